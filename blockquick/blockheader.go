@@ -4,6 +4,7 @@
 package blockquick
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -46,6 +47,46 @@ func NewHeader(txHash []byte, stateHash []byte, prevBlock []byte, minerSig []byt
 	return
 }
 
+// jsonHeader is the json encoding of BlockHeader, unlike Serialize it keeps
+// the miner public key
+type jsonHeader struct {
+	TxHash      []byte `json:"tx_hash"`
+	StateHash   []byte `json:"state_hash"`
+	PrevBlock   []byte `json:"prev_block"`
+	MinerSig    []byte `json:"miner_sig"`
+	MinerPubkey []byte `json:"miner_pubkey"`
+	Timestamp   uint64 `json:"timestamp"`
+	Number      uint64 `json:"number"`
+	Nonce       uint64 `json:"nonce"`
+}
+
+// MarshalJSON returns the json encoding of the block header
+func (bh BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonHeader{
+		TxHash:      bh.txHash,
+		StateHash:   bh.stateHash,
+		PrevBlock:   bh.prevBlock,
+		MinerSig:    bh.minerSig,
+		MinerPubkey: bh.minerPubkey,
+		Timestamp:   bh.timestamp,
+		Number:      bh.number,
+		Nonce:       bh.nonce,
+	})
+}
+
+// UnmarshalJSON decodes a block header and validates its miner signature
+func (bh *BlockHeader) UnmarshalJSON(data []byte) (err error) {
+	var h jsonHeader
+	if err = json.Unmarshal(data, &h); err != nil {
+		return
+	}
+	if len(h.MinerSig) < 65 {
+		return fmt.Errorf("invalid miner signature of block %d", h.Number)
+	}
+	*bh, err = NewHeader(h.TxHash, h.StateHash, h.PrevBlock, h.MinerSig, h.MinerPubkey, h.Timestamp, h.Number, h.Nonce)
+	return
+}
+
 // Serialize returns a serialized version
 func (bh *BlockHeader) Serialize() ([]byte, error) {
 	data, err := bert.Encode([7]bert.Term{
@@ -89,6 +130,11 @@ func (bh *BlockHeader) Parent() (hash Sha3) {
 	return
 }
 
+// StateHash returns the root hash of the state of the block
+func (bh *BlockHeader) StateHash() []byte {
+	return bh.stateHash
+}
+
 // Number returns the block number
 func (bh *BlockHeader) Number() uint64 {
 	return bh.number
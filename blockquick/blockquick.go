@@ -107,6 +107,23 @@ func (win *Window) lastFinal() *BlockScore {
 	return win.finals[len(win.finals)-1]
 }
 
+// Headers returns the finalized block headers of the window, the oldest
+// first and the header of Last at the end
+func (win *Window) Headers() []BlockHeader {
+	win.mx.Lock()
+	defer win.mx.Unlock()
+
+	finals := win.finals
+	if len(finals) > win.windowSize {
+		finals = finals[len(finals)-win.windowSize:]
+	}
+	bhs := make([]BlockHeader, 0, len(finals))
+	for _, bs := range finals {
+		bhs = append(bhs, bs.bh)
+	}
+	return bhs
+}
+
 // NeedsUpdate informs whether the window needs to be reinitialized
 func (win *Window) NeedsUpdate() bool {
 	win.mx.Lock()
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
@@ -24,14 +25,12 @@ import (
 	"github.com/diodechain/diode_client/db"
 	"github.com/diodechain/diode_client/rpc"
 	"github.com/diodechain/diode_client/util"
-	"gopkg.in/yaml.v2"
 )
 
 var (
 	diodeCmd = command.Command{
 		Name:     "diode",
 		HelpText: " Diode network command line interface",
-		PreRun:   prepareDiode,
 		PostRun:  cleanDiode,
 	}
 	bootDiodeAddrs = [6]string{
@@ -45,6 +44,9 @@ var (
 )
 
 func init() {
+	// the handlers read their own command, so they are set in init() to avoid
+	// an initialization cycle
+	diodeCmd.PreRun = prepareDiode
 	cfg := &config.Config{}
 	diodeCmd.Flag.StringVar(&cfg.DBPath, "dbpath", util.DefaultDBPath(), "file path to db file")
 	diodeCmd.Flag.IntVar(&cfg.RetryTimes, "retrytimes", 3, "retry times to connect the remote rpc server")
@@ -59,6 +61,10 @@ func init() {
 	diodeCmd.Flag.StringVar(&cfg.LogFilePath, "logfilepath", "", "absolute path to the log file")
 	diodeCmd.Flag.BoolVar(&cfg.LogDateTime, "logdatetime", false, "show the date time in log")
 	diodeCmd.Flag.StringVar(&cfg.ConfigFilePath, "configpath", "", "yaml file path to config file")
+	diodeCmd.Flag.StringVar(&cfg.Passphrase, "passphrase", "", "passphrase to unlock an encrypted private key (can also be set with DIODE_PASSPHRASE)")
+	diodeCmd.Flag.StringVar(&cfg.Identity, "identity", "", "name of the identity to use (default: the identity selected with 'diode identity use')")
+	diodeCmd.Flag.StringVar(&cfg.Checkpoint, "checkpoint", "", "trusted block to start the validation from, a file of 'diode checkpoint export' or <block_number>:<block_hash>")
+	diodeCmd.Flag.StringVar(&cfg.CheckpointSigner, "checkpointsigner", "", "address that has to have signed off the file of -checkpoint")
 	diodeCmd.Flag.StringVar(&cfg.CPUProfile, "cpuprofile", "", "file path for cpu profiling")
 	// diodeCmd.Flag.IntVar(&cfg.CPUProfileRate, "cpuprofilerate", 100, "the CPU profiling rate to hz samples per second")
 	diodeCmd.Flag.StringVar(&cfg.MEMProfile, "memprofile", "", "file path for memory profiling")
@@ -73,39 +79,53 @@ func init() {
 
 	diodeCmd.Flag.DurationVar(&cfg.RemoteRPCTimeout, "timeout", 5*time.Second, "timeout seconds to connect to the remote rpc server")
 	diodeCmd.Flag.DurationVar(&cfg.RetryWait, "retrywait", 1*time.Second, "wait seconds before next retry")
+	diodeCmd.Flag.DurationVar(&cfg.ReconnectMin, "reconnectmin", 1*time.Second, "minimum wait before reconnecting to the network, doubles after every failed attempt")
+	diodeCmd.Flag.DurationVar(&cfg.ReconnectMax, "reconnectmax", 5*time.Minute, "maximum wait before reconnecting to the network")
+	diodeCmd.Flag.IntVar(&cfg.TicketBytes, "ticketbytes", 4194304, "sign a new ticket after this many bytes of traffic")
+	diodeCmd.Flag.IntVar(&cfg.TicketConnections, "ticketconnections", 0, "sign a new ticket after this many connections, 0 to disable")
+	diodeCmd.Flag.DurationVar(&cfg.TicketInterval, "ticketinterval", 0, "sign the unsent traffic after this time, 0 to disable")
+	diodeCmd.Flag.StringVar(&cfg.TicketMode, "ticketmode", rpc.EagerTicketMode, "ticket signing mode: eager signs at every threshold, lazy signs only at -ticketmaxunsent")
+	diodeCmd.Flag.IntVar(&cfg.TicketMaxUnsent, "ticketmaxunsent", 67108864, "bytes of traffic that are never exceeded without signing a new ticket, 0 to disable")
 	diodeCmd.Flag.Var(&cfg.RemoteRPCAddrs, "diodeaddrs", "addresses of Diode node server (default: asia.prenet.diode.io:41046, europe.prenet.diode.io:41046, usa.prenet.diode.io:41046)")
-	diodeCmd.Flag.Var(&cfg.SBlocklists, "blocklists", "addresses are not allowed to connect to published resource (worked when allowlists is empty)")
-	diodeCmd.Flag.Var(&cfg.SAllowlists, "allowlists", "addresses are allowed to connect to published resource (worked when blocklists is empty)")
+	diodeCmd.Flag.IntVar(&cfg.RelayPoolSize, "relays", 5, "number of relays to connect to")
+	diodeCmd.Flag.StringVar(&cfg.RelayPolicy, "relaypolicy", rpc.LowestLatencyPolicy, "relay selection policy: lowest-latency, sticky-primary or random")
+	diodeCmd.Flag.Var(&cfg.PinnedRelays, "pinrelays", "addresses of relays that are always connected. -pinrelays <host>:<port>")
+	diodeCmd.Flag.Var(&cfg.ExcludedRelays, "excluderelays", "addresses or node ids of relays that are never used. -excluderelays <host>:<port>|<node_id>")
+	diodeCmd.Flag.StringVar(&cfg.RelayRegion, "relayregion", "", "prefer the relays of this region, e.g. eu, us or as")
+	diodeCmd.Flag.DurationVar(&cfg.RelayHysteresis, "relayhysteresis", 20*time.Millisecond, "latency improvement that is needed to switch the primary relay")
+	diodeCmd.Flag.Var(&cfg.SBlocklists, "blocklists", "addresses are not allowed to connect to published resource (worked when allowlists is empty), also read from blocklists in the config file and applied on reload")
+	diodeCmd.Flag.Var(&cfg.SAllowlists, "allowlists", "addresses are allowed to connect to published resource (worked when blocklists is empty), also read from allowlists in the config file and applied on reload")
 	diodeCmd.Flag.Var(&cfg.SBinds, "bind", "bind a remote port to a local port. -bind <local_port>:<to_address>:<to_port>:(udp|tcp)")
 	config.AppConfig = cfg
 	// Add diode commands
 	diodeCmd.AddSubCommand(bnsCmd)
+	diodeCmd.AddSubCommand(checkpointCmd)
 	diodeCmd.AddSubCommand(configCmd)
 	diodeCmd.AddSubCommand(fetchCmd)
 	diodeCmd.AddSubCommand(gatewayCmd)
+	diodeCmd.AddSubCommand(identityCmd)
 	diodeCmd.AddSubCommand(publishCmd)
 	diodeCmd.AddSubCommand(resetCmd)
 	diodeCmd.AddSubCommand(socksdCmd)
+	diodeCmd.AddSubCommand(ticketsCmd)
 	diodeCmd.AddSubCommand(timeCmd)
 	diodeCmd.AddSubCommand(tokenCmd)
 	diodeCmd.AddSubCommand(versionCmd)
 	diodeCmd.AddSubCommand(updateCmd)
+	diodeCmd.AddSubCommand(verifyCmd)
 }
 
 func prepareDiode() error {
 	cfg := config.AppConfig
-
-	// load file config
-	if len(cfg.ConfigFilePath) > 0 {
-		cfgByts, err := config.LoadConfigFromFile(cfg.ConfigFilePath)
-		if err == nil {
-			err = yaml.Unmarshal(cfgByts, cfg)
-			if err == nil {
-				cfg.LoadFromFile = true
-			}
-		}
+	cmd := diodeCmd.SubCommand()
+	if cmd != nil {
+		cfg.Command = cmd.Name
 	}
 
+	// keep the command line values to reload the config file on top of them
+	flagConfig = *cfg
+	_, loadErr := loadConfig(cfg, &flagConfig, cmd)
+
 	if len(cfg.LogFilePath) > 0 {
 		cfg.LogMode = config.LogToFile
 	} else {
@@ -120,6 +140,11 @@ func prepareDiode() error {
 		return err
 	}
 
+	if loadErr != nil {
+		cfg.PrintError("Couldn't load config", loadErr)
+		return loadErr
+	}
+
 	cfg.PrintLabel("Diode Client version", fmt.Sprintf("%s %s", version, buildTime))
 
 	if len(cfg.RemoteRPCAddrs) == 0 {
@@ -138,6 +163,12 @@ func prepareDiode() error {
 			cfg.RemoteRPCAddrs = remoteRPCAddrs
 		}
 	}
+	if err := checkRelaySettings(cfg); err != nil {
+		return err
+	}
+	if err := checkTicketSettings(cfg); err != nil {
+		return err
+	}
 	rand.Seed(time.Now().Unix())
 	rand.Shuffle(len(cfg.RemoteRPCAddrs), func(i, j int) {
 		cfg.RemoteRPCAddrs[i], cfg.RemoteRPCAddrs[j] = cfg.RemoteRPCAddrs[j], cfg.RemoteRPCAddrs[i]
@@ -152,6 +183,22 @@ func prepareDiode() error {
 		cfg.Binds = append(cfg.Binds, *bind)
 	}
 
+	allowlists, err := parseAccessList(cfg.SAllowlists)
+	if err != nil {
+		return err
+	}
+	blocklists, err := parseAccessList(cfg.SBlocklists)
+	if err != nil {
+		return err
+	}
+	cfg.AccessLists = config.NewAccessLists(allowlists, blocklists)
+	if cfg.LoadFromFile {
+		// SaveToFile only writes the values that are changed from now on
+		if err = cfg.TrackChanges(); err != nil {
+			return err
+		}
+	}
+
 	// initialize diode application
 	app = NewDiode(cfg)
 	if err := app.Init(); err != nil {
@@ -160,6 +207,48 @@ func prepareDiode() error {
 	return nil
 }
 
+// checkRelaySettings validates the relay selection flags
+func checkRelaySettings(cfg *config.Config) error {
+	if err := rpc.ValidRelayPolicy(cfg.RelayPolicy); err != nil {
+		return err
+	}
+	if cfg.RelayPoolSize < 1 {
+		return fmt.Errorf("relays should be at least 1")
+	}
+	for _, host := range cfg.PinnedRelays {
+		if !isValidRPCAddress(host) {
+			return fmt.Errorf("pinned relay %s should be <host>:<port>", host)
+		}
+	}
+	if cfg.RelayHysteresis < 0 {
+		return fmt.Errorf("relayhysteresis shouldn't be negative")
+	}
+	if cfg.ReconnectMin <= 0 {
+		return fmt.Errorf("reconnectmin should be positive")
+	}
+	if cfg.ReconnectMax < cfg.ReconnectMin {
+		return fmt.Errorf("reconnectmax should be at least reconnectmin")
+	}
+	return nil
+}
+
+// checkTicketSettings validates the ticket policy flags
+func checkTicketSettings(cfg *config.Config) error {
+	if err := rpc.ValidTicketMode(cfg.TicketMode); err != nil {
+		return err
+	}
+	if cfg.TicketBytes < 0 || cfg.TicketConnections < 0 || cfg.TicketMaxUnsent < 0 {
+		return fmt.Errorf("ticketbytes, ticketconnections and ticketmaxunsent shouldn't be negative")
+	}
+	if cfg.TicketInterval < 0 {
+		return fmt.Errorf("ticketinterval shouldn't be negative")
+	}
+	if cfg.TicketMode == rpc.LazyTicketMode && cfg.TicketMaxUnsent == 0 {
+		return fmt.Errorf("ticketmode lazy needs ticketmaxunsent")
+	}
+	return nil
+}
+
 func isValidRPCAddress(address string) (isValid bool) {
 	_, _, err := net.SplitHostPort(address)
 	if err == nil {
@@ -185,6 +274,7 @@ type Diode struct {
 	deferals        []func()
 	closeCh         chan struct{}
 	cmd             *command.Command
+	reloader        *configReloader
 }
 
 // NewDiode return diode application
@@ -201,14 +291,36 @@ func (dio *Diode) Init() error {
 	// Connect to first server to respond, and keep the other connections opened
 	cfg := dio.config
 
+	// -verify and -repair check the database instead of opening it,
+	// because it might be damaged
+	if cfg.ConfigVerify || cfg.ConfigRepair {
+		return checkDatabase(cfg)
+	}
+
 	// Initialize db
 	clidb, err := db.OpenFile(cfg.DBPath)
 	if err != nil {
 		cfg.PrintError("Couldn't open database", err)
+		if _, ok := err.(db.CorruptionError); ok {
+			cfg.PrintInfo("Run 'diode config -verify' to inspect and 'diode config -repair' to recover the database")
+		}
 		return err
 	}
 	db.DB = clidb
 
+	if len(cfg.Identity) == 0 {
+		cfg.Identity = db.DB.CurrentIdentity()
+	} else if !db.DB.HasIdentity(cfg.Identity) {
+		err = fmt.Errorf("identity %s not found, create it with 'diode identity create %s'", cfg.Identity, cfg.Identity)
+		cfg.PrintError("Couldn't load identity", err)
+		return err
+	}
+
+	if err = unlockDatabase(cfg); err != nil {
+		cfg.PrintError("Couldn't unlock database", err)
+		return err
+	}
+
 	if version != "development" && cfg.EnableUpdate {
 		var lastUpdateAtByt []byte
 		var lastUpdateAt time.Time
@@ -320,15 +432,21 @@ func (dio *Diode) Init() error {
 
 		cfg.ClientAddr = util.PubkeyToAddress(rpc.LoadClientPubKey())
 
-		if !cfg.LoadFromFile {
-			fleetAddr, err := db.DB.Get("fleet")
+		if len(cfg.SFleetAddr) > 0 {
+			fleetAddr, err := util.DecodeAddress(cfg.SFleetAddr)
+			if err != nil {
+				return fmt.Errorf("invalid fleet address in config file: %v", err)
+			}
+			cfg.FleetAddr = fleetAddr
+		} else if !cfg.LoadFromFile {
+			fleetAddr, err := db.DB.Get(db.IdentityKey(cfg.Identity, "fleet"))
 			if err != nil {
 				// Migration if existing
 				fleetAddr, err = db.DB.Get("fleet_id")
 				if err == nil {
 					cfg.FleetAddr, err = util.DecodeAddress(string(fleetAddr))
 					if err == nil {
-						db.DB.Put("fleet", cfg.FleetAddr[:])
+						db.DB.Put(db.IdentityKey(cfg.Identity, "fleet"), cfg.FleetAddr[:])
 						db.DB.Del("fleet_id")
 					}
 				}
@@ -354,6 +472,11 @@ func (dio *Diode) Start() error {
 	}
 	cfg.PrintLabel("Client address", cfg.ClientAddr.HexString())
 	cfg.PrintLabel("Fleet address", cfg.FleetAddr.HexString())
+	if len(cfg.Checkpoint) > 0 {
+		if err := pinCheckpoint(cfg); err != nil {
+			return err
+		}
+	}
 	dio.clientManager.Start()
 
 	if dio.cmd.Type == command.EmptyConnectionCommand {
@@ -371,6 +494,7 @@ func (dio *Diode) Start() error {
 	var client *rpc.Client
 
 	// waiting for first client
+	backoff := rpc.NewReconnectBackoff(cfg)
 	for {
 		client = dio.WaitForFirstClient(onlyNeedOne)
 
@@ -378,8 +502,10 @@ func (dio *Diode) Start() error {
 			break
 		}
 
-		cfg.Logger.Info("Could not connect to network trying again in 5 seconds")
-		time.Sleep(5 * time.Second)
+		wait := backoff.Duration()
+		rpc.CountReconnect()
+		cfg.Logger.Info("Could not connect to network trying again in %s (attempt %d)", wait, int(backoff.Attempt()))
+		time.Sleep(wait)
 	}
 
 	if client == nil {
@@ -389,7 +515,7 @@ func (dio *Diode) Start() error {
 	}
 	lvbn, lvbh = client.LastValid()
 	cfg.Logger.Info("Network is validated, last valid block: %d 0x%x", lvbn, lvbh)
-	name, err := client.ResolveReverseBNS(cfg.ClientAddr)
+	name, err := client.ResolveReverseBNS(context.Background(), cfg.ClientAddr)
 	if err == nil {
 		cfg.PrintLabel("Client name", fmt.Sprintf("%s.diode", name))
 		cfg.ClientName = name
@@ -420,18 +546,21 @@ func (dio *Diode) SetConfigAPIServer(configAPIServer *ConfigAPIServer) {
 	dio.configAPIServer = configAPIServer
 }
 
-// Wait till user signal int to diode application
+// Wait till user signal int to diode application, SIGHUP reloads the config file
 func (dio *Diode) Wait() {
-	// go func() {
 	// listen to signal
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT)
-	sig := <-sigChan
-	switch sig {
-	case syscall.SIGINT:
-		dio.Close()
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+	for sig := range sigChan {
+		switch sig {
+		case syscall.SIGHUP:
+			dio.ReloadConfig()
+		case syscall.SIGINT:
+			dio.Close()
+			return
+		}
 	}
-	// }()
 }
 
 // Closed returns the whether diode application has been closed
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -55,7 +56,7 @@ func bnsHandler() (err error) {
 	}
 	client := app.clientManager.GetNearestClient()
 	// register bns record
-	bn, _ := client.GetBlockPeak()
+	bn, _ := client.GetBlockPeak(context.Background())
 	if bn == 0 {
 		cfg.PrintError("Cannot find block peak: ", fmt.Errorf("not found"))
 		return
@@ -94,7 +95,7 @@ func handleLookup() (done bool, err error) {
 	var obnsAddr []util.Address
 	var ownerAddr util.Address
 	client := app.clientManager.GetNearestClient()
-	obnsAddr, err = client.ResolveBNS(lookupName)
+	obnsAddr, err = client.ResolveBNS(context.Background(), lookupName)
 	if err != nil {
 		cfg.PrintError("Lookup error: ", err)
 		return
@@ -102,7 +103,7 @@ func handleLookup() (done bool, err error) {
 	for _, addr := range obnsAddr {
 		cfg.PrintLabel("Lookup result: ", fmt.Sprintf("%s=0x%s", lookupName, addr.Hex()))
 	}
-	ownerAddr, err = client.ResolveBNSOwner(lookupName)
+	ownerAddr, err = client.ResolveBNSOwner(context.Background(), lookupName)
 	if err != nil {
 		cfg.PrintError("Couldn't lookup owner: ", err)
 		return
@@ -122,7 +123,7 @@ func handleLookupAccount() (done bool, err error) {
 	var obnsAddr []util.Address
 	var ownerAddr util.Address
 	client := app.clientManager.GetNearestClient()
-	obnsAddr, err = client.ResolveBNS(lookupName)
+	obnsAddr, err = client.ResolveBNS(context.Background(), lookupName)
 	if err != nil {
 		cfg.PrintError("Lookup error: ", err)
 		return
@@ -130,7 +131,7 @@ func handleLookupAccount() (done bool, err error) {
 	for _, addr := range obnsAddr {
 		cfg.PrintLabel("Lookup result: ", fmt.Sprintf("%s=0x%s", lookupName, addr.Hex()))
 		lvbn, _ := client.LastValid()
-		account, err := client.GetValidAccount(lvbn, addr)
+		account, err := client.GetValidAccount(context.Background(), lvbn, addr)
 		if err != nil {
 			cfg.PrintError("Couldn't lookup the account: ", err)
 		}
@@ -138,7 +139,7 @@ func handleLookupAccount() (done bool, err error) {
 		cfg.PrintLabel("Code: ", util.EncodeToString(account.Code))
 		cfg.PrintLabel("Balance: ", fmt.Sprintf("%d (wei)", account.Balance))
 	}
-	ownerAddr, err = client.ResolveBNSOwner(lookupName)
+	ownerAddr, err = client.ResolveBNSOwner(context.Background(), lookupName)
 	if err != nil {
 		cfg.PrintError("Couldn't lookup owner: ", err)
 		return
@@ -172,7 +173,7 @@ func handleRegister() (done bool, err error) {
 		cfg.PrintError("Argument Error: ", fmt.Errorf("BNS name should be more than 7 or less than 32 characters (0-9A-Za-z-)"))
 		return
 	}
-	nonce := client.GetAccountNonce(0, cfg.ClientAddr)
+	nonce := client.GetAccountNonce(context.Background(), 0, cfg.ClientAddr)
 	var bnsAddr []util.Address
 	if len(registerPair) > 1 {
 		for _, strAddr := range strings.Split(registerPair[1], ",") {
@@ -188,7 +189,7 @@ func handleRegister() (done bool, err error) {
 		bnsAddr = append(bnsAddr, cfg.ClientAddr)
 	}
 	// check bns
-	obnsAddr, err = client.ResolveBNS(bnsName)
+	obnsAddr, err = client.ResolveBNS(context.Background(), bnsName)
 	if err == nil && len(obnsAddr) == len(bnsAddr) {
 		if util.Equal(obnsAddr, bnsAddr) && !cfg.BNSForce {
 			cfg.PrintError("BNS name is already mapped to this address", fmt.Errorf("ignored"))
@@ -199,7 +200,7 @@ func handleRegister() (done bool, err error) {
 	// send register transaction
 	registerData, _ := bnsContract.Register(bnsName, bnsAddr)
 	ntx := edge.NewTransaction(nonce, 0, 10000000, contract.BNSAddr, 0, registerData, 0)
-	_, err = client.SendTransaction(ntx)
+	_, err = client.SendTransaction(context.Background(), ntx)
 	if err != nil {
 		cfg.PrintError("Cannot register with blockchain name service: ", err)
 		return
@@ -214,7 +215,7 @@ func handleRegister() (done bool, err error) {
 		for _, addr := range bnsAddr {
 			registerData, _ := bnsContract.RegisterReverse(addr, bnsName)
 			ntx := edge.NewTransaction(nonce, 0, 10000000, contract.BNSAddr, 0, registerData, 0)
-			_, err = client.SendTransaction(ntx)
+			_, err = client.SendTransaction(context.Background(), ntx)
 			if err != nil {
 				cfg.PrintError("Cannot register reverse name entry: ", err)
 				return
@@ -225,7 +226,7 @@ func handleRegister() (done bool, err error) {
 
 	}
 	wait(client, func() bool {
-		current, err := client.ResolveBNS(bnsName)
+		current, err := client.ResolveBNS(context.Background(), bnsName)
 		return err == nil && util.Equal(current, bnsAddr)
 	})
 	return
@@ -254,7 +255,7 @@ func handleTransfer() (done bool, err error) {
 		cfg.PrintError("Argument Error: ", fmt.Errorf("BNS name should be more than 7 or less than 32 characters (0-9A-Za-z-)"))
 		return
 	}
-	nonce := client.GetAccountNonce(0, cfg.ClientAddr)
+	nonce := client.GetAccountNonce(context.Background(), 0, cfg.ClientAddr)
 	var newOwner util.Address
 
 	newOwner, err = util.DecodeAddress(transferPair[1])
@@ -265,7 +266,7 @@ func handleTransfer() (done bool, err error) {
 
 	// check bns
 	var owner rpc.Address
-	owner, err = client.ResolveBNSOwner(bnsName)
+	owner, err = client.ResolveBNSOwner(context.Background(), bnsName)
 	if err == nil {
 		if owner == newOwner {
 			err = fmt.Errorf("domain is already owned by %v", owner.HexString())
@@ -282,14 +283,14 @@ func handleTransfer() (done bool, err error) {
 	// send register transaction
 	registerData, _ := bnsContract.Transfer(bnsName, newOwner)
 	ntx := edge.NewTransaction(nonce, 0, 10000000, contract.BNSAddr, 0, registerData, 0)
-	_, err = client.SendTransaction(ntx)
+	_, err = client.SendTransaction(context.Background(), ntx)
 	if err != nil {
 		cfg.PrintError("Cannot transfer blockchain name: ", err)
 		return
 	}
 	cfg.PrintLabel("Transferring bns: ", fmt.Sprintf("%s=%s", bnsName, newOwner.HexString()))
 	wait(client, func() bool {
-		current, err := client.ResolveBNSOwner(bnsName)
+		current, err := client.ResolveBNSOwner(context.Background(), bnsName)
 		return err == nil && current == newOwner
 	})
 	return
@@ -316,11 +317,11 @@ func handleUnregister() (done bool, err error) {
 		cfg.PrintError("Argument Error: ", fmt.Errorf("BNS name should be more than 7 or less than 32 characters (0-9A-Za-z-)"))
 		return
 	}
-	nonce := client.GetAccountNonce(0, cfg.ClientAddr)
+	nonce := client.GetAccountNonce(context.Background(), 0, cfg.ClientAddr)
 
 	// check bns
 	var owner rpc.Address
-	owner, _ = client.ResolveBNSOwner(bnsName)
+	owner, _ = client.ResolveBNSOwner(context.Background(), bnsName)
 	if owner == [20]byte{} {
 		err = fmt.Errorf("BNS name is already free")
 		return
@@ -333,14 +334,14 @@ func handleUnregister() (done bool, err error) {
 	// send register transaction
 	registerData, _ := bnsContract.Unregister(bnsName)
 	ntx := edge.NewTransaction(nonce, 0, 10000000, contract.BNSAddr, 0, registerData, 0)
-	_, err = client.SendTransaction(ntx)
+	_, err = client.SendTransaction(context.Background(), ntx)
 	if err != nil {
 		cfg.PrintError("Cannot unregister blockchain name: ", err)
 		return
 	}
 	cfg.PrintLabel("Unregistering bns: ", bnsName)
 	wait(client, func() bool {
-		owner, _ := client.ResolveBNSOwner(bnsName)
+		owner, _ := client.ResolveBNSOwner(context.Background(), bnsName)
 		return owner == [20]byte{}
 	})
 	return
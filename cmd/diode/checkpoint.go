@@ -0,0 +1,139 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/diodechain/diode_client/command"
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
+)
+
+var (
+	checkpointCmd = &command.Command{
+		Name:        "checkpoint",
+		HelpText:    `  Export or import the trusted block and window of headers that the blockquick validation starts from. Usage: diode checkpoint export [<file>]|import -signedby <address> [-force] <file>`,
+		ExampleText: `  diode checkpoint export anchor.json && diode checkpoint import -signedby 0x1234 anchor.json`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	checkpointSigner         string
+	checkpointForce          bool
+	errMissingCheckpointFile = fmt.Errorf("expected a checkpoint file")
+)
+
+func init() {
+	checkpointCmd.Run = checkpointHandler
+	checkpointCmd.Flag.StringVar(&checkpointSigner, "signedby", "", "address that has to have signed off the imported checkpoint")
+	checkpointCmd.Flag.BoolVar(&checkpointForce, "force", false, "import a checkpoint that is older than the trusted block")
+}
+
+func checkpointHandler() (err error) {
+	cfg := config.AppConfig
+	action, file, err := parseCheckpointArgs(checkpointCmd.Flag.Args())
+	if err != nil {
+		cfg.PrintError("Couldn't run checkpoint command", err)
+		return
+	}
+	switch action {
+	case "export":
+		err = exportCheckpoint(cfg, file)
+	case "import":
+		err = importCheckpoint(cfg, file)
+	default:
+		err = fmt.Errorf("unknown checkpoint action '%s', expected export or import", action)
+	}
+	if err != nil {
+		cfg.PrintError("Couldn't run checkpoint command", err)
+	}
+	return
+}
+
+// parseCheckpointArgs returns the action and the file, the flags may follow
+// them, the flag package stops parsing at the first positional argument
+func parseCheckpointArgs(args []string) (action string, file string, err error) {
+	var positional []string
+	for len(args) > 0 {
+		positional = append(positional, args[0])
+		if err = checkpointCmd.Flag.Parse(args[1:]); err != nil {
+			return
+		}
+		args = checkpointCmd.Flag.Args()
+	}
+	if len(positional) > 2 {
+		err = fmt.Errorf("unexpected arguments %s", strings.Join(positional[2:], " "))
+		return
+	}
+	if len(positional) > 0 {
+		action = positional[0]
+	}
+	if len(positional) > 1 {
+		file = positional[1]
+	}
+	return
+}
+
+func exportCheckpoint(cfg *config.Config, file string) error {
+	cp, err := rpc.ExportCheckpoint()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(file) == 0 {
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+		return err
+	}
+	if err = ioutil.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	if len(cp.Headers) == 0 {
+		cfg.PrintInfo("The window of headers isn't cached yet, only the block was exported")
+	}
+	cfg.PrintLabel("Exported block", fmt.Sprintf("%d %s", cp.BlockNumber, cp.BlockHash))
+	return nil
+}
+
+func importCheckpoint(cfg *config.Config, file string) error {
+	if len(file) == 0 {
+		return errMissingCheckpointFile
+	}
+	cp, err := rpc.LoadCheckpoint(file, checkpointSigner)
+	if err != nil {
+		return err
+	}
+	signer, err := cp.Verify()
+	if err != nil {
+		return err
+	}
+	if err = cp.Import(checkpointForce); err != nil {
+		return err
+	}
+	cfg.PrintLabel("Signed off by", signer.HexString())
+	cfg.PrintLabel("Imported block", fmt.Sprintf("%d %s", cp.BlockNumber, cp.BlockHash))
+	return nil
+}
+
+// pinCheckpoint starts the validation from the checkpoint of the -checkpoint
+// flag, a checkpoint file has to be signed off by -checkpointsigner
+func pinCheckpoint(cfg *config.Config) error {
+	cp, err := rpc.ParseCheckpoint(cfg.Checkpoint, cfg.CheckpointSigner)
+	if err != nil {
+		return err
+	}
+	pinned, err := cp.Pin()
+	if err != nil {
+		return err
+	}
+	if pinned {
+		cfg.PrintLabel("Pinned checkpoint", fmt.Sprintf("%d %s", cp.BlockNumber, cp.BlockHash))
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"crypto/ecdsa"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sort"
 	"strings"
 
@@ -14,33 +16,58 @@ import (
 	"github.com/diodechain/diode_client/config"
 	"github.com/diodechain/diode_client/crypto"
 	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/rpc"
 	"github.com/diodechain/diode_client/util"
 )
 
 var (
 	configCmd = &command.Command{
 		Name:        "config",
-		HelpText:    `  Manage variables in the local config store.`,
-		ExampleText: `  diode config -delete lvbn2 -delete lvbn`,
-		Run:         configHandler,
+		HelpText:    `  Manage variables in the local config store. Run 'diode config validate <file>' to check a yaml config file.`,
+		ExampleText: `  diode config -delete lvbn2 -delete lvbn && diode config validate /etc/diode/diode.yml && diode config -effective gateway`,
 		Type:        command.EmptyConnectionCommand,
 	}
 )
 
 func init() {
 	cfg := config.AppConfig
+	configCmd.Run = configHandler
 	configCmd.Flag.Var(&cfg.ConfigDelete, "delete", "deletes the given variable from the config")
 	configCmd.Flag.BoolVar(&cfg.ConfigList, "list", false, "list all stored config keys")
 	configCmd.Flag.BoolVar(&cfg.ConfigUnsafe, "unsafe", false, "display private keys (disabled by default)")
 	configCmd.Flag.Var(&cfg.ConfigSet, "set", "sets the given variable in the config")
+	configCmd.Flag.BoolVar(&cfg.ConfigEncrypt, "encrypt", false, "encrypt the private key with a passphrase")
+	configCmd.Flag.BoolVar(&cfg.ConfigDecrypt, "decrypt", false, "store the private key without passphrase protection")
+	configCmd.Flag.BoolVar(&cfg.ConfigVerify, "verify", false, "check the database for damaged records")
+	configCmd.Flag.BoolVar(&cfg.ConfigRepair, "repair", false, "recover all intact records of a damaged database")
+	configCmd.Flag.StringVar(&cfg.ConfigExportKey, "export-key", "", "export the private key to the given V3 keystore json file")
+	configCmd.Flag.StringVar(&cfg.ConfigImportKey, "import-key", "", "import the private key from the given V3 keystore json file")
+	configCmd.Flag.BoolVar(&cfg.ConfigForce, "force", false, "allow -import-key to replace the existing private key")
+	configCmd.Flag.BoolVar(&cfg.ConfigEffective, "effective", false, "print the config of a command (default: publish) and whether each value came from the defaults, the config file, DIODE_* environment variables or flags")
 }
 
 func configHandler() (err error) {
+	cfg := config.AppConfig
+	if cfg.ConfigVerify || cfg.ConfigRepair {
+		// the database was already checked in Init
+		return nil
+	}
+	args := configCmd.Flag.Args()
+	if cfg.ConfigEffective {
+		return effectiveConfig(cfg, args)
+	}
+	if len(args) > 0 {
+		if args[0] != "validate" || len(args) != 2 {
+			err = fmt.Errorf("expected 'diode config validate <file>' but got: %s", strings.Join(args, " "))
+			cfg.PrintError("Couldn't run config command", err)
+			return
+		}
+		return validateConfigFile(cfg, args[1])
+	}
 	err = app.Start()
 	if err != nil {
 		return
 	}
-	cfg := config.AppConfig
 	activity := false
 	if len(cfg.ConfigDelete) > 0 {
 		activity = true
@@ -71,19 +98,67 @@ func configHandler() (err error) {
 		}
 	}
 
+	if cfg.ConfigEncrypt {
+		activity = true
+		if err = encryptPrivateKey(cfg); err != nil {
+			cfg.PrintError("Couldn't encrypt private key", err)
+			return
+		}
+		cfg.PrintLabel("Encrypted:", db.IdentityKey(cfg.Identity, "private"))
+	} else if cfg.ConfigDecrypt {
+		activity = true
+		if err = decryptPrivateKey(cfg); err != nil {
+			cfg.PrintError("Couldn't decrypt private key", err)
+			return
+		}
+		cfg.PrintLabel("Decrypted:", db.IdentityKey(cfg.Identity, "private"))
+	}
+
+	if len(cfg.ConfigExportKey) > 0 {
+		activity = true
+		if err = exportPrivateKey(cfg, cfg.ConfigExportKey); err != nil {
+			cfg.PrintError("Couldn't export private key", err)
+			return
+		}
+		cfg.PrintLabel("Exported:", cfg.ConfigExportKey)
+	}
+
+	if len(cfg.ConfigImportKey) > 0 {
+		activity = true
+		if err = importPrivateKey(cfg, cfg.ConfigImportKey); err != nil {
+			cfg.PrintError("Couldn't import private key", err)
+			return
+		}
+		cfg.PrintLabel("Imported:", cfg.ConfigImportKey)
+		cfg.PrintLabel("Client address", cfg.ClientAddr.HexString())
+	}
+
 	if cfg.ConfigList || !activity {
 		var value []byte
+		privateKey := db.IdentityKey(cfg.Identity, "private")
 		cfg.PrintLabel("<KEY>", "<VALUE>")
 		list := db.DB.List()
 		sort.Strings(list)
 		for _, name := range list {
+			if rpc.IsTicketKey(name) {
+				// the signed tickets are listed by 'diode tickets'
+				continue
+			}
 			label := "<********************************>"
 			value, err = db.DB.Get(name)
 			if err == nil {
-				if name == "private" {
+				if name == privateKey {
 					cfg.PrintLabel("<address>", cfg.ClientAddr.HexString())
+					if db.IsEncrypted(value) {
+						cfg.PrintLabel("<encrypted>", "true")
+					}
 
 					if cfg.ConfigUnsafe {
+						value, err = db.DB.GetSecret(name)
+						if err != nil {
+							cfg.PrintError("Couldn't decrypt private key", err)
+							return
+						}
 						var privKey *ecdsa.PrivateKey
 						block, _ := pem.Decode(value)
 						if block == nil {
@@ -97,7 +172,7 @@ func configHandler() (err error) {
 						}
 						label = util.EncodeToString(privKey.D.Bytes())
 					}
-				} else {
+				} else if !strings.HasSuffix(name, "/private") {
 					label = util.EncodeToString(value)
 				}
 			}
@@ -106,3 +181,172 @@ func configHandler() (err error) {
 	}
 	return
 }
+
+// effectiveConfig prints the layers of the config as they are resolved for the
+// given command
+func effectiveConfig(cfg *config.Config, args []string) error {
+	name := "publish"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	cmd := diodeCmd.FindSubCommand(name)
+	if cmd == nil {
+		err := fmt.Errorf("unknown command '%s'", name)
+		cfg.PrintError("Couldn't print effective config", err)
+		return err
+	}
+	effective := flagConfig
+	fields, err := loadConfig(&effective, &flagConfig, cmd)
+	if err != nil {
+		cfg.PrintError("Couldn't print effective config", err)
+		return err
+	}
+	cfg.PrintLabel("Command", name)
+	printEffectiveConfig(cfg, &effective, fields)
+	return nil
+}
+
+// encryptPrivateKey migrates a plaintext private key to the encrypted format
+func encryptPrivateKey(cfg *config.Config) error {
+	privateKey := db.IdentityKey(cfg.Identity, "private")
+	if db.DB.IsSecretEncrypted(privateKey) {
+		return fmt.Errorf("private key is already encrypted")
+	}
+	privKey, err := db.DB.GetSecret(privateKey)
+	if err != nil {
+		return err
+	}
+	passphrase, err := newPassphrase(cfg)
+	if err != nil {
+		return err
+	}
+	db.DB.SetPassphrase([]byte(passphrase))
+	if err = db.DB.PutSecret(privateKey, privKey); err != nil {
+		return err
+	}
+	// Rewrite the journal so that the plaintext key doesn't stay on disk
+	return db.DB.Compact()
+}
+
+// decryptPrivateKey stores the private key in plaintext again
+func decryptPrivateKey(cfg *config.Config) error {
+	privateKey := db.IdentityKey(cfg.Identity, "private")
+	if !db.DB.IsSecretEncrypted(privateKey) {
+		return fmt.Errorf("private key is not encrypted")
+	}
+	privKey, err := db.DB.GetSecret(privateKey)
+	if err != nil {
+		return err
+	}
+	db.DB.SetPassphrase(nil)
+	return db.DB.Put(privateKey, privKey)
+}
+
+// exportPrivateKey writes the private key as V3 keystore file
+func exportPrivateKey(cfg *config.Config, filename string) error {
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("file %s already exists", filename)
+	}
+	privKey, err := loadPrivateKey(cfg)
+	if err != nil {
+		return err
+	}
+	passphrase, err := newKeystorePassphrase()
+	if err != nil {
+		return err
+	}
+	keystore, err := crypto.EncryptKeyV3(privKey, passphrase)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, keystore, 0600)
+}
+
+// importPrivateKey replaces the private key with the key of a V3 keystore file
+func importPrivateKey(cfg *config.Config, filename string) error {
+	keystore, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readKeystorePassphrase("Keystore passphrase: ")
+	if err != nil {
+		return err
+	}
+	privKey, err := crypto.DecryptKeyV3(keystore, passphrase)
+	if err != nil {
+		return err
+	}
+	privateKey := db.IdentityKey(cfg.Identity, "private")
+	if old, err := loadPrivateKey(cfg); err == nil {
+		if old.D.Cmp(privKey.D) == 0 {
+			return nil
+		}
+		// the key that Init generated for a new identity wasn't used yet
+		if !cfg.ConfigForce && !rpc.IsGeneratedKey() {
+			return fmt.Errorf("this replaces the private key of %s, export it first and add -force to confirm", cfg.ClientAddr.HexString())
+		}
+	} else if err != db.ErrKeyNotFound {
+		return err
+	}
+	der, err := crypto.ECDSAToDer(privKey)
+	if err != nil {
+		return err
+	}
+	if err = db.DB.PutSecret(privateKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return err
+	}
+	cfg.ClientAddr = util.PubkeyToAddress(crypto.MarshalPubkey(&privKey.PublicKey))
+	// Rewrite the journal so that the replaced key doesn't stay on disk
+	return db.DB.Compact()
+}
+
+// loadPrivateKey reads the private key of the active identity
+func loadPrivateKey(cfg *config.Config) (*ecdsa.PrivateKey, error) {
+	value, err := db.DB.GetSecret(db.IdentityKey(cfg.Identity, "private"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(value)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem private key format")
+	}
+	return crypto.DerToECDSA(block.Bytes)
+}
+
+// checkDatabase verifies or repairs the database and prints what was lost
+func checkDatabase(cfg *config.Config) (err error) {
+	var report *db.Report
+	if cfg.ConfigRepair {
+		report, err = db.Repair(cfg.DBPath)
+	} else {
+		report, err = db.Verify(cfg.DBPath)
+	}
+	if err != nil {
+		cfg.PrintError("Couldn't check database", err)
+		return
+	}
+	cfg.PrintLabel("Database", report.Path)
+	cfg.PrintLabel("Format", report.Format)
+	cfg.PrintLabel("Intact records", fmt.Sprintf("%d", report.Records))
+	cfg.PrintLabel("Keys", strings.Join(report.Keys, ", "))
+	for _, loss := range report.Losses {
+		key := loss.Key
+		if len(key) == 0 {
+			key = "<unknown>"
+		}
+		cfg.PrintLabel("Damaged record", fmt.Sprintf("offset=%d size=%d key=%s reason=%s", loss.Offset, loss.Size, key, loss.Reason))
+	}
+	if report.Healthy() {
+		cfg.PrintInfo("No damaged records found")
+		return
+	}
+	for _, identity := range report.LostIdentities() {
+		cfg.PrintError(fmt.Sprintf("The private key of identity %s could not be recovered", identity), fmt.Errorf("a new key will be created when the identity is used next"))
+	}
+	if cfg.ConfigRepair {
+		cfg.PrintLabel("Backup of damaged database", report.Backup)
+	} else {
+		cfg.PrintInfo("Run 'diode config -repair' to recover the intact records")
+	}
+	return
+}
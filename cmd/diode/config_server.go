@@ -16,6 +16,7 @@ import (
 	"syscall"
 
 	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
 	"github.com/diodechain/diode_client/util"
 	"github.com/go-playground/validator"
 	"github.com/rs/cors"
@@ -30,6 +31,9 @@ type apiResponse struct {
 	Message string            `json:"message"`
 	Error   map[string]string `json:"error,omitempty"`
 	Config  *configEntry      `json:"config,omitempty"`
+	Relays  []rpc.RelayScore  `json:"relays,omitempty"`
+	// Consensus is the latest disagreement of the relays on the chain
+	Consensus *rpc.ConsensusAlert `json:"consensus_alert,omitempty"`
 }
 
 type configEntry struct {
@@ -511,6 +515,30 @@ func findExternPort(ports []string, externPort int) (index int) {
 	return -1
 }
 
+// relaysHandleFunc returns the health scores of the relays
+func (configAPIServer *ConfigAPIServer) relaysHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/relays" || req.Method != "GET" {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		var scores []rpc.RelayScore
+		var alert *rpc.ConsensusAlert
+		if app.clientManager != nil {
+			scores = app.clientManager.RelayScores()
+			alert = app.clientManager.ConsensusAlert()
+		}
+		res, _ := json.Marshal(&apiResponse{
+			Success:   true,
+			Message:   "ok",
+			Relays:    scores,
+			Consensus: alert,
+		})
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
 func (configAPIServer *ConfigAPIServer) rootHandleFunc() func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != "/" {
@@ -547,6 +575,7 @@ func (configAPIServer *ConfigAPIServer) requireJSON(h http.Handler) http.Handler
 func (configAPIServer *ConfigAPIServer) ListenAndServe() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/config", configAPIServer.apiHandleFunc())
+	mux.HandleFunc("/relays", configAPIServer.relaysHandleFunc())
 	mux.HandleFunc("/", configAPIServer.rootHandleFunc())
 	handler := cors.New(configAPIServer.corsOptions).Handler(mux)
 	handler = configAPIServer.requireJSON(handler)
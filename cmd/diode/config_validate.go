@@ -0,0 +1,168 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/util"
+	"gopkg.in/yaml.v3"
+)
+
+// validateConfigFile checks every value of the config file with the same
+// parsers that are used by the commands
+func validateConfigFile(cfg *config.Config, path string) error {
+	cfgBytes, err := config.LoadConfigFromFile(path)
+	if err != nil {
+		cfg.PrintError("Couldn't read config file", err)
+		return err
+	}
+	errs := checkConfigFile(cfgBytes)
+	if len(errs) == 0 {
+		cfg.PrintLabel("Valid config file", path)
+		return nil
+	}
+	for _, err := range errs {
+		cfg.PrintError("Invalid config file", err)
+	}
+	return fmt.Errorf("%s has %d invalid values", path, len(errs))
+}
+
+// checkConfigFile returns a *config.FieldError for each invalid value and the
+// *yaml.TypeError messages for unknown keys or values of the wrong type
+func checkConfigFile(cfgBytes []byte) (errs []error) {
+	var fileCfg config.Config
+	if err := fileCfg.DecodeFile(cfgBytes, true); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return []error{err}
+		}
+		// the other values have been decoded, so they can still be checked
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, fmt.Errorf("%s", msg))
+		}
+	}
+	check := func(field string, value string, err error) {
+		if err != nil {
+			errs = append(errs, &config.FieldError{Field: field, Value: value, Err: err})
+		}
+	}
+
+	if len(fileCfg.SFleetAddr) > 0 {
+		_, err := util.DecodeAddress(fileCfg.SFleetAddr)
+		check("fleet", fileCfg.SFleetAddr, err)
+	}
+	for i, addr := range fileCfg.RemoteRPCAddrs {
+		var err error
+		if !isValidRPCAddress(addr) {
+			err = fmt.Errorf("expected <host>:<port>")
+		}
+		check(fmt.Sprintf("diodeaddrs[%d]", i), addr, err)
+	}
+	if len(fileCfg.RelayPolicy) > 0 {
+		check("relaypolicy", fileCfg.RelayPolicy, rpc.ValidRelayPolicy(fileCfg.RelayPolicy))
+	}
+	if fileCfg.RelayPoolSize < 0 {
+		check("relays", strconv.Itoa(fileCfg.RelayPoolSize), fmt.Errorf("should be at least 1"))
+	}
+	if len(fileCfg.TicketMode) > 0 {
+		check("ticketmode", fileCfg.TicketMode, rpc.ValidTicketMode(fileCfg.TicketMode))
+	}
+	if fileCfg.TicketMaxUnsent < 0 {
+		check("ticketmaxunsent", strconv.Itoa(fileCfg.TicketMaxUnsent), fmt.Errorf("shouldn't be negative"))
+	}
+	if len(fileCfg.Checkpoint) > 0 {
+		_, err := rpc.ParseCheckpoint(fileCfg.Checkpoint, fileCfg.CheckpointSigner)
+		check("checkpoint", fileCfg.Checkpoint, err)
+	}
+	for i, addr := range fileCfg.PinnedRelays {
+		var err error
+		if !isValidRPCAddress(addr) {
+			err = fmt.Errorf("expected <host>:<port>")
+		}
+		check(fmt.Sprintf("pinrelays[%d]", i), addr, err)
+	}
+	for i, addr := range fileCfg.SAllowlists {
+		_, err := util.DecodeAddress(addr)
+		check(fmt.Sprintf("allowlists[%d]", i), addr, err)
+	}
+	for i, addr := range fileCfg.SBlocklists {
+		_, err := util.DecodeAddress(addr)
+		check(fmt.Sprintf("blocklists[%d]", i), addr, err)
+	}
+	for i, bind := range fileCfg.SBinds {
+		_, err := parseBind(bind)
+		check(fmt.Sprintf("bind[%d]", i), bind, err)
+	}
+
+	// published ports
+	portErrs := len(errs)
+	checkPorts := func(field string, ports []string, mode int) {
+		for i, port := range ports {
+			_, err := parsePorts([]string{port}, mode)
+			check(fmt.Sprintf("%s[%d]", field, i), port, err)
+		}
+	}
+	checkPorts("published_public_ports", fileCfg.PublicPublishedPorts, config.PublicPublishedMode)
+	checkPorts("published_protected_ports", fileCfg.ProtectedPublishedPorts, config.ProtectedPublishedMode)
+	checkPorts("published_private_ports", fileCfg.PrivatePublishedPorts, config.PrivatePublishedMode)
+	if s := fileCfg.Publish; s != nil {
+		checkPorts("publish.public", s.Public, config.PublicPublishedMode)
+		checkPorts("publish.protected", s.Protected, config.ProtectedPublishedMode)
+		checkPorts("publish.private", s.Private, config.PrivatePublishedMode)
+	}
+	if len(errs) == portErrs {
+		publishCfg := fileCfg
+		publishCfg.ApplySection("publish")
+		_, err := parsePublishedPorts(&publishCfg)
+		check("publish", "", err)
+	}
+
+	checkPort := func(field string, port *int) {
+		if port != nil && !util.IsPort(*port) {
+			check(field, strconv.Itoa(*port), fmt.Errorf("port number should be bigger than 1 and smaller than 65535"))
+		}
+	}
+	checkFallback := func(field string, fallback *string) {
+		if fallback != nil && *fallback != "localhost" && *fallback != "false" {
+			check(field, *fallback, fmt.Errorf("expected 'localhost' or 'false'"))
+		}
+	}
+	if s := fileCfg.Publish; s != nil {
+		checkPort("publish.proxy_port", s.ProxyPort)
+		checkPort("publish.http_port", s.HTTPPort)
+	}
+	if s := fileCfg.Socksd; s != nil {
+		checkPort("socksd.socksd_port", s.Port)
+		checkFallback("socksd.fallback", s.Fallback)
+	}
+	if s := fileCfg.Gateway; s != nil {
+		checkPort("gateway.proxy_port", s.ProxyPort)
+		checkPort("gateway.httpd_port", s.HTTPDPort)
+		checkPort("gateway.httpsd_port", s.HTTPSDPort)
+		checkFallback("gateway.fallback", s.Fallback)
+		if s.AdditionalPorts != nil {
+			check("gateway.additional_ports", *s.AdditionalPorts, checkAdditionalPorts(*s.AdditionalPorts))
+		}
+	}
+	return
+}
+
+// checkAdditionalPorts validates the format that is read by
+// config.SProxyAdditionalPorts, which skips invalid ports
+func checkAdditionalPorts(ports string) error {
+	for _, frag := range strings.Split(ports, ",") {
+		for _, port := range strings.Split(frag, "..") {
+			portInt, err := strconv.Atoi(port)
+			if err != nil || !util.IsPort(portInt) {
+				return fmt.Errorf("expected comma separated ports or <from>..<to> ranges but got: %s", frag)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/diodechain/diode_client/config"
+)
+
+const validConfigFile = `diodeaddrs:
+  - europe.prenet.diode.io:41046
+relays: 3
+relaypolicy: sticky-primary
+allowlists:
+  - 0x6000000000000000000000000000000000000000
+bind:
+  - 8080:betahaus:80:tls
+publish:
+  public:
+    - 80:80
+  proxy_port: 8080
+socksd:
+  fallback: localhost
+`
+
+const invalidConfigFile = `retrytime: 5
+diodeaddrs:
+  - europe.prenet.diode.io
+relays: -1
+relaypolicy: fastest
+allowlists:
+  - 0x6000
+bind:
+  - 8080:betahaus.diode
+published_public_ports:
+  - 80000:80
+gateway:
+  httpd_port: 70000
+  fallback: maybe
+`
+
+func TestCheckConfigFile(t *testing.T) {
+	if errs := checkConfigFile([]byte(validConfigFile)); len(errs) != 0 {
+		t.Fatalf("valid config file should be accepted but got %v", errs)
+	}
+
+	errs := checkConfigFile([]byte(invalidConfigFile))
+	unknown := 0
+	fields := make(map[string]bool)
+	for _, err := range errs {
+		if fieldErr, ok := err.(*config.FieldError); ok {
+			fields[fieldErr.Field] = true
+		} else if strings.Contains(err.Error(), "retrytime") {
+			unknown++
+		}
+	}
+	if unknown != 1 {
+		t.Errorf("unknown key should be rejected but got %v", errs)
+	}
+	for _, field := range []string{
+		"diodeaddrs[0]",
+		"relays",
+		"relaypolicy",
+		"allowlists[0]",
+		"bind[0]",
+		"published_public_ports[0]",
+		"gateway.httpd_port",
+		"gateway.fallback",
+	} {
+		if !fields[field] {
+			t.Errorf("invalid value of %s should be rejected", field)
+		}
+	}
+	if len(errs) != len(fields)+unknown {
+		t.Errorf("expected one error per invalid value but got %v", errs)
+	}
+}
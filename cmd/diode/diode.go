@@ -5,6 +5,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"time"
 
@@ -45,7 +46,7 @@ func watchAccount(client *rpc.Client, to util.Address) (res bool) {
 	cfg := config.AppConfig
 	startBN, _ = client.LastValid()
 	bn = startBN
-	oact, _ = client.GetValidAccount(uint64(bn), to)
+	oact, _ = client.GetValidAccount(context.Background(), uint64(bn), to)
 	for {
 		<-time.After(15 * time.Second)
 		var nbn uint64
@@ -56,7 +57,7 @@ func watchAccount(client *rpc.Client, to util.Address) (res bool) {
 		}
 		var nact *edge.Account
 		bn = nbn
-		nact, err = client.GetValidAccount(uint64(bn), to)
+		nact, err = client.GetValidAccount(context.Background(), uint64(bn), to)
 		if err != nil {
 			cfg.PrintInfo("Waiting for next valid block...")
 			continue
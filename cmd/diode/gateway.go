@@ -4,8 +4,6 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/diodechain/diode_client/command"
 	"github.com/diodechain/diode_client/config"
 	"github.com/diodechain/diode_client/rpc"
@@ -19,8 +17,6 @@ var (
 		Run:         gatewayHandler,
 		Type:        command.DaemonCommand,
 	}
-	edgeACME      = false
-	edgeACMEEmail = ""
 )
 
 func init() {
@@ -39,8 +35,8 @@ func init() {
 	gatewayCmd.Flag.StringVar(&cfg.SProxyServerPrivPath, "privpath", "./priv/priv.pem", "Pem format of private key file path of httpsd secure server")
 	gatewayCmd.Flag.BoolVar(&cfg.EnableSProxyServer, "secure", false, "enable httpsd server")
 	gatewayCmd.Flag.BoolVar(&cfg.AllowRedirectToSProxy, "allow_redirect", false, "allow redirect all http transmission to httpsd")
-	gatewayCmd.Flag.BoolVar(&edgeACME, "edge_acme", false, "allow to use ACME generate certificates automatically")
-	gatewayCmd.Flag.StringVar(&edgeACMEEmail, "edge_acme_email", "", "ACME email configuration")
+	gatewayCmd.Flag.BoolVar(&cfg.EdgeACME, "edge_acme", false, "allow to use ACME generate certificates automatically")
+	gatewayCmd.Flag.StringVar(&cfg.EdgeACMEEmail, "edge_acme_email", "", "ACME email configuration")
 }
 
 func gatewayHandler() (err error) {
@@ -58,8 +54,7 @@ func gatewayHandler() (err error) {
 	socksCfg := rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
+		AccessLists:     cfg.AccessLists,
 		EnableProxy:     true,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
@@ -70,11 +65,7 @@ func gatewayHandler() (err error) {
 	}
 	if len(cfg.Binds) > 0 {
 		socksServer.SetBinds(cfg.Binds)
-		cfg.PrintInfo("")
-		cfg.PrintLabel("Bind      <name>", "<mode>     <remote>")
-		for _, bind := range cfg.Binds {
-			cfg.PrintLabel(fmt.Sprintf("Port      %5d", bind.LocalPort), fmt.Sprintf("%5s     %11s:%d", config.ProtocolName(bind.Protocol), bind.To, bind.ToPort))
-		}
+		printBinds(cfg, cfg.Binds)
 	}
 	app.SetSocksServer(socksServer)
 	if err = socksServer.Start(); err != nil {
@@ -89,8 +80,8 @@ func gatewayHandler() (err error) {
 		CertPath:          cfg.SProxyServerCertPath,
 		PrivPath:          cfg.SProxyServerPrivPath,
 		AllowRedirect:     cfg.AllowRedirectToSProxy,
-		EdgeACME:          edgeACME,
-		EdgeACMEEmail:     edgeACMEEmail,
+		EdgeACME:          cfg.EdgeACME,
+		EdgeACMEEmail:     cfg.EdgeACMEEmail,
 	}
 	var proxyServer *rpc.ProxyServer
 	proxyServer, err = rpc.NewProxyServer(proxyCfg, socksServer)
@@ -102,6 +93,7 @@ func gatewayHandler() (err error) {
 	if err := proxyServer.Start(); err != nil {
 		cfg.Logger.Error(err.Error())
 	}
+	app.WatchConfig(socksServer)
 	app.Wait()
 	return
 }
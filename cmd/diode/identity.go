@@ -0,0 +1,137 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"fmt"
+
+	"github.com/diodechain/diode_client/command"
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/util"
+)
+
+var (
+	identityCmd = &command.Command{
+		Name:        "identity",
+		HelpText:    `  Manage the identities in the local database, each identity has its own key, fleet and chain state. Usage: diode identity list|create <name>|use <name>|delete <name>`,
+		ExampleText: `  diode identity create work && diode identity use work && diode -identity default publish -public 80:80`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	errMissingIdentityName = fmt.Errorf("expected an identity name")
+)
+
+func init() {
+	cfg := config.AppConfig
+	// identityHandler reads the arguments of identityCmd, so Run can't be
+	// set in the declaration
+	identityCmd.Run = identityHandler
+	identityCmd.Flag.BoolVar(&cfg.IdentityForce, "force", false, "confirm deleting an identity and its private key")
+}
+
+func identityHandler() (err error) {
+	cfg := config.AppConfig
+	args := identityCmd.Flag.Args()
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+	switch action {
+	case "list":
+		listIdentities(cfg)
+	case "create":
+		err = createIdentity(cfg, name)
+	case "use":
+		err = useIdentity(cfg, name)
+	case "delete":
+		err = deleteIdentity(cfg, name)
+	default:
+		err = fmt.Errorf("unknown identity action '%s', expected list, create, use or delete", action)
+		cfg.PrintError("Couldn't run identity command", err)
+	}
+	return
+}
+
+func listIdentities(cfg *config.Config) {
+	current := db.DB.CurrentIdentity()
+	cfg.PrintLabel("<IDENTITY>", "<ADDRESS>")
+	for _, name := range db.DB.Identities() {
+		address := "<not created yet>"
+		pubKey, err := rpc.LoadIdentityPubKey(name)
+		if err == db.ErrPassphraseRequired || err == db.ErrWrongPassphrase {
+			address = "<encrypted>"
+		} else if err == nil {
+			addr := util.PubkeyToAddress(pubKey)
+			address = addr.HexString()
+		}
+		if name == current {
+			name += " (in use)"
+		}
+		cfg.PrintLabel(name, address)
+	}
+}
+
+func createIdentity(cfg *config.Config, name string) error {
+	if err := checkIdentityName(cfg, name); err != nil {
+		return err
+	}
+	if db.DB.HasIdentity(name) {
+		cfg.PrintError("Couldn't create identity", db.ErrIdentityExists)
+		return db.ErrIdentityExists
+	}
+	// LoadClientPubKey generates the key of the active identity
+	active := cfg.Identity
+	cfg.Identity = name
+	address := util.PubkeyToAddress(rpc.LoadClientPubKey())
+	cfg.Identity = active
+	cfg.PrintLabel("Created identity", name)
+	cfg.PrintLabel("Client address", address.HexString())
+	return nil
+}
+
+func useIdentity(cfg *config.Config, name string) error {
+	if err := checkIdentityName(cfg, name); err != nil {
+		return err
+	}
+	if err := db.DB.UseIdentity(name); err != nil {
+		cfg.PrintError("Couldn't use identity", err)
+		return err
+	}
+	cfg.PrintLabel("Using identity", name)
+	return nil
+}
+
+func deleteIdentity(cfg *config.Config, name string) error {
+	if err := checkIdentityName(cfg, name); err != nil {
+		return err
+	}
+	if !cfg.IdentityForce {
+		err := fmt.Errorf("this removes the private key of %s permanently, run 'diode identity -force delete %s' to confirm", name, name)
+		cfg.PrintError("Couldn't delete identity", err)
+		return err
+	}
+	if err := db.DB.DeleteIdentity(name); err != nil {
+		cfg.PrintError("Couldn't delete identity", err)
+		return err
+	}
+	cfg.PrintLabel("Deleted identity", name)
+	return nil
+}
+
+func checkIdentityName(cfg *config.Config, name string) (err error) {
+	if len(name) == 0 {
+		err = errMissingIdentityName
+	} else {
+		err = db.ValidateIdentity(name)
+	}
+	if err != nil {
+		cfg.PrintError("Invalid identity name", err)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/diodechain/diode_client/command"
+	"github.com/diodechain/diode_client/config"
+)
+
+// loadConfig sets all fields of cfg from the layers of the global flags and
+// the flags of the command, flags is the config after the command line has
+// been parsed
+func loadConfig(cfg *config.Config, flags *config.Config, cmd *command.Command) ([]config.Field, error) {
+	flagSets := []*flag.FlagSet{&diodeCmd.Flag}
+	name := ""
+	if cmd != nil {
+		flagSets = append(flagSets, &cmd.Flag)
+		name = cmd.Name
+	}
+	fields := config.AppConfig.Fields(flagSets...)
+	if err := cfg.LoadLayers(flags, name, fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// printEffectiveConfig prints the value of each field of effective and where
+// it came from
+func printEffectiveConfig(cfg *config.Config, effective *config.Config, fields []config.Field) {
+	cfg.PrintLabel("<NAME>", "<VALUE> <LAYER>")
+	for _, field := range fields {
+		value := field.Value(effective)
+		str := fmt.Sprintf("%v", value)
+		if values, ok := value.(config.StringValues); ok {
+			str = strings.Join(values, " ")
+		}
+		if field.Name == "passphrase" && len(str) > 0 && !cfg.ConfigUnsafe {
+			str = "<********************************>"
+		}
+		layer := field.Layer
+		if len(field.Origin) > 0 {
+			layer += " " + field.Origin
+		}
+		cfg.PrintLabel(field.Name, fmt.Sprintf("%s [%s]", str, layer))
+	}
+}
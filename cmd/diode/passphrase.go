@@ -0,0 +1,124 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/db"
+)
+
+const (
+	passphraseEnv         = "DIODE_PASSPHRASE"
+	keystorePassphraseEnv = "DIODE_KEYSTORE_PASSPHRASE"
+)
+
+var (
+	errPassphraseMismatch = fmt.Errorf("passphrases did not match")
+	errEmptyPassphrase    = fmt.Errorf("passphrase must not be empty")
+)
+
+// lookupPassphrase returns the passphrase given by flag or environment variable
+func lookupPassphrase(cfg *config.Config) string {
+	if len(cfg.Passphrase) > 0 {
+		return cfg.Passphrase
+	}
+	return os.Getenv(passphraseEnv)
+}
+
+// unlockDatabase sets the database passphrase, when the private key is encrypted and
+// no passphrase was provided the user is asked for it
+func unlockDatabase(cfg *config.Config) error {
+	privateKey := db.IdentityKey(cfg.Identity, "private")
+	passphrase := lookupPassphrase(cfg)
+	if len(passphrase) == 0 && db.DB.IsSecretEncrypted(privateKey) {
+		var err error
+		passphrase, err = readPassphrase("Passphrase for the private key: ")
+		if err != nil {
+			return err
+		}
+	}
+	if len(passphrase) > 0 {
+		db.DB.SetPassphrase([]byte(passphrase))
+		if db.DB.IsSecretEncrypted(privateKey) {
+			if _, err := db.DB.GetSecret(privateKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newPassphrase returns the configured passphrase or asks the user for a new one
+func newPassphrase(cfg *config.Config) (string, error) {
+	if passphrase := lookupPassphrase(cfg); len(passphrase) > 0 {
+		return passphrase, nil
+	}
+	return readNewPassphrase("New passphrase: ")
+}
+
+// newKeystorePassphrase returns the passphrase for an exported keystore file
+func newKeystorePassphrase() (string, error) {
+	if passphrase := os.Getenv(keystorePassphraseEnv); len(passphrase) > 0 {
+		return passphrase, nil
+	}
+	return readNewPassphrase("New keystore passphrase: ")
+}
+
+// readKeystorePassphrase returns the passphrase of an imported keystore file
+func readKeystorePassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(keystorePassphraseEnv); len(passphrase) > 0 {
+		return passphrase, nil
+	}
+	return readPassphrase(prompt)
+}
+
+// readNewPassphrase asks the user twice for a new passphrase
+func readNewPassphrase(prompt string) (string, error) {
+	passphrase, err := readPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errEmptyPassphrase
+	}
+	confirm, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", errPassphraseMismatch
+	}
+	return passphrase, nil
+}
+
+// readPassphrase prompts for a passphrase on the terminal
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if runtime.GOOS != "windows" {
+		if err := stty("-echo"); err == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", fmt.Errorf("couldn't read passphrase: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -32,9 +32,10 @@ var (
 		Type:             command.DaemonCommand,
 		SingleConnection: true,
 	}
-	enableStaticServer = false
-	staticServer       staticserver.StaticHTTPServer
-	scfg               staticserver.Config
+	staticServer staticserver.StaticHTTPServer
+	// staticServerPort is published for the static server, it's kept when
+	// the published ports are reloaded
+	staticServerPort *config.Port
 )
 
 func init() {
@@ -46,11 +47,11 @@ func init() {
 	publishCmd.Flag.StringVar(&cfg.SocksServerHost, "proxy_host", "127.0.0.1", "host of socksd proxy server")
 	publishCmd.Flag.IntVar(&cfg.SocksServerPort, "proxy_port", 1080, "port of socksd proxy server")
 	publishCmd.Flag.BoolVar(&cfg.EnableSocksServer, "socksd", false, "enable socksd proxy server")
-	publishCmd.Flag.BoolVar(&enableStaticServer, "http", false, "enable http static file server")
-	publishCmd.Flag.StringVar(&scfg.RootDirectory, "http_dir", "", "the root directory of http static file server")
-	publishCmd.Flag.StringVar(&scfg.Host, "http_host", "127.0.0.1", "the host of http static file server")
-	publishCmd.Flag.IntVar(&scfg.Port, "http_port", 8080, "the port of http static file server")
-	publishCmd.Flag.BoolVar(&scfg.Indexed, "indexed", false, "enable directory indexing in http static file server")
+	publishCmd.Flag.BoolVar(&cfg.EnableStaticServer, "http", false, "enable http static file server")
+	publishCmd.Flag.StringVar(&cfg.StaticServerRoot, "http_dir", "", "the root directory of http static file server")
+	publishCmd.Flag.StringVar(&cfg.StaticServerHost, "http_host", "127.0.0.1", "the host of http static file server")
+	publishCmd.Flag.IntVar(&cfg.StaticServerPort, "http_port", 8080, "the port of http static file server")
+	publishCmd.Flag.BoolVar(&cfg.StaticServerIndexed, "indexed", false, "enable directory indexing in http static file server")
 }
 
 // Supporting ipv6 if sorrounded by [] otherwise assuming domain or ip4
@@ -199,50 +200,60 @@ func parseBind(bind string) (*config.Bind, error) {
 	return ret, nil
 }
 
-func publishHandler() (err error) {
-	cfg := config.AppConfig
+// parsePublishedPorts returns all public, protected and private ports of the config
+func parsePublishedPorts(cfg *config.Config) (map[int]*config.Port, error) {
 	portString := make(map[int]*config.Port)
 
 	// copy to config
 	ports, err := parsePorts(cfg.PublicPublishedPorts, config.PublicPublishedMode)
 	if err != nil {
-		return
+		return nil, err
 	}
 	for _, port := range ports {
 		if portString[port.To] != nil {
-			err = fmt.Errorf("public port specified twice: %v", port.To)
-			return
+			return nil, fmt.Errorf("public port specified twice: %v", port.To)
 		}
 		portString[port.To] = port
 	}
 	ports, err = parsePorts(cfg.ProtectedPublishedPorts, config.ProtectedPublishedMode)
 	if err != nil {
-		return
+		return nil, err
 	}
 	for _, port := range ports {
 		if portString[port.To] != nil {
-			err = fmt.Errorf("port conflict between public and protected port: %v", port.To)
-			return
+			return nil, fmt.Errorf("port conflict between public and protected port: %v", port.To)
 		}
 		portString[port.To] = port
 	}
 	ports, err = parsePorts(cfg.PrivatePublishedPorts, config.PrivatePublishedMode)
 	if err != nil {
-		return
+		return nil, err
 	}
 	for _, port := range ports {
 		if portString[port.To] != nil {
-			err = fmt.Errorf("port conflict with private port: %v", port.To)
-			return
+			return nil, fmt.Errorf("port conflict with private port: %v", port.To)
 		}
 		portString[port.To] = port
 	}
-	cfg.PublishedPorts = portString
+	return portString, nil
+}
+
+func publishHandler() (err error) {
+	cfg := config.AppConfig
+	cfg.PublishedPorts, err = parsePublishedPorts(cfg)
+	if err != nil {
+		return
+	}
 
-	if enableStaticServer || len(scfg.RootDirectory) > 0 {
+	if cfg.EnableStaticServer || len(cfg.StaticServerRoot) > 0 {
 		// publish the static when user didn't publish 80 port
 		if _, ok := cfg.PublishedPorts[httpPort]; !ok {
-			staticServer = staticserver.NewStaticHTTPServer(scfg)
+			staticServer = staticserver.NewStaticHTTPServer(staticserver.Config{
+				RootDirectory: cfg.StaticServerRoot,
+				Host:          cfg.StaticServerHost,
+				Port:          cfg.StaticServerPort,
+				Indexed:       cfg.StaticServerIndexed,
+			})
 			var ln net.Listener
 			ln, err = net.Listen("tcp", staticServer.Addr)
 			if err != nil {
@@ -262,12 +273,13 @@ func publishHandler() (err error) {
 				ln.Close()
 			})
 
-			cfg.PublishedPorts[httpPort] = &config.Port{
-				Src:      scfg.Port,
+			staticServerPort = &config.Port{
+				Src:      cfg.StaticServerPort,
 				To:       httpPort,
 				Mode:     config.PublicPublishedMode,
 				Protocol: config.AnyProtocol,
 			}
+			cfg.PublishedPorts[httpPort] = staticServerPort
 		}
 	}
 
@@ -299,15 +311,7 @@ func publishHandler() (err error) {
 				break
 			}
 		}
-		cfg.PrintLabel("Port      <name>", "<extern>     <mode>    <protocol>     <allowlist>")
-		for _, port := range cfg.PublishedPorts {
-			addrs := make([]string, 0, len(port.Allowlist))
-			for addr := range port.Allowlist {
-				addrs = append(addrs, addr.HexString())
-			}
-			host := net.JoinHostPort(port.SrcHost, strconv.Itoa(port.Src))
-			cfg.PrintLabel(fmt.Sprintf("Port %12s", host), fmt.Sprintf("%8d  %10s       %s        %s", port.To, config.ModeName(port.Mode), config.ProtocolName(port.Protocol), strings.Join(addrs, ",")))
-		}
+		printPublishedPorts(cfg, cfg.PublishedPorts)
 	}
 
 	if cfg.EnableAPIServer {
@@ -318,8 +322,7 @@ func publishHandler() (err error) {
 	socksCfg := rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
+		AccessLists:     cfg.AccessLists,
 		EnableProxy:     true,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
@@ -337,28 +340,47 @@ func publishHandler() (err error) {
 	}
 	if len(cfg.Binds) > 0 {
 		socksServer.SetBinds(cfg.Binds)
-		cfg.PrintInfo("")
-		cfg.PrintLabel("Bind      <name>", "<mode>     <remote>")
-		for _, bind := range cfg.Binds {
-			cfg.PrintLabel(fmt.Sprintf("Port      %5d", bind.LocalPort), fmt.Sprintf("%5s     %11s:%d", config.ProtocolName(bind.Protocol), bind.To, bind.ToPort))
-		}
+		printBinds(cfg, cfg.Binds)
 	}
+	app.WatchConfig(socksServer)
 	for {
 		app.Wait()
 		if !app.Closed() {
 			// Restart to publish utill user send sigint to client
 			var client *rpc.Client
+			backoff := rpc.NewReconnectBackoff(cfg)
 			for {
 				client = app.WaitForFirstClient(true)
 				if client != nil {
 					break
 				}
-				cfg.Logger.Info("Could not connect to network trying again in 5 seconds")
-				// TODO: backoff?
-				time.Sleep(5 * time.Second)
+				wait := backoff.Duration()
+				rpc.CountReconnect()
+				cfg.Logger.Info("Could not connect to network trying again in %s (attempt %d)", wait, int(backoff.Attempt()))
+				time.Sleep(wait)
 			}
 		} else {
 			return
 		}
 	}
 }
+
+func printPublishedPorts(cfg *config.Config, publishedPorts map[int]*config.Port) {
+	cfg.PrintLabel("Port      <name>", "<extern>     <mode>    <protocol>     <allowlist>")
+	for _, port := range publishedPorts {
+		addrs := make([]string, 0, len(port.Allowlist))
+		for addr := range port.Allowlist {
+			addrs = append(addrs, addr.HexString())
+		}
+		host := net.JoinHostPort(port.SrcHost, strconv.Itoa(port.Src))
+		cfg.PrintLabel(fmt.Sprintf("Port %12s", host), fmt.Sprintf("%8d  %10s       %s        %s", port.To, config.ModeName(port.Mode), config.ProtocolName(port.Protocol), strings.Join(addrs, ",")))
+	}
+}
+
+func printBinds(cfg *config.Config, binds []config.Bind) {
+	cfg.PrintInfo("")
+	cfg.PrintLabel("Bind      <name>", "<mode>     <remote>")
+	for _, bind := range binds {
+		cfg.PrintLabel(fmt.Sprintf("Port      %5d", bind.LocalPort), fmt.Sprintf("%5s     %11s:%d", config.ProtocolName(bind.Protocol), bind.To, bind.ToPort))
+	}
+}
@@ -0,0 +1,194 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/util"
+)
+
+const (
+	// configPollInterval is how often the config file is checked for changes
+	configPollInterval = 5 * time.Second
+)
+
+var (
+	// flagConfig keeps the command line values, the config is loaded again
+	// from its layers on every reload
+	flagConfig config.Config
+)
+
+// configReloader applies changes of the config file to a running daemon
+type configReloader struct {
+	socksServer *rpc.Server
+	modTime     time.Time
+	mx          sync.Mutex
+}
+
+// WatchConfig reloads the config file on SIGHUP and whenever the file changes
+func (dio *Diode) WatchConfig(socksServer *rpc.Server) {
+	cfg := dio.config
+	if len(cfg.ConfigFilePath) == 0 {
+		return
+	}
+	dio.reloader = &configReloader{
+		socksServer: socksServer,
+		modTime:     configModTime(cfg.ConfigFilePath),
+	}
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dio.closeCh:
+				return
+			case <-ticker.C:
+				modTime := configModTime(cfg.ConfigFilePath)
+				dio.reloader.mx.Lock()
+				changed := !modTime.IsZero() && !modTime.Equal(dio.reloader.modTime)
+				dio.reloader.mx.Unlock()
+				if changed {
+					dio.ReloadConfig()
+				}
+			}
+		}
+	}()
+}
+
+// ReloadConfig reads the config file again and applies changed published
+// ports, binds and access lists, live connections are not affected
+func (dio *Diode) ReloadConfig() {
+	cfg := dio.config
+	reloader := dio.reloader
+	if reloader == nil {
+		cfg.PrintInfo("Ignoring reload, the config can only be reloaded by publish, socksd and gateway with -configpath")
+		return
+	}
+	reloader.mx.Lock()
+	defer reloader.mx.Unlock()
+	reloader.modTime = configModTime(cfg.ConfigFilePath)
+	if err := dio.applyConfigFile(reloader.socksServer); err != nil {
+		cfg.PrintError("Couldn't reload config", err)
+		return
+	}
+	if err := cfg.TrackChanges(); err != nil {
+		cfg.PrintError("Couldn't reload config", err)
+	}
+}
+
+func (dio *Diode) applyConfigFile(socksServer *rpc.Server) (err error) {
+	cfg := dio.config
+	newCfg := flagConfig
+	if _, err = loadConfig(&newCfg, &flagConfig, dio.cmd); err != nil {
+		return
+	}
+
+	// Validate everything before anything is applied
+	publishPorts := cfg.Command == "publish" && (!sameStrings(cfg.PublicPublishedPorts, newCfg.PublicPublishedPorts) ||
+		!sameStrings(cfg.ProtectedPublishedPorts, newCfg.ProtectedPublishedPorts) ||
+		!sameStrings(cfg.PrivatePublishedPorts, newCfg.PrivatePublishedPorts))
+	var publishedPorts map[int]*config.Port
+	if publishPorts {
+		publishedPorts, err = parsePublishedPorts(&newCfg)
+		if err != nil {
+			return
+		}
+		if _, ok := publishedPorts[httpPort]; !ok && staticServerPort != nil {
+			publishedPorts[httpPort] = staticServerPort
+		}
+	}
+	bindChanged := !sameStrings(cfg.SBinds, newCfg.SBinds)
+	binds := make([]config.Bind, 0, len(newCfg.SBinds))
+	if bindChanged {
+		for _, str := range newCfg.SBinds {
+			var bind *config.Bind
+			bind, err = parseBind(str)
+			if err != nil {
+				return
+			}
+			binds = append(binds, *bind)
+		}
+	}
+	accessChanged := !sameStrings(cfg.SAllowlists, newCfg.SAllowlists) || !sameStrings(cfg.SBlocklists, newCfg.SBlocklists)
+	var allowlists, blocklists map[config.Address]bool
+	if accessChanged {
+		allowlists, err = parseAccessList(newCfg.SAllowlists)
+		if err != nil {
+			return
+		}
+		blocklists, err = parseAccessList(newCfg.SBlocklists)
+		if err != nil {
+			return
+		}
+	}
+
+	if !publishPorts && !bindChanged && !accessChanged {
+		cfg.PrintInfo("Reloaded config, nothing changed")
+		return
+	}
+	if publishPorts {
+		cfg.PublicPublishedPorts = newCfg.PublicPublishedPorts
+		cfg.ProtectedPublishedPorts = newCfg.ProtectedPublishedPorts
+		cfg.PrivatePublishedPorts = newCfg.PrivatePublishedPorts
+		cfg.PublishedPorts = publishedPorts
+		dio.clientManager.GetPool().SetPublishedPorts(publishedPorts)
+		cfg.PrintInfo("Reloaded published ports")
+		printPublishedPorts(cfg, publishedPorts)
+	}
+	if bindChanged {
+		cfg.SBinds = newCfg.SBinds
+		cfg.Binds = binds
+		socksServer.SetBinds(binds)
+		cfg.PrintInfo("Reloaded binds")
+		printBinds(cfg, binds)
+	}
+	if accessChanged {
+		cfg.SAllowlists = newCfg.SAllowlists
+		cfg.SBlocklists = newCfg.SBlocklists
+		cfg.AccessLists.Set(allowlists, blocklists)
+		cfg.PrintLabel("Reloaded allowlists", strings.Join(newCfg.SAllowlists, ", "))
+		cfg.PrintLabel("Reloaded blocklists", strings.Join(newCfg.SBlocklists, ", "))
+	}
+	return
+}
+
+// parseAccessList returns the set of addresses in an allow or block list
+func parseAccessList(addrs []string) (map[config.Address]bool, error) {
+	list := make(map[config.Address]bool, len(addrs))
+	for _, str := range addrs {
+		addr, err := util.DecodeAddress(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address in allow/block list %s: %v", str, err)
+		}
+		list[addr] = true
+	}
+	return list, nil
+}
+
+func configModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
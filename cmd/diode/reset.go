@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/diodechain/diode_client/command"
@@ -36,7 +37,7 @@ func doInit(cfg *config.Config, client *rpc.Client) error {
 		return nil
 	}
 	// deploy fleet
-	bn, _ := client.GetBlockPeak()
+	bn, _ := client.GetBlockPeak(context.Background())
 	if bn == 0 {
 		err := fmt.Errorf("not found")
 		cfg.PrintError("Cannot find block peak: ", err)
@@ -51,7 +52,7 @@ func doInit(cfg *config.Config, client *rpc.Client) error {
 		cfg.PrintError("Cannot create fleet contract instance: ", err)
 		return err
 	}
-	act, _ := client.GetValidAccount(uint64(bn), cfg.ClientAddr)
+	act, _ := client.GetValidAccount(context.Background(), uint64(bn), cfg.ClientAddr)
 	if act == nil {
 		nonce = 0
 	} else {
@@ -63,7 +64,7 @@ func doInit(cfg *config.Config, client *rpc.Client) error {
 		return err
 	}
 	tx := edge.NewDeployTransaction(nonce, 0, 10000000, 0, deployData, 0)
-	res, err := client.SendTransaction(tx)
+	res, err := client.SendTransaction(context.Background(), tx)
 	if err != nil {
 		cfg.PrintError("Cannot deploy fleet contract: ", err)
 		return err
@@ -81,7 +82,7 @@ func doInit(cfg *config.Config, client *rpc.Client) error {
 	// send device allowlist transaction
 	allowlistData, _ := fleetContract.SetDeviceAllowlist(cfg.ClientAddr, true)
 	ntx := edge.NewTransaction(nonce+1, 0, 10000000, fleetAddr, 0, allowlistData, 0)
-	res, err = client.SendTransaction(ntx)
+	res, err = client.SendTransaction(context.Background(), ntx)
 	if err != nil {
 		cfg.PrintError("Cannot allowlist device: ", err)
 		return err
@@ -99,7 +100,7 @@ func doInit(cfg *config.Config, client *rpc.Client) error {
 	if cfg.LoadFromFile {
 		err = cfg.SaveToFile()
 	} else {
-		err = db.DB.Put("fleet", fleetAddr[:])
+		err = db.DB.Put(db.IdentityKey(cfg.Identity, "fleet"), fleetAddr[:])
 	}
 	if err != nil {
 		cfg.PrintError("Cannot save fleet address: ", err)
@@ -115,7 +116,7 @@ func doInitExp(cfg *config.Config, client *rpc.Client) error {
 		return nil
 	}
 	// deploy fleet
-	bn, _ := client.GetBlockPeak()
+	bn, _ := client.GetBlockPeak(context.Background())
 	if bn == 0 {
 		err := fmt.Errorf("not found")
 		cfg.PrintError("Cannot find block peak: ", err)
@@ -130,7 +131,7 @@ func doInitExp(cfg *config.Config, client *rpc.Client) error {
 		cfg.PrintError("Cannot create fleet contract instance: ", err)
 		return err
 	}
-	act, _ := client.GetValidAccount(uint64(bn), cfg.ClientAddr)
+	act, _ := client.GetValidAccount(context.Background(), uint64(bn), cfg.ClientAddr)
 	if act == nil {
 		nonce = 0
 	} else {
@@ -142,7 +143,7 @@ func doInitExp(cfg *config.Config, client *rpc.Client) error {
 		return err
 	}
 	tx := edge.NewDeployTransaction(nonce, 0, 10000000, 0, deployData, 0)
-	res, err := client.SendTransaction(tx)
+	res, err := client.SendTransaction(context.Background(), tx)
 	if err != nil {
 		cfg.PrintError("Cannot deploy fleet contract: ", err)
 		return err
@@ -158,7 +159,7 @@ func doInitExp(cfg *config.Config, client *rpc.Client) error {
 	// send device allowlist transaction
 	allowlistData, _ := fleetContract.SetDeviceAllowlist(cfg.ClientAddr, true)
 	ntx := edge.NewTransaction(nonce+1, 0, 10000000, fleetAddr, 0, allowlistData, 0)
-	res, err = client.SendTransaction(ntx)
+	res, err = client.SendTransaction(context.Background(), ntx)
 	if err != nil {
 		cfg.PrintError("Cannot allowlist device: ", err)
 		return err
@@ -176,7 +177,7 @@ func doInitExp(cfg *config.Config, client *rpc.Client) error {
 	if cfg.LoadFromFile {
 		err = cfg.SaveToFile()
 	} else {
-		err = db.DB.Put("fleet", fleetAddr[:])
+		err = db.DB.Put(db.IdentityKey(cfg.Identity, "fleet"), fleetAddr[:])
 	}
 	if err != nil {
 		cfg.PrintError("Cannot save fleet address: ", err)
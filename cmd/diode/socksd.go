@@ -47,8 +47,7 @@ func socksdHandler() (err error) {
 	socksCfg := rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
+		AccessLists:     cfg.AccessLists,
 		EnableProxy:     false,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
@@ -72,6 +71,7 @@ func socksdHandler() (err error) {
 		return
 	}
 	app.SetSocksServer(socksServer)
+	app.WatchConfig(socksServer)
 	app.Wait()
 	return
 }
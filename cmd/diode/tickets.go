@@ -0,0 +1,49 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diodechain/diode_client/command"
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
+)
+
+var (
+	ticketsCmd = &command.Command{
+		Name:        "tickets",
+		HelpText:    `  List the tickets that this client signed for the relays, to reconcile their billing.`,
+		ExampleText: `  diode tickets -json > tickets.json`,
+		Run:         ticketsHandler,
+		Type:        command.EmptyConnectionCommand,
+	}
+	ticketsJSON bool
+)
+
+func init() {
+	ticketsCmd.Flag.BoolVar(&ticketsJSON, "json", false, "print the tickets with their signatures as json")
+}
+
+func ticketsHandler() (err error) {
+	cfg := config.AppConfig
+	records, err := rpc.TicketHistory()
+	if err != nil {
+		cfg.PrintError("Couldn't read the ticket history", err)
+		return
+	}
+	if ticketsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	cfg.PrintLabel("<TIME>", "<RELAY>                         <BLOCK>      <BYTES>  <CONNECTIONS>  <REASON>")
+	for _, r := range records {
+		cfg.PrintLabel(r.Time.Format(time.RFC3339), fmt.Sprintf("%-30s %8d %12d %14d  %s", r.Relay, r.BlockNumber, r.TotalBytes, r.TotalConnections, r.Reason))
+	}
+	return
+}
@@ -4,26 +4,47 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"time"
 
+	"github.com/diodechain/diode_client/blockquick"
 	"github.com/diodechain/diode_client/command"
 	"github.com/diodechain/diode_client/config"
 	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/util"
 )
 
 var (
 	ErrFailedToFetchHeader = fmt.Errorf("can't load last valid block")
-	averageBlockTime       = 15
 	timeCmd                = &command.Command{
 		Name:        "time",
-		HelpText:    `  Lookup the current time from the blockchain consensus.`,
-		ExampleText: `  diode time`,
+		HelpText:    `  Lookup the current time from the blockchain consensus. With -serve the time is served as json over http and the system clock is watched.`,
+		ExampleText: `  diode time -serve localhost:1082 -sign`,
 		Run:         timeHandler,
 		Type:        command.OneOffCommand,
 	}
+	timeCfg = struct {
+		ServeAddr string
+		Sign      bool
+		MaxSkew   time.Duration
+	}{}
 )
 
+// timeResponse is the json response of the time server
+type timeResponse struct {
+	rpc.ConsensusTime
+	SkewAlarm bool `json:"skew_alarm"`
+}
+
+func init() {
+	timeCmd.Flag.StringVar(&timeCfg.ServeAddr, "serve", "", "keep running and serve the consensus time as json at this address, e.g. localhost:1082")
+	timeCmd.Flag.BoolVar(&timeCfg.Sign, "sign", false, "sign the served time with the time key of the identity, together with the ?nonce=<hex> of the request")
+	timeCmd.Flag.DurationVar(&timeCfg.MaxSkew, "maxskew", time.Minute, "warn when the system clock is further than this outside of the consensus time")
+}
+
 func timeHandler() (err error) {
 	err = app.Start()
 	if err != nil {
@@ -38,12 +59,117 @@ func timeHandler() (err error) {
 		return
 	}
 
-	t0 := int(header.Timestamp())
-	t1 := t0 + (rpc.WindowSize() * averageBlockTime)
+	clock := &rpc.ConsensusClock{}
+	clock.Update(header)
+	ct, _ := clock.Now()
+	tm0 := time.Unix(ct.Min, 0)
+	tm1 := time.Unix(ct.Max, 0)
+	cfg.PrintLabel("Minimum Time", fmt.Sprintf("%s (%d)", tm0.Format(time.UnixDate), ct.Min))
+	cfg.PrintLabel("Maximum Time", fmt.Sprintf("%s (%d)", tm1.Format(time.UnixDate), ct.Max))
+	if len(timeCfg.ServeAddr) == 0 {
+		if isSkewed(ct) {
+			cfg.PrintLabel("System Clock", fmt.Sprintf("off by %ds", ct.Skew))
+		}
+		return
+	}
+	return serveTime(cfg, clock)
+}
+
+func isSkewed(ct rpc.ConsensusTime) bool {
+	skew := time.Duration(ct.Skew) * time.Second
+	return skew > timeCfg.MaxSkew || -skew > timeCfg.MaxSkew
+}
+
+// serveTime serves the consensus time until the client is stopped, the
+// clock moves with every new validated block
+func serveTime(cfg *config.Config, clock *rpc.ConsensusClock) error {
+	var signer util.Address
+	if timeCfg.Sign {
+		pubKey, err := rpc.TimeSigner().PublicKey()
+		if err != nil {
+			return err
+		}
+		signer = util.PubkeyToAddress(pubKey)
+	}
+	ln, err := net.Listen("tcp", timeCfg.ServeAddr)
+	if err != nil {
+		return err
+	}
+	blocks := make(chan blockquick.BlockHeader, 16)
+	app.clientManager.SubscribeBlocks(blocks)
+	defer app.clientManager.UnsubscribeBlocks(blocks)
+	done := make(chan struct{})
+	defer close(done)
+	go watchClock(cfg, clock, blocks, done)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", timeHandleFunc(cfg, clock))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			cfg.Logger.Error("Couldn't serve the consensus time: %v", err)
+		}
+	}()
+	cfg.PrintLabel("Time server", ln.Addr().String())
+	if timeCfg.Sign {
+		cfg.PrintLabel("Time signer", signer.HexString())
+	}
+	app.Wait()
+	return srv.Close()
+}
+
+// watchClock moves the clock to the new blocks and warns when the system
+// clock leaves the consensus time
+func watchClock(cfg *config.Config, clock *rpc.ConsensusClock, blocks <-chan blockquick.BlockHeader, done <-chan struct{}) {
+	skewed := false
+	for {
+		select {
+		case bh := <-blocks:
+			clock.Update(bh)
+		case <-done:
+			return
+		}
+		ct, ok := clock.Now()
+		if !ok || isSkewed(ct) == skewed {
+			continue
+		}
+		skewed = !skewed
+		if skewed {
+			cfg.Logger.Warn("System clock is off by %ds from the consensus time of block %d", ct.Skew, ct.BlockNumber)
+		} else {
+			cfg.Logger.Info("System clock is within the consensus time of block %d again", ct.BlockNumber)
+		}
+	}
+}
 
-	tm0 := time.Unix(int64(t0), 0)
-	tm1 := time.Unix(int64(t1), 0)
-	cfg.PrintLabel("Minimum Time", fmt.Sprintf("%s (%d)", tm0.Format(time.UnixDate), t0))
-	cfg.PrintLabel("Maximum Time", fmt.Sprintf("%s (%d)", tm1.Format(time.UnixDate), t1))
-	return
+func timeHandleFunc(cfg *config.Config, clock *rpc.ConsensusClock) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ct, ok := clock.Now()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if timeCfg.Sign {
+			var nonce []byte
+			if q := req.URL.Query().Get("nonce"); len(q) > 0 {
+				var err error
+				nonce, err = util.DecodeString(q)
+				if err != nil || len(nonce) > 64 {
+					http.Error(w, "nonce should be at most 64 bytes of hex", http.StatusBadRequest)
+					return
+				}
+			}
+			if err := ct.Sign(rpc.TimeSigner(), nonce); err != nil {
+				cfg.Logger.Error("Couldn't sign the consensus time: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(timeResponse{ConsensusTime: ct, SkewAlarm: isSkewed(ct)})
+	}
 }
@@ -5,6 +5,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -93,7 +94,7 @@ func tokenHandler() (err error) {
 	}
 	appCfg := config.AppConfig
 	client := app.clientManager.GetNearestClient()
-	oaccount, err := client.GetValidAccount(0, appCfg.ClientAddr)
+	oaccount, err := client.GetValidAccount(context.Background(), 0, appCfg.ClientAddr)
 	if err != nil {
 		return
 	}
@@ -101,7 +102,7 @@ func tokenHandler() (err error) {
 	if !util.IsAddress([]byte(tokenCfg.To)) {
 		// lookup the bns name
 		var lookupAddrs []util.Address
-		lookupAddrs, err = client.ResolveBNS(tokenCfg.To)
+		lookupAddrs, err = client.ResolveBNS(context.Background(), tokenCfg.To)
 		if err != nil {
 			return
 		}
@@ -121,13 +122,13 @@ func tokenHandler() (err error) {
 		}
 	}
 	tx := edge.NewTransaction(uint64(oaccount.Nonce), uint64(gasPriceWei), uint64(gasWei), toAddr, uint64(valWei), data, 0)
-	_, err = client.SendTransaction(tx)
+	_, err = client.SendTransaction(context.Background(), tx)
 	if err != nil {
 		appCfg.PrintError("Cannot transfer DIODEs: ", err)
 		return
 	}
 	wait(client, func() bool {
-		naccount, err := client.GetValidAccount(0, appCfg.ClientAddr)
+		naccount, err := client.GetValidAccount(context.Background(), 0, appCfg.ClientAddr)
 		// Check state root in case the transaction is self transfer
 		// isSelfTx := appCfg.ClientAddr == toAddr
 		return err == nil && !bytes.Equal(naccount.StateRoot(), oaccount.StateRoot())
@@ -142,7 +143,7 @@ func showBalance() (err error) {
 	}
 	appCfg := config.AppConfig
 	client := app.clientManager.GetNearestClient()
-	oaccount, err := client.GetValidAccount(0, appCfg.ClientAddr)
+	oaccount, err := client.GetValidAccount(context.Background(), 0, appCfg.ClientAddr)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,147 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/diodechain/diode_client/command"
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/util"
+)
+
+var (
+	verifyCmd = &command.Command{
+		Name:        "verify",
+		HelpText:    `  Verify an account or a storage value against the state of a validated block and print the merkle proof. Usage: diode verify account <address>|storage <contract> <slot>`,
+		ExampleText: `  diode verify -json storage 0xaf60faa5cd840b724742f1af116168276112d6a6 0x1`,
+		Type:        command.OneOffCommand,
+	}
+	verifyJSON  bool
+	verifyBlock uint64
+)
+
+func init() {
+	verifyCmd.Run = verifyHandler
+	verifyCmd.Flag.BoolVar(&verifyJSON, "json", false, "print the proof as json")
+	verifyCmd.Flag.Uint64Var(&verifyBlock, "block", 0, "number of the validated block to verify against (default: the last valid block)")
+}
+
+func verifyHandler() (err error) {
+	cfg := config.AppConfig
+	args := verifyCmd.Flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("expected account <address> or storage <contract> <slot>")
+	}
+	var addr util.Address
+	var slot []byte
+	switch args[0] {
+	case rpc.AccountProof:
+		if len(args) != 2 {
+			return fmt.Errorf("expected verify account <address>")
+		}
+		addr, err = util.DecodeAddress(args[1])
+	case rpc.StorageProof:
+		if len(args) != 3 {
+			return fmt.Errorf("expected verify storage <contract> <slot>")
+		}
+		addr, err = util.DecodeAddress(args[1])
+		if err == nil {
+			slot, err = parseSlot(args[2])
+		}
+	default:
+		return fmt.Errorf("unknown verify action '%s', expected account or storage", args[0])
+	}
+	if err != nil {
+		return
+	}
+
+	err = app.Start()
+	if err != nil {
+		return
+	}
+	client := app.clientManager.GetNearestClient()
+	if client == nil {
+		return fmt.Errorf("couldn't connect to the network")
+	}
+	var proof *rpc.Proof
+	if args[0] == rpc.AccountProof {
+		proof, err = client.ProveAccount(context.Background(), verifyBlock, addr)
+	} else {
+		proof, err = client.ProveStorage(context.Background(), verifyBlock, addr, slot)
+	}
+	if err != nil {
+		cfg.PrintError("Couldn't verify the proof", err)
+		return
+	}
+	if verifyJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(proof)
+	} else {
+		printProof(cfg, proof)
+	}
+	if err == nil && !proof.Valid {
+		err = fmt.Errorf("the proof is not valid")
+	}
+	return
+}
+
+// parseSlot reads a storage slot as hex or as decimal number
+func parseSlot(s string) ([]byte, error) {
+	// hex slots may have an odd number of digits, e.g. 0x1
+	digits, base := s, 10
+	if strings.HasPrefix(s, "0x") {
+		digits, base = s[2:], 16
+	}
+	slot, ok := new(big.Int).SetString(digits, base)
+	if !ok || slot.Sign() < 0 {
+		return nil, fmt.Errorf("invalid storage slot %s", s)
+	}
+	return slot.Bytes(), nil
+}
+
+func printProof(cfg *config.Config, proof *rpc.Proof) {
+	cfg.PrintLabel("Block", fmt.Sprintf("%d %s", proof.BlockNumber, proof.BlockHash))
+	cfg.PrintLabel("State root", proof.StateHash)
+	cfg.PrintLabel("Account", proof.Account)
+	if proof.Kind == rpc.AccountProof {
+		cfg.PrintLabel("Balance", proof.Balance)
+		cfg.PrintLabel("Nonce", fmt.Sprintf("%d", proof.Nonce))
+	} else {
+		cfg.PrintLabel("Key", proof.Key)
+		cfg.PrintLabel("Value", proof.Value)
+	}
+	for _, tree := range proof.Trees {
+		cfg.PrintLabel(fmt.Sprintf("<%s PROOF>", strings.ToUpper(tree.Name)), fmt.Sprintf("root %s at %d", tree.RootHash, tree.Modulo))
+		for _, node := range tree.Nodes {
+			path := node.Path
+			if len(path) == 0 {
+				path = "<root>"
+			}
+			cfg.PrintLabel(path, node.Hash)
+			for _, leave := range node.Leaves {
+				cfg.PrintLabel("", fmt.Sprintf("  %s = %s", leave.Key, leave.Value))
+			}
+		}
+	}
+	for _, check := range proof.Checks {
+		result := "ok"
+		if !check.OK {
+			result = "FAILED"
+		}
+		cfg.PrintLabel(fmt.Sprintf("Check %s", check.Name), fmt.Sprintf("%s: %s", result, check.Detail))
+	}
+	if proof.Valid {
+		cfg.PrintLabel("Result", "valid")
+	} else {
+		cfg.PrintLabel("Result", "NOT valid")
+	}
+}
@@ -93,6 +93,11 @@ func (cmd *Command) SubCommand() *Command {
 	return subCmd
 }
 
+// FindSubCommand returns the subcommand with the given name or nil
+func (cmd *Command) FindSubCommand(name string) *Command {
+	return cmd.subCommands[name]
+}
+
 // Execute will run the command
 func (cmd *Command) Execute() (err error) {
 	args := os.Args[1:]
@@ -0,0 +1,51 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// AccessLists are the allow and block lists of devices, Set replaces them
+// while connections are checked against them
+type AccessLists struct {
+	lists atomic.Value
+}
+
+type accessLists struct {
+	allow map[Address]bool
+	block map[Address]bool
+}
+
+// NewAccessLists returns the access lists with the given allow and block list
+func NewAccessLists(allow map[Address]bool, block map[Address]bool) *AccessLists {
+	al := &AccessLists{}
+	al.Set(allow, block)
+	return al
+}
+
+// Set replaces both lists, the maps must not be changed afterwards
+func (al *AccessLists) Set(allow map[Address]bool, block map[Address]bool) {
+	al.lists.Store(accessLists{allow: allow, block: block})
+}
+
+// Check returns an error when the device is not allowed, the block list is
+// used when it's not empty and the allow list otherwise
+func (al *AccessLists) Check(device Address) error {
+	if al == nil {
+		return nil
+	}
+	lists, _ := al.lists.Load().(accessLists)
+	if len(lists.block) > 0 {
+		if lists.block[device] {
+			return fmt.Errorf("device %x is in the block list", device)
+		}
+	} else if len(lists.allow) > 0 {
+		if !lists.allow[device] {
+			return fmt.Errorf("device %x is not in the allow list", device)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAccessLists(t *testing.T) {
+	device := Address{1}
+	other := Address{2}
+	var none *AccessLists
+	if none.Check(device) != nil {
+		t.Fatalf("all devices should be allowed without access lists")
+	}
+	al := NewAccessLists(map[Address]bool{device: true}, nil)
+	if al.Check(device) != nil || al.Check(other) == nil {
+		t.Fatalf("only devices in the allow list should be allowed")
+	}
+	// the block list is used when it's not empty
+	al.Set(map[Address]bool{device: true}, map[Address]bool{device: true})
+	if al.Check(device) == nil || al.Check(other) != nil {
+		t.Fatalf("only devices in the block list should be blocked")
+	}
+
+	// a reload replaces the lists while they are checked
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			al.Set(nil, map[Address]bool{{byte(i)}: true})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			al.Check(device)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,204 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeFile sets the values of the config file in cfg, with strict set
+// unknown keys are reported too
+func (cfg *Config) DecodeFile(in []byte, strict bool) error {
+	dec := yaml.NewDecoder(bytes.NewReader(in))
+	dec.KnownFields(strict)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// TrackChanges remembers the current values, SaveToFile only writes the
+// values that differ from them
+func (cfg *Config) TrackChanges() (err error) {
+	cfg.syncFileValues()
+	cfg.tracked, err = cfg.yamlNode()
+	return
+}
+
+// SaveToFile writes the values that changed since TrackChanges to
+// ConfigFilePath, comments, ordering and unknown keys of the file are kept
+func (cfg *Config) SaveToFile() (err error) {
+	if !cfg.LoadFromFile {
+		err = errConfigNotLoadedFromFile
+		return
+	}
+	cfg.syncFileValues()
+	current, err := cfg.yamlNode()
+	if err != nil {
+		return
+	}
+	in, err := LoadConfigFromFile(cfg.ConfigFilePath)
+	if err != nil {
+		return
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(in, &doc); err != nil {
+		return
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	updateMapping(doc.Content[0], cfg.tracked, current)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return
+	}
+	if err = enc.Close(); err != nil {
+		return
+	}
+	var f *os.File
+	f, err = os.OpenFile(cfg.ConfigFilePath, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(out.Bytes())
+	return
+}
+
+// syncFileValues copies values that are changed at runtime to the fields
+// that are stored in the config file
+func (cfg *Config) syncFileValues() {
+	if cfg.FleetAddr != DefaultFleetAddr || len(cfg.SFleetAddr) > 0 {
+		cfg.SFleetAddr = cfg.FleetAddr.HexString()
+	}
+	// the section of the command overrides the top level published ports
+	if s := cfg.Publish; s != nil && cfg.Command == "publish" {
+		if len(s.Public) > 0 {
+			s.Public = cfg.PublicPublishedPorts
+		}
+		if len(s.Protected) > 0 {
+			s.Protected = cfg.ProtectedPublishedPorts
+		}
+		if len(s.Private) > 0 {
+			s.Private = cfg.PrivatePublishedPorts
+		}
+	}
+}
+
+// yamlNode returns the mapping node of the config
+func (cfg *Config) yamlNode() (*yaml.Node, error) {
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	// ports of the publish section are only written to the section
+	if s := cfg.Publish; s != nil {
+		if len(s.Public) > 0 {
+			removeMappingKey(node, "published_public_ports")
+		}
+		if len(s.Protected) > 0 {
+			removeMappingKey(node, "published_protected_ports")
+		}
+		if len(s.Private) > 0 {
+			removeMappingKey(node, "published_private_ports")
+		}
+	}
+	return node, nil
+}
+
+// updateMapping applies the keys that differ between old and current to the
+// mapping node of the file
+func updateMapping(file *yaml.Node, old *yaml.Node, current *yaml.Node) {
+	keys := mappingKeys(current)
+	for _, key := range mappingKeys(old) {
+		if mappingValue(current, key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		oldValue := mappingValue(old, key)
+		value := mappingValue(current, key)
+		if sameNode(oldValue, value) {
+			continue
+		}
+		fileValue := mappingValue(file, key)
+		switch {
+		case value == nil:
+			removeMappingKey(file, key)
+		case fileValue == nil:
+			file.Content = append(file.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		case fileValue.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			updateMapping(fileValue, oldValue, value)
+		default:
+			// keep the comments of the replaced value
+			value.HeadComment = fileValue.HeadComment
+			value.LineComment = fileValue.LineComment
+			value.FootComment = fileValue.FootComment
+			*fileValue = *value
+		}
+	}
+}
+
+func mappingKeys(node *yaml.Node) (keys []string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys = append(keys, node.Content[i].Value)
+	}
+	return
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func removeMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// sameNode compares the values of two nodes, styles and comments are ignored
+func sameNode(a *yaml.Node, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
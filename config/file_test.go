@@ -0,0 +1,105 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testConfigFile = `# Diode config of the office gateway
+debug: false # enabled while debugging the relay
+# keep the retries low, the link is flaky
+retrytimes: 5
+owner: ops-team
+publish:
+  # web server
+  public:
+    - 80:80
+`
+
+func TestSaveToFileKeepsComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diode_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "diode.yml")
+	if err = ioutil.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		DBPath:         "/tmp/private.db",
+		ConfigFilePath: path,
+		Command:        "publish",
+		LoadFromFile:   true,
+	}
+	in, err := LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = yaml.Unmarshal(in, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ApplySection(cfg.Command)
+	if err = cfg.TrackChanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Debug = true
+	cfg.PublicPublishedPorts = append(cfg.PublicPublishedPorts, "8080:8080")
+	cfg.SBinds = StringValues{"8000:betahaus.diode:80:tls"}
+	if err = cfg.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(out)
+	for _, want := range []string{
+		"# Diode config of the office gateway",
+		"debug: true # enabled while debugging the relay",
+		"# keep the retries low, the link is flaky",
+		"retrytimes: 5",
+		"owner: ops-team",
+		"# web server",
+		"- 8080:8080",
+		"- 8000:betahaus.diode:80:tls",
+	} {
+		if !strings.Contains(saved, want) {
+			t.Errorf("expected %q in saved config:\n%s", want, saved)
+		}
+	}
+	// unchanged values that were not in the file are not written
+	for _, unwanted := range []string{"dbpath", "published_public_ports"} {
+		if strings.Contains(saved, unwanted) {
+			t.Errorf("didn't expect %q in saved config:\n%s", unwanted, saved)
+		}
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	cfg := &Config{RetryTimes: 3, DBPath: "/tmp/private.db"}
+	if err := cfg.DecodeFile([]byte(testConfigFile), false); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RetryTimes != 5 || cfg.DBPath != "/tmp/private.db" {
+		t.Fatalf("wrong values %d %s", cfg.RetryTimes, cfg.DBPath)
+	}
+	if err := cfg.DecodeFile(nil, true); err != nil {
+		t.Fatalf("empty file should be valid: %v", err)
+	}
+	err := cfg.DecodeFile([]byte(testConfigFile), true)
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok || len(typeErr.Errors) != 1 || !strings.Contains(typeErr.Errors[0], "owner") {
+		t.Fatalf("expected an error for the unknown key but got %v", err)
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"time"
 
 	"github.com/diodechain/diode_client/util"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -44,9 +44,17 @@ type Config struct {
 	EnableUpdate     bool          `yaml:"update,omitempty" json:"update,omitempty"`
 	EnableMetrics    bool          `yaml:"metrics,omitempty" json:"metrics,omitempty"`
 	RemoteRPCAddrs   StringValues  `yaml:"diodeaddrs,omitempty" json:"diodeaddrs,omitempty"`
+	RelayPoolSize    int           `yaml:"relays,omitempty" json:"relays,omitempty"`
+	RelayPolicy      string        `yaml:"relaypolicy,omitempty" json:"relaypolicy,omitempty"`
+	PinnedRelays     StringValues  `yaml:"pinrelays,omitempty" json:"pinrelays,omitempty"`
+	ExcludedRelays   StringValues  `yaml:"excluderelays,omitempty" json:"excluderelays,omitempty"`
+	RelayRegion      string        `yaml:"relayregion,omitempty" json:"relayregion,omitempty"`
+	RelayHysteresis  time.Duration `yaml:"relayhysteresis,omitempty" json:"relayhysteresis,omitempty"`
 	RemoteRPCTimeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
 	RetryTimes       int           `yaml:"retrytimes,omitempty" json:"retrytimes,omitempty"`
 	RetryWait        time.Duration `yaml:"retrywait,omitempty" json:"retrywait,omitempty"`
+	ReconnectMin     time.Duration `yaml:"reconnectmin,omitempty" json:"reconnectmin,omitempty"`
+	ReconnectMax     time.Duration `yaml:"reconnectmax,omitempty" json:"reconnectmax,omitempty"`
 	RlimitNofile     int           `yaml:"rlimit_nofile,omitempty" json:"rlimit_nofile,omitempty"`
 	LogFilePath      string        `yaml:"logfilepath,omitempty" json:"logfilepath,omitempty"`
 	SBlocklists      StringValues  `yaml:"blocklists,omitempty" json:"blocklists,omitempty"`
@@ -54,56 +62,86 @@ type Config struct {
 	SBinds           StringValues  `yaml:"bind,omitempty" json:"bind,omitempty"`
 	CPUProfile       string        `yaml:"cpuprofile,omitempty" json:"-"`
 	// CPUProfileRate          int              `yaml:"cpuprofilerate,omitempty" json:"-"`
-	MEMProfile              string           `yaml:"memprofile,omitempty"`
-	PProfPort               int              `yaml:"pprofport,omitempty"`
-	BlockProfile            string           `yaml:"blockprofile,omitempty" json:"-"`
-	BlockProfileRate        int              `yaml:"blockprofilerate,omitempty" json:"-"`
-	MutexProfile            string           `yaml:"mutexprofile,omitempty" json:"-"`
-	MutexProfileRate        int              `yaml:"mutexprofilerate,omitempty" json:"-"`
-	Command                 string           `yaml:"-" json:"-"`
-	FleetAddr               Address          `yaml:"-" json:"-"`
-	ClientAddr              Address          `yaml:"-" json:"-"`
-	ClientName              string           `yaml:"-" json:"-"`
-	RegistryAddr            Address          `yaml:"-" json:"-"`
-	ProxyServerHost         string           `yaml:"-" json:"-"`
-	ProxyServerPort         int              `yaml:"-" json:"-"`
-	SProxyServerHost        string           `yaml:"-" json:"-"`
-	SProxyServerPort        int              `yaml:"-" json:"-"`
-	SProxyServerPorts       string           `yaml:"-" json:"-"`
-	SProxyServerCertPath    string           `yaml:"-" json:"-"`
-	SProxyServerPrivPath    string           `yaml:"-" json:"-"`
-	AllowRedirectToSProxy   bool             `yaml:"-" json:"-"`
-	APIServerAddr           string           `yaml:"-" json:"-"`
-	EnableAPIServer         bool             `yaml:"-" json:"-"`
-	EnableProxyServer       bool             `yaml:"-" json:"-"`
-	EnableSProxyServer      bool             `yaml:"-" json:"-"`
-	EnableSocksServer       bool             `yaml:"-" json:"-"`
-	SocksServerHost         string           `yaml:"-" json:"-"`
-	SocksServerPort         int              `yaml:"-" json:"-"`
-	SocksFallback           string           `yaml:"-" json:"-"`
-	ConfigUnsafe            bool             `yaml:"-" json:"-"`
-	ConfigList              bool             `yaml:"-" json:"-"`
-	ConfigDelete            StringValues     `yaml:"-" json:"-"`
-	ConfigSet               StringValues     `yaml:"-" json:"-"`
-	PublishedPorts          map[int]*Port    `yaml:"-" json:"-"`
-	PublicPublishedPorts    StringValues     `yaml:"published_public_ports,omitempty" json:"-"`
-	ProtectedPublishedPorts StringValues     `yaml:"published_protected_ports,omitempty" json:"-"`
-	PrivatePublishedPorts   StringValues     `yaml:"published_private_ports,omitempty" json:"-"`
-	Blocklists              map[Address]bool `yaml:"-" json:"-"`
-	Allowlists              map[Address]bool `yaml:"-" json:"-"`
-	LogMode                 int              `yaml:"-" json:"-"`
-	LogDateTime             bool             `yaml:"-" json:"-"`
-	Logger                  *Logger          `yaml:"-" json:"-"`
-	ConfigFilePath          string           `yaml:"-" json:"-"`
-	Binds                   []Bind           `yaml:"-" json:"-"`
-	BNSForce                bool             `yaml:"-" json:"-"`
-	BNSRegister             string           `yaml:"-" json:"-"`
-	BNSUnregister           string           `yaml:"-" json:"-"`
-	BNSTransfer             string           `yaml:"-" json:"-"`
-	BNSLookup               string           `yaml:"-" json:"-"`
-	BNSAccount              string           `yaml:"-" json:"-"`
-	Experimental            bool             `yaml:"-" json:"-"`
-	LoadFromFile            bool             `yaml:"-" json:"-"`
+	MEMProfile              string          `yaml:"memprofile,omitempty"`
+	PProfPort               int             `yaml:"pprofport,omitempty"`
+	BlockProfile            string          `yaml:"blockprofile,omitempty" json:"-"`
+	BlockProfileRate        int             `yaml:"blockprofilerate,omitempty" json:"-"`
+	MutexProfile            string          `yaml:"mutexprofile,omitempty" json:"-"`
+	MutexProfileRate        int             `yaml:"mutexprofilerate,omitempty" json:"-"`
+	Command                 string          `yaml:"-" json:"-"`
+	FleetAddr               Address         `yaml:"-" json:"-"`
+	SFleetAddr              string          `yaml:"fleet,omitempty" json:"-"`
+	ClientAddr              Address         `yaml:"-" json:"-"`
+	ClientName              string          `yaml:"-" json:"-"`
+	RegistryAddr            Address         `yaml:"-" json:"-"`
+	ProxyServerHost         string          `yaml:"-" json:"-"`
+	ProxyServerPort         int             `yaml:"-" json:"-"`
+	SProxyServerHost        string          `yaml:"-" json:"-"`
+	SProxyServerPort        int             `yaml:"-" json:"-"`
+	SProxyServerPorts       string          `yaml:"-" json:"-"`
+	SProxyServerCertPath    string          `yaml:"-" json:"-"`
+	SProxyServerPrivPath    string          `yaml:"-" json:"-"`
+	AllowRedirectToSProxy   bool            `yaml:"-" json:"-"`
+	APIServerAddr           string          `yaml:"apiaddr,omitempty" json:"-"`
+	EnableAPIServer         bool            `yaml:"api,omitempty" json:"-"`
+	EnableProxyServer       bool            `yaml:"-" json:"-"`
+	EnableSProxyServer      bool            `yaml:"-" json:"-"`
+	EnableSocksServer       bool            `yaml:"-" json:"-"`
+	SocksServerHost         string          `yaml:"-" json:"-"`
+	SocksServerPort         int             `yaml:"-" json:"-"`
+	SocksFallback           string          `yaml:"-" json:"-"`
+	EnableStaticServer      bool            `yaml:"-" json:"-"`
+	StaticServerRoot        string          `yaml:"-" json:"-"`
+	StaticServerHost        string          `yaml:"-" json:"-"`
+	StaticServerPort        int             `yaml:"-" json:"-"`
+	StaticServerIndexed     bool            `yaml:"-" json:"-"`
+	EdgeACME                bool            `yaml:"-" json:"-"`
+	EdgeACMEEmail           string          `yaml:"-" json:"-"`
+	Publish                 *PublishSection `yaml:"publish,omitempty" json:"-"`
+	Socksd                  *SocksdSection  `yaml:"socksd,omitempty" json:"-"`
+	Gateway                 *GatewaySection `yaml:"gateway,omitempty" json:"-"`
+	ConfigUnsafe            bool            `yaml:"-" json:"-"`
+	ConfigList              bool            `yaml:"-" json:"-"`
+	ConfigDelete            StringValues    `yaml:"-" json:"-"`
+	ConfigSet               StringValues    `yaml:"-" json:"-"`
+	ConfigEncrypt           bool            `yaml:"-" json:"-"`
+	ConfigDecrypt           bool            `yaml:"-" json:"-"`
+	ConfigVerify            bool            `yaml:"-" json:"-"`
+	ConfigRepair            bool            `yaml:"-" json:"-"`
+	ConfigExportKey         string          `yaml:"-" json:"-"`
+	ConfigImportKey         string          `yaml:"-" json:"-"`
+	ConfigForce             bool            `yaml:"-" json:"-"`
+	ConfigEffective         bool            `yaml:"-" json:"-"`
+	Passphrase              string          `yaml:"-" json:"-"`
+	Identity                string          `yaml:"identity,omitempty" json:"-"`
+	IdentityForce           bool            `yaml:"-" json:"-"`
+	PublishedPorts          map[int]*Port   `yaml:"-" json:"-"`
+	PublicPublishedPorts    StringValues    `yaml:"published_public_ports,omitempty" json:"-"`
+	ProtectedPublishedPorts StringValues    `yaml:"published_protected_ports,omitempty" json:"-"`
+	PrivatePublishedPorts   StringValues    `yaml:"published_private_ports,omitempty" json:"-"`
+	AccessLists             *AccessLists    `yaml:"-" json:"-"`
+	LogMode                 int             `yaml:"-" json:"-"`
+	LogDateTime             bool            `yaml:"logdatetime,omitempty" json:"-"`
+	Logger                  *Logger         `yaml:"-" json:"-"`
+	ConfigFilePath          string          `yaml:"-" json:"-"`
+	Binds                   []Bind          `yaml:"-" json:"-"`
+	BNSForce                bool            `yaml:"-" json:"-"`
+	BNSRegister             string          `yaml:"-" json:"-"`
+	BNSUnregister           string          `yaml:"-" json:"-"`
+	BNSTransfer             string          `yaml:"-" json:"-"`
+	BNSLookup               string          `yaml:"-" json:"-"`
+	BNSAccount              string          `yaml:"-" json:"-"`
+	Experimental            bool            `yaml:"-" json:"-"`
+	LoadFromFile            bool            `yaml:"-" json:"-"`
+	TicketBytes             int             `yaml:"ticketbytes,omitempty" json:"ticketbytes,omitempty"`
+	TicketConnections       int             `yaml:"ticketconnections,omitempty" json:"ticketconnections,omitempty"`
+	TicketInterval          time.Duration   `yaml:"ticketinterval,omitempty" json:"ticketinterval,omitempty"`
+	TicketMode              string          `yaml:"ticketmode,omitempty" json:"ticketmode,omitempty"`
+	TicketMaxUnsent         int             `yaml:"ticketmaxunsent,omitempty" json:"ticketmaxunsent,omitempty"`
+	Checkpoint              string          `yaml:"checkpoint,omitempty" json:"checkpoint,omitempty"`
+	CheckpointSigner        string          `yaml:"checkpointsigner,omitempty" json:"checkpointsigner,omitempty"`
+	// tracked are the values of the last TrackChanges call
+	tracked *yaml.Node
 }
 
 // LoadConfigFromFile returns bytes data of config
@@ -134,30 +172,6 @@ func LoadConfigFromFile(filePath string) (configBytes []byte, err error) {
 	return
 }
 
-// SaveToFile store yaml config to ConfigFilePath
-func (cfg *Config) SaveToFile() (err error) {
-	if !cfg.LoadFromFile {
-		err = errConfigNotLoadedFromFile
-		return
-	}
-	var out []byte
-	var f *os.File
-	out, err = yaml.Marshal(cfg)
-	if err != nil {
-		return
-	}
-	f, err = os.OpenFile(cfg.ConfigFilePath, os.O_WRONLY|os.O_TRUNC, 0600)
-	if err != nil {
-		return
-	}
-	// this will break comment option in config file
-	_, err = f.Write(out)
-	if err != nil {
-		return
-	}
-	return
-}
-
 // SocksServerAddr returns address that socks proxy listen to
 func (cfg *Config) SocksServerAddr() string {
 	return fmt.Sprintf("%s:%d", cfg.SocksServerHost, cfg.SocksServerPort)
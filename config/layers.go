@@ -0,0 +1,254 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The config is built from these layers, later layers take precedence:
+// flag defaults < config file < DIODE_* environment variables < flags
+const (
+	envPrefix    = "DIODE_"
+	layerDefault = "default"
+	layerFile    = "file"
+	layerEnv     = "env"
+	layerFlag    = "flag"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Field is a field of Config that can be set by the user
+type Field struct {
+	Name string
+	Env  string
+	// Layer and Origin tell where the value came from, e.g. the flag name,
+	// the environment variable or the config file path
+	Layer  string
+	Origin string
+	index  int
+	flag   *flag.Flag
+	set    bool
+}
+
+// Fields returns the fields that can be set with the flags or the config
+// file, the flags of the flag sets have to be bound to the fields of cfg
+func (cfg *Config) Fields(flagSets ...*flag.FlagSet) (fields []Field) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	addrs := make(map[uintptr]int)
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			addrs[v.Field(i).Addr().Pointer()] = i
+		}
+	}
+	covered := make(map[int]bool)
+	for _, flagSet := range flagSets {
+		explicit := make(map[string]bool)
+		flagSet.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = true
+		})
+		flagSet.VisitAll(func(f *flag.Flag) {
+			// the flag value points to the config field it's bound to
+			ptr := reflect.ValueOf(f.Value)
+			if ptr.Kind() != reflect.Ptr {
+				return
+			}
+			i, ok := addrs[ptr.Pointer()]
+			if !ok || covered[i] || !settable(t.Field(i).Type) {
+				return
+			}
+			covered[i] = true
+			fields = append(fields, Field{
+				Name:  f.Name,
+				Env:   envName(f.Name),
+				index: i,
+				flag:  f,
+				set:   explicit[f.Name],
+			})
+		})
+	}
+	// values that are only read from the config file
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if covered[i] || key == "" || key == "-" || !settable(t.Field(i).Type) {
+			continue
+		}
+		fields = append(fields, Field{
+			Name:  key,
+			Env:   envName(key),
+			index: i,
+		})
+	}
+	return
+}
+
+// LoadLayers sets the fields of cfg from the layers and records in fields
+// where each value came from, flags is the config after the command line has
+// been parsed and command selects the section of the config file
+func (cfg *Config) LoadLayers(flags *Config, command string, fields []Field) (err error) {
+	v := reflect.ValueOf(cfg).Elem()
+	fv := reflect.ValueOf(flags).Elem()
+	pathField := -1
+	for i := range fields {
+		field := &fields[i]
+		field.Layer = layerDefault
+		def := ""
+		if field.flag != nil {
+			def = field.flag.DefValue
+		}
+		if err = setField(v.Field(field.index), def); err != nil {
+			return fmt.Errorf("invalid default value %s for %s: %v", def, field.Name, err)
+		}
+		if v.Type().Field(field.index).Name == "ConfigFilePath" {
+			pathField = i
+		}
+	}
+
+	// the path of the config file can't be set in the file
+	if pathField >= 0 {
+		if err = fields[pathField].override(v, fv); err != nil {
+			return
+		}
+	}
+	cfg.LoadFromFile = false
+	if len(cfg.ConfigFilePath) > 0 {
+		if err = cfg.loadFileLayer(command, fields); err != nil {
+			return
+		}
+	}
+	for i := range fields {
+		if err = fields[i].override(v, fv); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// loadFileLayer applies the config file and the section of the command
+func (cfg *Config) loadFileLayer(command string, fields []Field) error {
+	cfgBytes, err := LoadConfigFromFile(cfg.ConfigFilePath)
+	if err != nil {
+		return err
+	}
+	before := *cfg
+	if err = cfg.DecodeFile(cfgBytes, false); err != nil {
+		return fmt.Errorf("couldn't parse config file %s: %v", cfg.ConfigFilePath, err)
+	}
+	if len(command) > 0 {
+		cfg.ApplySection(command)
+	}
+	cfg.LoadFromFile = true
+	v := reflect.ValueOf(cfg).Elem()
+	bv := reflect.ValueOf(&before).Elem()
+	for i := range fields {
+		field := &fields[i]
+		if !reflect.DeepEqual(v.Field(field.index).Interface(), bv.Field(field.index).Interface()) {
+			field.Layer = layerFile
+			field.Origin = cfg.ConfigFilePath
+		}
+	}
+	return nil
+}
+
+// override applies the environment variable and the flag of the field
+func (field *Field) override(v reflect.Value, flags reflect.Value) error {
+	if value, ok := os.LookupEnv(field.Env); ok {
+		if err := setField(v.Field(field.index), value); err != nil {
+			return fmt.Errorf("invalid value %s for %s: %v", value, field.Env, err)
+		}
+		field.Layer = layerEnv
+		field.Origin = field.Env
+	}
+	if field.set {
+		v.Field(field.index).Set(flags.Field(field.index))
+		field.Layer = layerFlag
+		field.Origin = "-" + field.Name
+	}
+	return nil
+}
+
+// Value returns the value of the field in cfg
+func (field *Field) Value(cfg *Config) interface{} {
+	return reflect.ValueOf(cfg).Elem().Field(field.index).Interface()
+}
+
+// settable returns true for the field types that can be parsed by setField
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setField parses the value like the flag package does, lists are separated
+// by whitespace since port definitions may contain commas
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		if len(value) == 0 {
+			field.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		if len(value) == 0 {
+			field.SetInt(0)
+			return nil
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case reflect.Int64:
+		if field.Type() != durationType {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		if len(value) == 0 {
+			field.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case reflect.Slice:
+		values := strings.Fields(value)
+		if len(values) == 0 {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		list := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			list.Index(i).SetString(value)
+		}
+		field.Set(list)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
@@ -0,0 +1,132 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testLayersFile = `retrytimes: 5
+debug: true
+relayregion: europe
+diodeaddrs:
+  - file.diode.io:41046
+`
+
+func setEnv(t *testing.T, key string, value string) {
+	os.Setenv(key, value)
+	t.Cleanup(func() { os.Unsetenv(key) })
+}
+
+// loadTestLayers binds a few flags to a config, parses args and loads the
+// layers on top of them
+func loadTestLayers(t *testing.T, args ...string) (*Config, map[string]Field, error) {
+	bound := &Config{}
+	fs := flag.NewFlagSet("diode", flag.ContinueOnError)
+	fs.StringVar(&bound.ConfigFilePath, "configpath", "", "")
+	fs.IntVar(&bound.RetryTimes, "retrytimes", 3, "")
+	fs.BoolVar(&bound.Debug, "debug", false, "")
+	fs.DurationVar(&bound.RetryWait, "retrywait", time.Second, "")
+	fs.Var(&bound.RemoteRPCAddrs, "diodeaddrs", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	flags := *bound
+	cfg := &Config{}
+	fields := bound.Fields(fs)
+	err := cfg.LoadLayers(&flags, "", fields)
+	byName := make(map[string]Field, len(fields))
+	for _, field := range fields {
+		byName[field.Name] = field
+	}
+	return cfg, byName, err
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diode_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "diode.yml")
+	if err = ioutil.WriteFile(path, []byte(testLayersFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, "DIODE_RETRYTIMES", "7")
+	setEnv(t, "DIODE_DEBUG", "false")
+	setEnv(t, "DIODE_DIODEADDRS", " env1.diode.io:41046\n\tenv2.diode.io:41046 ")
+
+	cfg, fields, err := loadTestLayers(t, "-configpath", path, "-retrytimes", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		value  interface{}
+		layer  string
+		origin string
+	}{
+		// flags take precedence over the environment and the file
+		{"retrytimes", 9, layerFlag, "-retrytimes"},
+		// the environment takes precedence over the file
+		{"debug", false, layerEnv, "DIODE_DEBUG"},
+		{"diodeaddrs", StringValues{"env1.diode.io:41046", "env2.diode.io:41046"}, layerEnv, "DIODE_DIODEADDRS"},
+		// values that are only read from the file
+		{"relayregion", "europe", layerFile, path},
+		{"retrywait", time.Second, layerDefault, ""},
+		{"configpath", path, layerFlag, "-configpath"},
+	}
+	for _, test := range tests {
+		field, ok := fields[test.name]
+		if !ok {
+			t.Fatalf("%s should be a config field", test.name)
+		}
+		if value := field.Value(cfg); !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%s should be %v but is %v", test.name, test.value, value)
+		}
+		if field.Layer != test.layer || field.Origin != test.origin {
+			t.Errorf("%s should come from %s %s but came from %s %s", test.name, test.layer, test.origin, field.Layer, field.Origin)
+		}
+	}
+	if !cfg.LoadFromFile {
+		t.Errorf("config should be loaded from the file")
+	}
+}
+
+func TestLoadLayersEnv(t *testing.T) {
+	// an empty list clears the default
+	setEnv(t, "DIODE_DIODEADDRS", " ")
+	setEnv(t, "DIODE_RETRYWAIT", "")
+	cfg, fields, err := loadTestLayers(t, "-diodeaddrs", "flag.diode.io:41046")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["diodeaddrs"].Layer != layerFlag || len(cfg.RemoteRPCAddrs) != 1 {
+		t.Errorf("flag should take precedence over the environment but got %v", cfg.RemoteRPCAddrs)
+	}
+	if fields["retrywait"].Layer != layerEnv || cfg.RetryWait != 0 {
+		t.Errorf("empty environment variable should reset the duration but got %s", cfg.RetryWait)
+	}
+
+	os.Unsetenv("DIODE_DIODEADDRS")
+	setEnv(t, "DIODE_PINRELAYS", "\t")
+	cfg, fields, err = loadTestLayers(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["pinrelays"].Layer != layerEnv || cfg.PinnedRelays != nil {
+		t.Errorf("whitespace should be an empty list but got %v", cfg.PinnedRelays)
+	}
+
+	setEnv(t, "DIODE_RETRYTIMES", "many")
+	if _, _, err = loadTestLayers(t); err == nil {
+		t.Errorf("invalid number in the environment should be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package config
+
+import (
+	"fmt"
+)
+
+// The settings of a command are read from the section with the name of the
+// command, the keys of a section are the flag names of the command. Only the
+// section of the running command is applied, e.g.:
+//
+//   fleet: 0x6000000000000000000000000000000000000000
+//   bind:
+//     - 8080:betahaus.diode:80:tls
+//   publish:
+//     public:
+//       - 80:80
+//     http: true
+//     http_dir: /var/www
+//   socksd:
+//     socksd_port: 1080
+//   gateway:
+//     httpd_port: 8080
+//     secure: true
+//     certpath: /etc/diode/cert.pem
+//     privpath: /etc/diode/priv.pem
+
+// PublishSection is the publish section of the config file
+type PublishSection struct {
+	Public      StringValues `yaml:"public,omitempty"`
+	Protected   StringValues `yaml:"protected,omitempty"`
+	Private     StringValues `yaml:"private,omitempty"`
+	ProxyHost   *string      `yaml:"proxy_host,omitempty"`
+	ProxyPort   *int         `yaml:"proxy_port,omitempty"`
+	Socksd      *bool        `yaml:"socksd,omitempty"`
+	HTTP        *bool        `yaml:"http,omitempty"`
+	HTTPDir     *string      `yaml:"http_dir,omitempty"`
+	HTTPHost    *string      `yaml:"http_host,omitempty"`
+	HTTPPort    *int         `yaml:"http_port,omitempty"`
+	HTTPIndexed *bool        `yaml:"indexed,omitempty"`
+}
+
+// SocksdSection is the socksd section of the config file
+type SocksdSection struct {
+	Host     *string `yaml:"socksd_host,omitempty"`
+	Port     *int    `yaml:"socksd_port,omitempty"`
+	Fallback *string `yaml:"fallback,omitempty"`
+}
+
+// GatewaySection is the gateway section of the config file
+type GatewaySection struct {
+	ProxyHost       *string `yaml:"proxy_host,omitempty"`
+	ProxyPort       *int    `yaml:"proxy_port,omitempty"`
+	Socksd          *bool   `yaml:"socksd,omitempty"`
+	Fallback        *string `yaml:"fallback,omitempty"`
+	HTTPDHost       *string `yaml:"httpd_host,omitempty"`
+	HTTPDPort       *int    `yaml:"httpd_port,omitempty"`
+	HTTPSDHost      *string `yaml:"httpsd_host,omitempty"`
+	HTTPSDPort      *int    `yaml:"httpsd_port,omitempty"`
+	AdditionalPorts *string `yaml:"additional_ports,omitempty"`
+	CertPath        *string `yaml:"certpath,omitempty"`
+	PrivPath        *string `yaml:"privpath,omitempty"`
+	Secure          *bool   `yaml:"secure,omitempty"`
+	AllowRedirect   *bool   `yaml:"allow_redirect,omitempty"`
+	EdgeACME        *bool   `yaml:"edge_acme,omitempty"`
+	EdgeACMEEmail   *string `yaml:"edge_acme_email,omitempty"`
+}
+
+// FieldError is an invalid value in the config file
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if len(e.Value) == 0 {
+		return fmt.Sprintf("%s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: invalid value '%s': %v", e.Field, e.Value, e.Err)
+}
+
+// ApplySection copies the values that are set in the section of the command
+// to the config
+func (cfg *Config) ApplySection(command string) {
+	switch command {
+	case "publish":
+		cfg.applyPublishSection()
+	case "socksd":
+		cfg.applySocksdSection()
+	case "gateway":
+		cfg.applyGatewaySection()
+	}
+}
+
+func (cfg *Config) applyPublishSection() {
+	s := cfg.Publish
+	if s == nil {
+		return
+	}
+	if len(s.Public) > 0 {
+		cfg.PublicPublishedPorts = s.Public
+	}
+	if len(s.Protected) > 0 {
+		cfg.ProtectedPublishedPorts = s.Protected
+	}
+	if len(s.Private) > 0 {
+		cfg.PrivatePublishedPorts = s.Private
+	}
+	setString(&cfg.SocksServerHost, s.ProxyHost)
+	setInt(&cfg.SocksServerPort, s.ProxyPort)
+	setBool(&cfg.EnableSocksServer, s.Socksd)
+	setBool(&cfg.EnableStaticServer, s.HTTP)
+	setString(&cfg.StaticServerRoot, s.HTTPDir)
+	setString(&cfg.StaticServerHost, s.HTTPHost)
+	setInt(&cfg.StaticServerPort, s.HTTPPort)
+	setBool(&cfg.StaticServerIndexed, s.HTTPIndexed)
+}
+
+func (cfg *Config) applySocksdSection() {
+	s := cfg.Socksd
+	if s == nil {
+		return
+	}
+	setString(&cfg.SocksServerHost, s.Host)
+	setInt(&cfg.SocksServerPort, s.Port)
+	setString(&cfg.SocksFallback, s.Fallback)
+}
+
+func (cfg *Config) applyGatewaySection() {
+	s := cfg.Gateway
+	if s == nil {
+		return
+	}
+	setString(&cfg.SocksServerHost, s.ProxyHost)
+	setInt(&cfg.SocksServerPort, s.ProxyPort)
+	setBool(&cfg.EnableSocksServer, s.Socksd)
+	setString(&cfg.SocksFallback, s.Fallback)
+	setString(&cfg.ProxyServerHost, s.HTTPDHost)
+	setInt(&cfg.ProxyServerPort, s.HTTPDPort)
+	setString(&cfg.SProxyServerHost, s.HTTPSDHost)
+	setInt(&cfg.SProxyServerPort, s.HTTPSDPort)
+	setString(&cfg.SProxyServerPorts, s.AdditionalPorts)
+	setString(&cfg.SProxyServerCertPath, s.CertPath)
+	setString(&cfg.SProxyServerPrivPath, s.PrivPath)
+	setBool(&cfg.EnableSProxyServer, s.Secure)
+	setBool(&cfg.AllowRedirectToSProxy, s.AllowRedirect)
+	setBool(&cfg.EdgeACME, s.EdgeACME)
+	setString(&cfg.EdgeACMEEmail, s.EdgeACMEEmail)
+}
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setInt(dst *int, src *int) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
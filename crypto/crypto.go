@@ -19,6 +19,7 @@ const ecPrivKeyVersion = 1
 
 var (
 	secp256k1N, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	oidSecp256k1  = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
 )
 
 // Sha3 hash
@@ -88,6 +89,25 @@ func DerToECDSA(derD []byte) (*ecdsa.PrivateKey, error) {
 	return toECDSA(privKey.PrivateKey, true)
 }
 
+// ECDSAToDer returns the der encoded private key, the inverse of DerToECDSA.
+func ECDSAToDer(priv *ecdsa.PrivateKey) ([]byte, error) {
+	pub := MarshalPubkey(&priv.PublicKey)
+	return asn1.Marshal(ECPrivateKey{
+		Version:       ecPrivKeyVersion,
+		PrivateKey:    paddedBigBytes(priv.D, 32),
+		NamedCurveOID: oidSecp256k1,
+		PublicKey:     asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+}
+
+// paddedBigBytes returns the big endian bytes of num padded to size bytes
+func paddedBigBytes(num *big.Int, size int) []byte {
+	ret := make([]byte, size)
+	b := num.Bytes()
+	copy(ret[size-len(b):], b)
+	return ret
+}
+
 // ToECDSA creates a private key with the given D value.
 func ToECDSA(d []byte) (*ecdsa.PrivateKey, error) {
 	return toECDSA(d, true)
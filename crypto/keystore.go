@@ -0,0 +1,230 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keystore files follow the Web3 Secret Storage Definition (version 3)
+// https://github.com/ethereum/wiki/wiki/Web3-Secret-Storage-Definition
+const (
+	keystoreVersion = 3
+	keystoreCipher  = "aes-128-ctr"
+	keystoreDKLen   = 32
+	// same parameters as the standard scrypt setting of geth
+	keystoreScryptN = 1 << 18
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+	// limits of the parameters of imported files, so that a crafted file
+	// can't exhaust the memory or hang the process
+	maxKeystoreDKLen      = 64
+	maxKeystoreScryptN    = 1 << 20
+	maxKeystoreScryptR    = 8
+	maxKeystoreScryptP    = 16
+	maxKeystoreIterations = 10000000
+)
+
+var (
+	ErrKeystoreMAC     = errors.New("could not decrypt key with given passphrase")
+	ErrKeystoreVersion = errors.New("unsupported keystore version")
+)
+
+type keystoreJSON struct {
+	Address string         `json:"address"`
+	Crypto  keystoreCrypto `json:"crypto"`
+	ID      string         `json:"id"`
+	Version int            `json:"version"`
+}
+
+type keystoreCrypto struct {
+	Cipher       string                 `json:"cipher"`
+	CipherText   string                 `json:"ciphertext"`
+	CipherParams keystoreCipherParams   `json:"cipherparams"`
+	KDF          string                 `json:"kdf"`
+	KDFParams    map[string]interface{} `json:"kdfparams"`
+	MAC          string                 `json:"mac"`
+}
+
+type keystoreCipherParams struct {
+	IV string `json:"iv"`
+}
+
+// EncryptKeyV3 encrypts the private key into the json of a V3 keystore file
+func EncryptKeyV3(key *ecdsa.PrivateKey, passphrase string) ([]byte, error) {
+	return encryptKeyV3(key, passphrase, keystoreScryptN, keystoreScryptR, keystoreScryptP)
+}
+
+func encryptKeyV3(key *ecdsa.PrivateKey, passphrase string, scryptN int, scryptR int, scryptP int) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keystoreDKLen)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := aesCTR(derivedKey[:16], iv, paddedBigBytes(key.D, 32))
+	if err != nil {
+		return nil, err
+	}
+	// UUID version 4
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	ks := keystoreJSON{
+		Address: hex.EncodeToString(keystoreAddress(&key.PublicKey)),
+		Crypto: keystoreCrypto{
+			Cipher:       keystoreCipher,
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: keystoreCipherParams{IV: hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams: map[string]interface{}{
+				"n":     scryptN,
+				"r":     scryptR,
+				"p":     scryptP,
+				"dklen": keystoreDKLen,
+				"salt":  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(keystoreMAC(derivedKey, cipherText)),
+		},
+		ID:      fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Version: keystoreVersion,
+	}
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeyV3 decrypts the private key from the json of a V3 keystore file
+func DecryptKeyV3(data []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	var ks keystoreJSON
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != keystoreVersion {
+		return nil, ErrKeystoreVersion
+	}
+	if ks.Crypto.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("unsupported keystore cipher %s", ks.Crypto.Cipher)
+	}
+	cipherText, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(ks.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(ks.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	derivedKey, err := keystoreDerivedKey(ks.Crypto, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(keystoreMAC(derivedKey, cipherText), mac) {
+		return nil, ErrKeystoreMAC
+	}
+	d, err := aesCTR(derivedKey[:16], iv, cipherText)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ToECDSA(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(ks.Address) > 0 {
+		address := hex.EncodeToString(keystoreAddress(&key.PublicKey))
+		if strings.TrimPrefix(strings.ToLower(ks.Address), "0x") != address {
+			return nil, fmt.Errorf("keystore address %s does not match the key", ks.Address)
+		}
+	}
+	return key, nil
+}
+
+func keystoreDerivedKey(c keystoreCrypto, passphrase string) ([]byte, error) {
+	salt, err := hex.DecodeString(keystoreParamString(c.KDFParams, "salt"))
+	if err != nil {
+		return nil, err
+	}
+	dkLen := keystoreParamInt(c.KDFParams, "dklen")
+	if dkLen < keystoreDKLen || dkLen > maxKeystoreDKLen {
+		return nil, fmt.Errorf("keystore dklen=%d is out of range", dkLen)
+	}
+	switch c.KDF {
+	case "scrypt":
+		n := keystoreParamInt(c.KDFParams, "n")
+		r := keystoreParamInt(c.KDFParams, "r")
+		p := keystoreParamInt(c.KDFParams, "p")
+		if n < 2 || n > maxKeystoreScryptN || r < 1 || r > maxKeystoreScryptR || p < 1 || p > maxKeystoreScryptP {
+			return nil, fmt.Errorf("keystore scrypt parameters n=%d r=%d p=%d are out of range", n, r, p)
+		}
+		return scrypt.Key([]byte(passphrase), salt, n, r, p, dkLen)
+	case "pbkdf2":
+		if prf := keystoreParamString(c.KDFParams, "prf"); prf != "hmac-sha256" {
+			return nil, fmt.Errorf("unsupported keystore prf %s", prf)
+		}
+		iterations := keystoreParamInt(c.KDFParams, "c")
+		if iterations < 1 || iterations > maxKeystoreIterations {
+			return nil, fmt.Errorf("keystore pbkdf2 iterations c=%d are out of range", iterations)
+		}
+		return pbkdf2.Key([]byte(passphrase), salt, iterations, dkLen, sha256.New), nil
+	}
+	return nil, fmt.Errorf("unsupported keystore kdf %s", c.KDF)
+}
+
+func keystoreParamInt(params map[string]interface{}, name string) int {
+	// encoding/json decodes all numbers to float64
+	value, _ := params[name].(float64)
+	if value < 0 || value > math.MaxInt32 {
+		return -1
+	}
+	return int(value)
+}
+
+func keystoreParamString(params map[string]interface{}, name string) string {
+	value, _ := params[name].(string)
+	return value
+}
+
+func keystoreMAC(derivedKey []byte, cipherText []byte) []byte {
+	return Sha3Hash(append(append([]byte{}, derivedKey[16:32]...), cipherText...))
+}
+
+func aesCTR(key []byte, iv []byte, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid keystore iv length %d", len(iv))
+	}
+	out := make([]byte, len(in))
+	cipher.NewCTR(block, iv).XORKeyStream(out, in)
+	return out, nil
+}
+
+// keystoreAddress returns the 20 byte address of the public key
+func keystoreAddress(pub *ecdsa.PublicKey) []byte {
+	return Sha3Hash(MarshalPubkey(pub)[1:])[12:]
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test vector from the Web3 Secret Storage Definition
+var keystoreTestVector = `{
+	"crypto": {
+		"cipher": "aes-128-ctr",
+		"cipherparams": {"iv": "83dbcc02d8ccb40e466191a123791e0e"},
+		"ciphertext": "d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c",
+		"kdf": "scrypt",
+		"kdfparams": {
+			"dklen": 32,
+			"n": 262144,
+			"p": 8,
+			"r": 1,
+			"salt": "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"
+		},
+		"mac": "2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3097"
+	},
+	"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+	"version": 3
+}`
+
+func TestDecryptKeyV3(t *testing.T) {
+	key, err := DecryptKeyV3([]byte(keystoreTestVector), "testpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(key.D.Bytes()) != "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d" {
+		t.Errorf("Decrypted wrong private key %x", key.D.Bytes())
+	}
+	if _, err = DecryptKeyV3([]byte(keystoreTestVector), "wrong"); err != ErrKeystoreMAC {
+		t.Errorf("Expected ErrKeystoreMAC but got: %v", err)
+	}
+}
+
+func TestDecryptKeyV3Limits(t *testing.T) {
+	crafted := []string{
+		strings.Replace(keystoreTestVector, `"n": 262144`, `"n": 1073741824`, 1),
+		strings.Replace(keystoreTestVector, `"r": 1`, `"r": 1024`, 1),
+		strings.Replace(keystoreTestVector, `"dklen": 32`, `"dklen": 1e12`, 1),
+		strings.Replace(keystoreTestVector, `"kdf": "scrypt"`, `"kdf": "pbkdf2"`, 1),
+	}
+	crafted[3] = strings.Replace(crafted[3], `"n": 262144`, `"c": 1e15, "prf": "hmac-sha256"`, 1)
+	for _, keystore := range crafted {
+		if _, err := DecryptKeyV3([]byte(keystore), "testpassword"); err == nil || !strings.Contains(err.Error(), "range") {
+			t.Errorf("Expected an out of range error but got: %v", err)
+		}
+	}
+}
+
+func TestEncryptKeyV3(t *testing.T) {
+	key, err := HexToECDSA("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use light scrypt parameters to keep the test fast
+	data, err := encryptKeyV3(key, "testpassword", 1<<12, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := DecryptKeyV3(data, "testpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.D.Cmp(key.D) != 0 {
+		t.Errorf("Keystore round trip returned a different key")
+	}
+	der, err := ECDSAToDer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := DerToECDSA(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.D.Cmp(key.D) != 0 {
+		t.Errorf("Der round trip returned a different key")
+	}
+}
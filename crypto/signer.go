@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+
+	"github.com/diodechain/diode_client/crypto/secp256k1"
+)
+
+var errSignatureMismatch = errors.New("signature does not match the public key")
+
+// Signer creates the signatures of the client identity, the private key
+// itself doesn't need to be accessible.
+type Signer interface {
+	// PublicKey returns the uncompressed public key
+	PublicKey() ([]byte, error)
+	// Sign returns the recoverable signature [recid | r | s] of the 32 byte hash
+	Sign(hash []byte) ([]byte, error)
+}
+
+// KeySigner is a Signer for a private key in memory
+type KeySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewKeySigner returns a Signer for the given private key
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key}
+}
+
+// PublicKey returns the uncompressed public key
+func (s *KeySigner) PublicKey() ([]byte, error) {
+	return MarshalPubkey(&s.key.PublicKey), nil
+}
+
+// Sign returns the recoverable signature of the hash
+func (s *KeySigner) Sign(hash []byte) ([]byte, error) {
+	return secp256k1.Sign(hash, paddedBigBytes(s.key.D, 32))
+}
+
+// VerifySignature checks that sig is a signature of hash by the public key
+func VerifySignature(pubKey []byte, hash []byte, sig []byte) error {
+	recovered, err := secp256k1.RecoverPubkey(hash, sig)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(recovered, pubKey) {
+		return errSignatureMismatch
+	}
+	return nil
+}
@@ -4,9 +4,10 @@
 package db
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -16,7 +17,17 @@ import (
 )
 
 const (
+	// databaseVersionMagic is the legacy format, a full snapshot of all
+	// key/value pairs that was rewritten on every change
 	databaseVersionMagic uint64 = 4389235283
+	// databaseJournalMagic is an append-only log of put/del records
+	// with a checksum for each record
+	databaseJournalMagic uint64 = 4389235284
+
+	// the journal is compacted when it's both larger than compactionMinSize
+	// and compactionFactor times larger than the live data
+	compactionMinSize = 64 * 1024
+	compactionFactor  = 4
 )
 
 var (
@@ -29,10 +40,14 @@ var (
 type Database struct {
 	path   string
 	values map[string][]byte
-	// maybe write data async in the future
-	// dirty bool
-	rm     sync.Mutex
-	buffer []byte
+	// journal is kept open for appending records
+	journal *os.File
+	// logSize is the size of the journal, liveSize is the size the
+	// journal would have after compaction
+	logSize    int64
+	liveSize   int64
+	rm         sync.Mutex
+	passphrase []byte
 }
 
 func OpenFile(filepath string) (*Database, error) {
@@ -56,39 +71,98 @@ func OpenFile(filepath string) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer func(f *os.File) {
-		f.Close()
-	}(f)
-	r := bufio.NewReader(f)
-	magic, _ := binary.ReadUvarint(r)
-	values := make(map[string][]byte)
-	if magic == databaseVersionMagic {
-		numTuples, err := binary.ReadUvarint(r)
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{
+		path:   filepath,
+		values: make(map[string][]byte),
+	}
+	if len(data) == 0 {
+		return db, db.compact()
+	}
+
+	magic, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, CorruptionError{Path: filepath, Err: ErrUnknownFormat}
+	}
+	switch magic {
+	case databaseJournalMagic:
+		valid, err := db.replay(data, n)
 		if err != nil {
 			return nil, err
 		}
-		for i := numTuples; i > 0; i-- {
-			size64, _ := binary.ReadUvarint(r)
-			key := make([]byte, size64)
-			size, err := r.Read(key)
-			if size != len(key) || err != nil {
+		db.logSize = int64(valid)
+		db.liveSize = db.snapshotSize()
+		if valid < len(data) {
+			// The last record was only partially written, e.g. because of
+			// a power loss. Cut it off so new records can be appended.
+			log.Printf("Truncating incomplete database record in %s\n", filepath)
+			if err = os.Truncate(filepath, int64(valid)); err != nil {
 				return nil, err
 			}
-			size64, _ = binary.ReadUvarint(r)
-			value := make([]byte, size64)
-			size, err = r.Read(value)
-			if size != len(value) || err != nil {
-				return nil, err
+		}
+		if err = db.openJournal(); err != nil {
+			return nil, err
+		}
+	case databaseVersionMagic:
+		values, offset, err := parseSnapshot(data[n:])
+		if err != nil {
+			return nil, CorruptionError{Path: filepath, Offset: int64(n + offset), Err: err}
+		}
+		db.values = values
+		// Upgrade to the journal format
+		if err = db.compact(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, CorruptionError{Path: filepath, Err: ErrUnknownFormat}
+	}
+	return db, nil
+}
+
+// replay applies all records starting at offset and returns the offset
+// of the end of the last complete record
+func (db *Database) replay(data []byte, offset int) (int, error) {
+	for offset < len(data) {
+		rec, n, err := parseRecord(data[offset:])
+		if err != nil {
+			// A damaged length can look like an incomplete record, so it's
+			// only the tail when no intact record follows
+			if isTornTail(data[offset:], err) && nextRecord(data, offset) == len(data) {
+				return offset, nil
 			}
-			values[string(key)] = value
+			return offset, CorruptionError{Path: db.path, Offset: int64(offset), Err: err}
 		}
+		db.apply(rec)
+		offset += n
 	}
-	db := &Database{
-		path:   filepath,
-		values: values,
-		buffer: make([]byte, 1024),
+	return offset, nil
+}
+
+func (db *Database) apply(rec record) {
+	switch rec.op {
+	case recordPut:
+		db.values[rec.key] = rec.value
+	case recordDel:
+		delete(db.values, rec.key)
 	}
-	return db, nil
+}
+
+// isTornTail returns true when the damaged record is the last
+// record and could have been caused by an interrupted write, a complete
+// record with a wrong checksum is corruption and never torn
+func isTornTail(rest []byte, err error) bool {
+	switch err {
+	case errShortRecord:
+		return true
+	case errZeroRecord:
+		return isZero(rest)
+	}
+	return false
 }
 
 // Get reads data from the file database
@@ -106,16 +180,29 @@ func (db *Database) Get(key string) ([]byte, error) {
 func (db *Database) Put(key string, value []byte) (err error) {
 	db.rm.Lock()
 	defer db.rm.Unlock()
+	old, ok := db.values[key]
+	if ok {
+		if bytes.Equal(old, value) {
+			return nil
+		}
+		db.liveSize -= recordSize(key, old)
+	}
 	db.values[key] = value
-	return db.store()
+	db.liveSize += recordSize(key, value)
+	return db.append(recordPut, key, value)
 }
 
 // Del deletes data from the file database
 func (db *Database) Del(key string) (err error) {
 	db.rm.Lock()
 	defer db.rm.Unlock()
+	old, ok := db.values[key]
+	if !ok {
+		return nil
+	}
 	delete(db.values, key)
-	return db.store()
+	db.liveSize -= recordSize(key, old)
+	return db.append(recordDel, key, nil)
 }
 
 // List returns all keys
@@ -130,34 +217,100 @@ func (db *Database) List() []string {
 	return list
 }
 
-func (db *Database) store() error {
-	f, err := os.OpenFile(db.path+".tmp", os.O_CREATE|os.O_WRONLY, 0600)
+// Compact rewrites the journal so that it only contains the current values
+func (db *Database) Compact() error {
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	return db.compact()
+}
+
+// append writes a single record to the end of the journal and
+// syncs it to disk before returning, a closed journal is reopened so that
+// values can still be stored during shutdown
+func (db *Database) append(op byte, key string, value []byte) error {
+	if db.journal == nil {
+		if err := db.openJournal(); err != nil {
+			return err
+		}
+	}
+	buf := encodeRecord(op, key, value)
+	n, err := db.journal.Write(buf)
 	if err != nil {
 		return err
 	}
-	w := bufio.NewWriter(f)
-	err = db.put(w, databaseVersionMagic)
-	if err != nil {
+	if n != len(buf) {
+		return ErrSizeDidNotMatch
+	}
+	if err = db.journal.Sync(); err != nil {
 		return err
 	}
-	err = db.put(w, uint64(len(db.values)))
-	if err != nil {
+	db.logSize += int64(n)
+	if db.logSize > compactionMinSize && db.logSize > compactionFactor*db.liveSize {
+		return db.compact()
+	}
+	return nil
+}
+
+// compact writes all values into a new journal, the journal is
+// replaced atomically
+func (db *Database) compact() error {
+	if db.journal != nil {
+		db.journal.Close()
+		db.journal = nil
+	}
+	if err := writeJournal(db.path, db.values); err != nil {
 		return err
 	}
+	db.liveSize = db.snapshotSize()
+	db.logSize = db.liveSize
+	return db.openJournal()
+}
+
+func (db *Database) openJournal() (err error) {
+	db.journal, err = os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return
+}
+
+// snapshotSize returns the size of the journal after compaction
+func (db *Database) snapshotSize() int64 {
+	size := int64(uvarintSize(databaseJournalMagic))
 	for key, value := range db.values {
-		db.put(w, uint64(len(key)))
-		r, err := w.Write([]byte(key))
-		if len(key) != r || err != nil {
-			return err
-		}
-		db.put(w, uint64(len(value)))
-		r, err = w.Write(value)
-		if len(value) != r || err != nil {
-			return err
-		}
+		size += recordSize(key, value)
+	}
+	return size
+}
+
+func (db *Database) Close() error {
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	if db.journal == nil {
+		return nil
+	}
+	err := db.journal.Close()
+	db.journal = nil
+	return err
+}
+
+// writeJournal atomically replaces the file at filepath with a
+// journal containing the given values
+func writeJournal(filepath string, values map[string][]byte) error {
+	buf := appendUvarint(nil, databaseJournalMagic)
+	for key, value := range values {
+		buf = append(buf, encodeRecord(recordPut, key, value)...)
+	}
+	f, err := os.OpenFile(filepath+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(buf)
+	if err == nil && n != len(buf) {
+		err = ErrSizeDidNotMatch
+	}
+	if err == nil {
+		err = f.Sync()
 	}
-	err = w.Flush()
 	if err != nil {
+		f.Close()
 		return err
 	}
 	err = f.Close()
@@ -166,21 +319,20 @@ func (db *Database) store() error {
 	}
 	// This renaming makes the operation atomic
 	// the database will be written 100% correct or not at
-	return os.Rename(db.path+".tmp", db.path)
-}
-
-func (db *Database) put(w *bufio.Writer, num uint64) error {
-	size := binary.PutUvarint(db.buffer, num)
-	r, err := w.Write(db.buffer[:size])
+	err = os.Rename(filepath+".tmp", filepath)
 	if err != nil {
 		return err
 	}
-	if size != r {
-		return ErrSizeDidNotMatch
-	}
+	syncDir(path.Dir(filepath))
 	return nil
 }
 
-func (db *Database) Close() error {
-	return nil
+// syncDir makes sure a rename in the directory is persisted
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
@@ -0,0 +1,121 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The default identity uses the unprefixed keys ("private", "fleet", ...) so
+// that databases created by older versions keep working, all other identities
+// store their keys as "identity/<name>/<key>"
+const (
+	DefaultIdentity    = "default"
+	identityPrefix     = "identity/"
+	currentIdentityKey = "identity"
+	identityPrivateKey = "private"
+)
+
+var (
+	identityPattern     = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+	ErrInvalidIdentity  = fmt.Errorf("identity names may only contain letters, digits, '_', '.' and '-'")
+	ErrIdentityNotFound = fmt.Errorf("identity not found")
+	ErrIdentityExists   = fmt.Errorf("identity already exists")
+	ErrDefaultIdentity  = fmt.Errorf("the default identity can't be deleted")
+)
+
+// IdentityKey returns the database key under which the identity stores key
+func IdentityKey(identity string, key string) string {
+	if len(identity) == 0 || identity == DefaultIdentity {
+		return key
+	}
+	return identityPrefix + identity + "/" + key
+}
+
+// identityOfPrivateKey returns the identity that stores its private key under
+// the database key
+func identityOfPrivateKey(key string) (string, bool) {
+	if key == identityPrivateKey {
+		return DefaultIdentity, true
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(key, identityPrefix), "/"+identityPrivateKey)
+	return name, len(name) > 0 && key == IdentityKey(name, identityPrivateKey)
+}
+
+// ValidateIdentity returns an error if name can't be used as identity name
+func ValidateIdentity(name string) error {
+	if !identityPattern.MatchString(name) {
+		return ErrInvalidIdentity
+	}
+	return nil
+}
+
+// Identities returns the names of all identities, the default identity first
+func (db *Database) Identities() []string {
+	names := []string{}
+	for _, key := range db.List() {
+		if name, ok := identityOfPrivateKey(key); ok && name != DefaultIdentity {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultIdentity}, names...)
+}
+
+// HasIdentity returns true when the identity exists
+func (db *Database) HasIdentity(name string) bool {
+	if name == DefaultIdentity {
+		return true
+	}
+	_, err := db.Get(IdentityKey(name, identityPrivateKey))
+	return err == nil
+}
+
+// CurrentIdentity returns the identity selected with UseIdentity
+func (db *Database) CurrentIdentity() string {
+	name, err := db.Get(currentIdentityKey)
+	if err != nil || !db.HasIdentity(string(name)) {
+		return DefaultIdentity
+	}
+	return string(name)
+}
+
+// UseIdentity selects the identity that is used when none is given
+func (db *Database) UseIdentity(name string) error {
+	if !db.HasIdentity(name) {
+		return ErrIdentityNotFound
+	}
+	if name == DefaultIdentity {
+		return db.Del(currentIdentityKey)
+	}
+	return db.Put(currentIdentityKey, []byte(name))
+}
+
+// DeleteIdentity removes all keys of the identity including its private key
+func (db *Database) DeleteIdentity(name string) error {
+	if name == DefaultIdentity {
+		return ErrDefaultIdentity
+	}
+	if !db.HasIdentity(name) {
+		return ErrIdentityNotFound
+	}
+	if db.CurrentIdentity() == name {
+		if err := db.Del(currentIdentityKey); err != nil {
+			return err
+		}
+	}
+	prefix := IdentityKey(name, "")
+	for _, key := range db.List() {
+		if strings.HasPrefix(key, prefix) {
+			if err := db.Del(key); err != nil {
+				return err
+			}
+		}
+	}
+	// Rewrite the journal so that the private key doesn't stay on disk
+	return db.Compact()
+}
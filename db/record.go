@@ -0,0 +1,183 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// A journal record is:
+// op | uvarint(len(key)) | key | (put only: uvarint(len(value)) | value) | crc32c
+// The checksum covers all preceding bytes of the record.
+const (
+	recordPut byte = 1
+	recordDel byte = 2
+
+	checksumSize = 4
+)
+
+var (
+	crcTable            = crc32.MakeTable(crc32.Castagnoli)
+	errShortRecord      = fmt.Errorf("incomplete record")
+	errZeroRecord       = fmt.Errorf("zero filled record")
+	ErrChecksumMismatch = fmt.Errorf("record checksum mismatch")
+	ErrUnknownFormat    = fmt.Errorf("unknown database format")
+)
+
+// CorruptionError is returned when the database contains damaged records
+type CorruptionError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e CorruptionError) Error() string {
+	return fmt.Sprintf("database %s is corrupted at offset %d: %v (try 'diode config -verify')", e.Path, e.Offset, e.Err)
+}
+
+type record struct {
+	op    byte
+	key   string
+	value []byte
+}
+
+// parseRecord decodes the record at the start of data and returns
+// the number of bytes it occupies
+func parseRecord(data []byte) (rec record, n int, err error) {
+	if len(data) == 0 {
+		err = errShortRecord
+		return
+	}
+	rec.op = data[0]
+	if rec.op == 0 {
+		err = errZeroRecord
+		return
+	}
+	if rec.op != recordPut && rec.op != recordDel {
+		err = fmt.Errorf("unknown record type %d", rec.op)
+		return
+	}
+	n = 1
+	var key []byte
+	key, n, err = parseBytes(data, n)
+	if err != nil {
+		return
+	}
+	rec.key = string(key)
+	if rec.op == recordPut {
+		rec.value, n, err = parseBytes(data, n)
+		if err != nil {
+			return
+		}
+	}
+	if len(data) < n+checksumSize {
+		err = errShortRecord
+		return
+	}
+	sum := binary.BigEndian.Uint32(data[n : n+checksumSize])
+	if sum != crc32.Checksum(data[:n], crcTable) {
+		err = ErrChecksumMismatch
+	}
+	n += checksumSize
+	return
+}
+
+func parseBytes(data []byte, offset int) ([]byte, int, error) {
+	size, m := binary.Uvarint(data[offset:])
+	if m == 0 {
+		return nil, offset, errShortRecord
+	}
+	if m < 0 {
+		return nil, offset, fmt.Errorf("invalid length")
+	}
+	offset += m
+	if uint64(len(data)-offset) < size {
+		return nil, offset, errShortRecord
+	}
+	ret := make([]byte, size)
+	copy(ret, data[offset:offset+int(size)])
+	return ret, offset + int(size), nil
+}
+
+// encodeRecord returns the checksummed encoding of the record
+func encodeRecord(op byte, key string, value []byte) []byte {
+	buf := make([]byte, 0, recordSize(key, value))
+	buf = append(buf, op)
+	buf = appendBytes(buf, []byte(key))
+	if op == recordPut {
+		buf = appendBytes(buf, value)
+	}
+	var sum [checksumSize]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf, crcTable))
+	return append(buf, sum[:]...)
+}
+
+func appendBytes(buf []byte, value []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func appendUvarint(buf []byte, num uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(tmp[:], num)
+	return append(buf, tmp[:size]...)
+}
+
+func recordSize(key string, value []byte) int64 {
+	return int64(1 + uvarintSize(uint64(len(key))) + len(key) + uvarintSize(uint64(len(value))) + len(value) + checksumSize)
+}
+
+func uvarintSize(num uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], num)
+}
+
+// parseSnapshot decodes the legacy full snapshot format, on error the values
+// before offset have been decoded
+func parseSnapshot(data []byte) (values map[string][]byte, offset int, err error) {
+	values = make(map[string][]byte)
+	numTuples, n := binary.Uvarint(data)
+	if n <= 0 {
+		return values, 0, errShortRecord
+	}
+	offset = n
+	for i := numTuples; i > 0; i-- {
+		var key, value []byte
+		var next int
+		key, next, err = parseBytes(data, offset)
+		if err != nil {
+			return
+		}
+		value, next, err = parseBytes(data, next)
+		if err != nil {
+			return
+		}
+		values[string(key)] = value
+		offset = next
+	}
+	return
+}
+
+// nextRecord returns the offset of the next intact record
+// after offset or len(data) when there is none
+func nextRecord(data []byte, offset int) int {
+	for offset++; offset < len(data); offset++ {
+		if _, _, err := parseRecord(data[offset:]); err == nil {
+			return offset
+		}
+	}
+	return len(data)
+}
+
+// isZero returns true when all bytes are zero
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package db
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"sort"
+)
+
+// Report describes the state of a database file as found by Verify or Repair
+type Report struct {
+	Path    string
+	Format  string
+	Records int
+	Keys    []string
+	Losses  []Loss
+	// Backup is the copy of the damaged file that was made by Repair
+	Backup string
+	values map[string][]byte
+}
+
+// Loss is a region of the database file that could not be read
+type Loss struct {
+	Offset int64
+	Size   int64
+	// Key is the key of the damaged record, if it could be read
+	Key    string
+	Reason string
+}
+
+// Healthy returns true when no data was lost
+func (r *Report) Healthy() bool {
+	return len(r.Losses) == 0
+}
+
+// LostKey returns true when a damaged record belonged to the given key and
+// no later intact record for that key was found
+func (r *Report) LostKey(key string) bool {
+	if _, ok := r.values[key]; ok {
+		return false
+	}
+	for _, loss := range r.Losses {
+		if loss.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// LostIdentities returns the identities whose private key was in a damaged
+// record and not found in a later intact record
+func (r *Report) LostIdentities() []string {
+	lost := map[string]bool{}
+	for _, loss := range r.Losses {
+		if name, ok := identityOfPrivateKey(loss.Key); ok && r.LostKey(loss.Key) {
+			lost[name] = true
+		}
+	}
+	names := []string{}
+	for name := range lost {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify reads the database file and reports all damaged records
+// without changing the file
+func Verify(filepath string) (*Report, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return salvage(filepath, data), nil
+}
+
+// Repair rewrites the database file with all records that could be recovered,
+// a copy of the damaged file is kept next to it
+func Repair(filepath string) (*Report, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	report := salvage(filepath, data)
+	if !report.Healthy() {
+		report.Backup = filepath + ".corrupt"
+		if err = ioutil.WriteFile(report.Backup, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	if err = writeJournal(filepath, report.values); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// salvage recovers as many values as possible, in the journal format
+// damaged records are skipped by searching for the next intact record
+func salvage(filepath string, data []byte) *Report {
+	report := &Report{
+		Path:   filepath,
+		values: make(map[string][]byte),
+	}
+	defer report.sortKeys()
+	if len(data) == 0 {
+		report.Format = "empty"
+		return report
+	}
+
+	magic, n := binary.Uvarint(data)
+	if n <= 0 {
+		n = 0
+	}
+	switch magic {
+	case databaseJournalMagic:
+		report.Format = "journal"
+		report.scan(data, n)
+	case databaseVersionMagic:
+		report.Format = "snapshot"
+		values, offset, err := parseSnapshot(data[n:])
+		report.values = values
+		report.Records = len(values)
+		if err != nil {
+			report.lose(int64(n+offset), int64(len(data)-n-offset), "", err)
+		}
+	default:
+		report.Format = "unknown"
+		report.scan(data, report.resync(data, 0, "", ErrUnknownFormat))
+	}
+	return report
+}
+
+func (r *Report) scan(data []byte, offset int) {
+	for offset < len(data) {
+		rec, n, err := parseRecord(data[offset:])
+		if err == nil {
+			r.apply(rec)
+			offset += n
+			continue
+		}
+		if isTornTail(data[offset:], err) && nextRecord(data, offset) == len(data) {
+			r.lose(int64(offset), int64(len(data)-offset), rec.key, err)
+			return
+		}
+		offset = r.resync(data, offset, rec.key, err)
+	}
+}
+
+// resync records the damaged record at offset as lost and returns the
+// offset of the next intact record
+func (r *Report) resync(data []byte, offset int, key string, err error) int {
+	next := nextRecord(data, offset)
+	r.lose(int64(offset), int64(next-offset), key, err)
+	return next
+}
+
+func (r *Report) apply(rec record) {
+	r.Records++
+	switch rec.op {
+	case recordPut:
+		r.values[rec.key] = rec.value
+	case recordDel:
+		delete(r.values, rec.key)
+	}
+}
+
+func (r *Report) lose(offset int64, size int64, key string, err error) {
+	r.Losses = append(r.Losses, Loss{
+		Offset: offset,
+		Size:   size,
+		Key:    key,
+		Reason: err.Error(),
+	})
+}
+
+func (r *Report) sortKeys() {
+	r.Keys = make([]string, 0, len(r.values))
+	for key := range r.values {
+		r.Keys = append(r.Keys, key)
+	}
+	sort.Strings(r.Keys)
+}
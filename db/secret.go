@@ -0,0 +1,158 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted values are stored as:
+// secretMagic | log2(N) | r | p | salt | nonce | aes-256-gcm(value)
+// The database key is used as additional data so that encrypted
+// values can't be swapped between keys.
+const (
+	secretScryptLogN = 15
+	secretScryptR    = 8
+	secretScryptP    = 1
+	secretKeyLen     = 32
+	secretSaltLen    = 32
+	secretNonceLen   = 12
+)
+
+var (
+	secretMagic           = []byte("diode:secret:1:")
+	ErrPassphraseRequired = fmt.Errorf("passphrase required to decrypt value")
+	ErrWrongPassphrase    = fmt.Errorf("wrong passphrase or corrupted value")
+	ErrInvalidSecret      = fmt.Errorf("invalid encrypted value")
+)
+
+// IsEncrypted returns true when the value has been encrypted with EncryptValue
+func IsEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, secretMagic)
+}
+
+// EncryptValue encrypts the value with a key derived from passphrase
+func EncryptValue(key string, value []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, secretSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, secretNonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	aead, err := newSecretAEAD(passphrase, salt, secretScryptLogN, secretScryptR, secretScryptP)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, 0, len(secretMagic)+3+secretSaltLen+secretNonceLen+len(value)+aead.Overhead())
+	ret = append(ret, secretMagic...)
+	ret = append(ret, secretScryptLogN, secretScryptR, secretScryptP)
+	ret = append(ret, salt...)
+	ret = append(ret, nonce...)
+	return aead.Seal(ret, nonce, value, []byte(key)), nil
+}
+
+// DecryptValue decrypts a value that was encrypted with EncryptValue
+func DecryptValue(key string, value []byte, passphrase []byte) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, ErrInvalidSecret
+	}
+	value = value[len(secretMagic):]
+	if len(value) < 3+secretSaltLen+secretNonceLen {
+		return nil, ErrInvalidSecret
+	}
+	logN, r, p := value[0], int(value[1]), int(value[2])
+	if logN == 0 || logN > 30 || r == 0 || p == 0 {
+		return nil, ErrInvalidSecret
+	}
+	value = value[3:]
+	salt := value[:secretSaltLen]
+	nonce := value[secretSaltLen : secretSaltLen+secretNonceLen]
+	aead, err := newSecretAEAD(passphrase, salt, logN, r, p)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, value[secretSaltLen+secretNonceLen:], []byte(key))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
+
+func newSecretAEAD(passphrase []byte, salt []byte, logN byte, r int, p int) (cipher.AEAD, error) {
+	derived, err := scrypt.Key(passphrase, salt, 1<<logN, r, p, secretKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetPassphrase sets the passphrase used by GetSecret and PutSecret
+func (db *Database) SetPassphrase(passphrase []byte) {
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	db.passphrase = passphrase
+}
+
+// HasPassphrase returns true when a passphrase has been set
+func (db *Database) HasPassphrase() bool {
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	return len(db.passphrase) > 0
+}
+
+// IsSecretEncrypted returns true when the value stored at key is encrypted
+func (db *Database) IsSecretEncrypted(key string) bool {
+	value, err := db.Get(key)
+	if err != nil {
+		return false
+	}
+	return IsEncrypted(value)
+}
+
+// GetSecret reads data from the file database and decrypts it if needed
+func (db *Database) GetSecret(key string) ([]byte, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	db.rm.Lock()
+	passphrase := db.passphrase
+	db.rm.Unlock()
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	return DecryptValue(key, value, passphrase)
+}
+
+// PutSecret writes data to the file database, the data is encrypted when
+// a passphrase has been set
+func (db *Database) PutSecret(key string, value []byte) error {
+	db.rm.Lock()
+	passphrase := db.passphrase
+	db.rm.Unlock()
+	if len(passphrase) > 0 {
+		var err error
+		value, err = EncryptValue(key, value, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Put(key, value)
+}
@@ -79,11 +79,16 @@ func (ct *DeviceTicket) arrayBlob() []byte {
 
 // Sign ticket with given ecdsa private key
 func (ct *DeviceTicket) Sign(privKey *ecdsa.PrivateKey) error {
+	return ct.SignWith(crypto.NewKeySigner(privKey))
+}
+
+// SignWith signs the ticket with the given signer
+func (ct *DeviceTicket) SignWith(signer crypto.Signer) error {
 	msgHash, err := ct.HashWithoutSig()
 	if err != nil {
 		return err
 	}
-	sig, err := secp256k1.Sign(msgHash, privKey.D.Bytes())
+	sig, err := signer.Sign(msgHash)
 	if err != nil {
 		return err
 	}
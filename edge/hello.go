@@ -0,0 +1,120 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package edge
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/rlp"
+)
+
+// Versions of the edge protocol, relays that predate the capability
+// negotiation only accept the legacy version in the hello
+const (
+	LegacyProtocolVersion uint64 = 1000
+	ProtocolVersion       uint64 = 1001
+)
+
+// Capabilities that are negotiated in the hello, the client only asks for
+// the capabilities it implements and relays ignore the ones they don't know
+const (
+	// CapabilityBatch allows to fetch many block headers with a single
+	// getblockheaders call
+	CapabilityBatch = "batch"
+	// CapabilityBlocks makes the relay announce new blocks with newblock
+	// requests, so the client doesn't have to poll for them
+	CapabilityBlocks = "blocks"
+)
+
+// MaxBatchSize is the most block headers that are requested in one batch
+const MaxBatchSize = 100
+
+// Hello is the result of the hello handshake, a legacy relay answers with
+// the legacy version and no capabilities
+type Hello struct {
+	Version      uint64
+	Capabilities []string
+}
+
+// Has returns true when the capability was negotiated
+func (hello Hello) Has(capability string) bool {
+	for _, c := range hello.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+type helloResponse struct {
+	RequestID uint64
+	Payload   struct {
+		Type   string
+		Result string
+		// Tail is the version followed by the capabilities, it's empty for
+		// legacy relays
+		Tail []rlp.RawValue `rlp:"tail"`
+	}
+}
+
+type blockHeadersResponse struct {
+	RequestID uint64
+	Payload   struct {
+		Type    string
+		Headers []blockHeaderPayload `rlp:"tail"`
+	}
+}
+
+type blockHeaderPayload struct {
+	Items       [8]Item
+	MinerPubkey []byte
+}
+
+func parseHelloResponse(buffer []byte) (interface{}, error) {
+	var response helloResponse
+	decodeStream := rlp.NewStream(bytes.NewReader(buffer), 0)
+	err := decodeStream.Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Payload.Result != "ok" {
+		return nil, fmt.Errorf("hello was not accepted: %s", response.Payload.Result)
+	}
+	hello := Hello{Version: LegacyProtocolVersion}
+	if len(response.Payload.Tail) == 0 {
+		return hello, nil
+	}
+	err = rlp.DecodeBytes(response.Payload.Tail[0], &hello.Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range response.Payload.Tail[1:] {
+		var capability string
+		err = rlp.DecodeBytes(raw, &capability)
+		if err != nil {
+			return nil, err
+		}
+		hello.Capabilities = append(hello.Capabilities, capability)
+	}
+	return hello, nil
+}
+
+func parseBlockHeadersResponse(buffer []byte) (interface{}, error) {
+	var response blockHeadersResponse
+	decodeStream := rlp.NewStream(bytes.NewReader(buffer), 0)
+	err := decodeStream.Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]blockquick.BlockHeader, len(response.Payload.Headers))
+	for i, payload := range response.Payload.Headers {
+		headers[i], err = newBlockHeader(payload.Items, payload.MinerPubkey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
@@ -0,0 +1,66 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package edge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/diodechain/diode_client/rlp"
+)
+
+func TestParseHelloResponse(t *testing.T) {
+	tests := []struct {
+		payload  []interface{}
+		expected Hello
+	}{
+		{
+			payload:  []interface{}{"response", "ok"},
+			expected: Hello{Version: LegacyProtocolVersion},
+		},
+		{
+			payload:  []interface{}{"response", "ok", ProtocolVersion},
+			expected: Hello{Version: ProtocolVersion},
+		},
+		{
+			payload:  []interface{}{"response", "ok", ProtocolVersion, CapabilityBatch, "unknown"},
+			expected: Hello{Version: ProtocolVersion, Capabilities: []string{CapabilityBatch, "unknown"}},
+		},
+	}
+	for _, test := range tests {
+		buffer, err := rlp.EncodeToBytes(generalRequest{RequestID: 1, Payload: test.payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := parseHelloResponse(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello := res.(Hello)
+		if !reflect.DeepEqual(hello, test.expected) {
+			t.Fatalf("expected %+v but got %+v", test.expected, hello)
+		}
+		if hello.Has(CapabilityBatch) != (len(test.expected.Capabilities) > 0) {
+			t.Fatalf("wrong batch capability %+v", hello)
+		}
+	}
+}
+
+func TestParseNewBlockRequest(t *testing.T) {
+	buffer, err := rlp.EncodeToBytes(generalRequest{RequestID: 1 << 48, Payload: []interface{}{"newblock", uint64(1234)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Len: len(buffer), Buffer: buffer}
+	if msg.IsResponse() {
+		t.Fatalf("newblock should be an inbound request")
+	}
+	req, err := msg.ReadAsInboundRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newBlock, ok := req.(NewBlock); !ok || newBlock.Number != 1234 {
+		t.Fatalf("expected block 1234 but got %+v", req)
+	}
+}
@@ -39,3 +39,11 @@ type goodbyeInboundRequest struct {
 		Message string
 	}
 }
+
+type newBlockInboundRequest struct {
+	RequestID uint64
+	Payload   struct {
+		Method string
+		Number uint64
+	}
+}
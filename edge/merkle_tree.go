@@ -29,6 +29,16 @@ type MerkleTreeLeave struct {
 	Value []byte
 }
 
+// MerkleProofNode is a node of a merkle proof, Path are the bits of the
+// position of the node in the tree, a node either hashes the leaves of a
+// bucket or is the hash of a sibling subtree
+type MerkleProofNode struct {
+	Path   string
+	Hash   []byte
+	Modulo uint64
+	Leaves []MerkleTreeLeave
+}
+
 // MerkleTree struct for merkle tree
 type MerkleTree struct {
 	mtp      MerkleTreeParser
@@ -49,6 +59,12 @@ func (mt *MerkleTree) Get(key []byte) ([]byte, error) {
 	return nil, errKeyNotFound
 }
 
+// ProofNodes returns the nodes of the proof from the left to the right
+func (mt *MerkleTree) ProofNodes() (nodes []MerkleProofNode, err error) {
+	err = mt.mtp.walk(mt.RawTree, "", 0, &nodes)
+	return
+}
+
 func (mt *MerkleTree) parse() (rootHash []byte, modulo uint64, leaves []MerkleTreeLeave, err error) {
 	var parsed interface{}
 
@@ -158,6 +174,39 @@ func setBit(n uint64, pos int, bit uint64) uint64 {
 	return n
 }
 
+// walk collects the proof nodes in the same order as rparse
+func (mt MerkleTreeParser) walk(proof interface{}, path string, bits uint64, nodes *[]MerkleProofNode) error {
+	val := reflect.ValueOf(proof)
+	kind := val.Kind()
+	if kind != reflect.Slice && kind != reflect.Array {
+		return errWrongTree
+	}
+	if bytVal, ok := val.Interface().([]byte); ok {
+		*nodes = append(*nodes, MerkleProofNode{Path: path, Hash: bytVal})
+		return nil
+	}
+	if val.Len() == 0 {
+		return errWrongTree
+	}
+	leftRaw := val.Index(0).Interface()
+	if bytVal, ok := leftRaw.([]byte); ok && len(bytVal) < 32 {
+		hash, modulo, leaves, err := mt.parseProof(proof, len(path), bits)
+		if err != nil {
+			return err
+		}
+		*nodes = append(*nodes, MerkleProofNode{Path: path, Hash: hash, Modulo: modulo, Leaves: leaves})
+		return nil
+	}
+	if val.Len() != 2 {
+		return errWrongTree
+	}
+	depth := len(path) + 1
+	if err := mt.walk(leftRaw, path+"0", setBit(bits, depth, 0), nodes); err != nil {
+		return err
+	}
+	return mt.walk(val.Index(1).Interface(), path+"1", setBit(bits, depth, 1), nodes)
+}
+
 // parse recursively
 func (mt MerkleTreeParser) rparse(proof interface{}, depth int, bits uint64) (interface{}, uint64, []MerkleTreeLeave, error) {
 	val := reflect.ValueOf(proof)
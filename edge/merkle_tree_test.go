@@ -62,6 +62,21 @@ func assertSame(t *testing.T, rawTestTree []interface{}, key []uint8, expected [
 		t.Fatalf("Found root hash but modulo is wrong")
 	}
 
+	nodes, err := acvTree.ProofNodes()
+	if err != nil {
+		t.Fatalf("Proof nodes should be parsed %v", err)
+	}
+	leaves := 0
+	for _, node := range nodes {
+		if len(node.Hash) != 32 {
+			t.Fatalf("Proof node %s should have a hash", node.Path)
+		}
+		leaves += len(node.Leaves)
+	}
+	if leaves != len(acvTree.Leaves) {
+		t.Fatalf("Proof nodes should have %d leaves but have %d", len(acvTree.Leaves), leaves)
+	}
+
 	value, err := acvTree.Get(key)
 	if len(expected) == 0 {
 		if err == nil {
@@ -29,6 +29,7 @@ var (
 	portSendPivot     = []byte("portsend")
 	portClosePivot    = []byte("portclose")
 	goodbyePivot      = []byte("goodbye")
+	newBlockPivot     = []byte("newblock")
 	// Maybe remove parse callback and use parse response?
 	blockPivot                 = []byte("getblock")
 	block2Pivot                = []byte("getblock2")
@@ -141,17 +142,22 @@ func parseBlockHeaderResponse(buffer []byte) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newBlockHeader(response.Payload.Items, response.Payload.MinerPubkey)
+}
+
+// newBlockHeader returns the header and checks that the block hash matches
+func newBlockHeader(items [8]Item, minerPubkey []byte) (bh blockquick.BlockHeader, err error) {
 	// get value
-	txHash, _ := findItemInItems(response.Payload.Items, "transaction_hash")
-	stateHash, _ := findItemInItems(response.Payload.Items, "state_hash")
-	blockHash, _ := findItemInItems(response.Payload.Items, "block_hash")
-	prevBlock, _ := findItemInItems(response.Payload.Items, "previous_block")
-	nonce, _ := findItemInItems(response.Payload.Items, "nonce")
-	minerSig, _ := findItemInItems(response.Payload.Items, "miner_signature")
-	timestamp, _ := findItemInItems(response.Payload.Items, "timestamp")
-	number, _ := findItemInItems(response.Payload.Items, "number")
+	txHash, _ := findItemInItems(items, "transaction_hash")
+	stateHash, _ := findItemInItems(items, "state_hash")
+	blockHash, _ := findItemInItems(items, "block_hash")
+	prevBlock, _ := findItemInItems(items, "previous_block")
+	nonce, _ := findItemInItems(items, "nonce")
+	minerSig, _ := findItemInItems(items, "miner_signature")
+	timestamp, _ := findItemInItems(items, "timestamp")
+	number, _ := findItemInItems(items, "number")
 	// also can decompress pubkey and marshal to pubkey bytes
-	dminerPubkey := secp256k1.DecompressPubkeyBytes(response.Payload.MinerPubkey)
+	dminerPubkey := secp256k1.DecompressPubkeyBytes(minerPubkey)
 	header, err := blockquick.NewHeader(
 		txHash.Value,
 		stateHash.Value,
@@ -163,11 +169,11 @@ func parseBlockHeaderResponse(buffer []byte) (interface{}, error) {
 		util.DecodeBytesToUint(nonce.Value),
 	)
 	if err != nil {
-		return nil, err
+		return bh, err
 	}
 	hash := header.Hash()
 	if !bytes.Equal(hash[:], blockHash.Value) {
-		return nil, fmt.Errorf("blockhash != real hash %v %v", blockHash.Value, header)
+		return bh, fmt.Errorf("blockhash != real hash %v %v", blockHash.Value, header)
 	}
 	return header, nil
 }
@@ -546,6 +552,16 @@ func parseInboundGoodbyeRequest(buffer []byte) (interface{}, error) {
 	return goodbye, nil
 }
 
+func parseInboundNewBlockRequest(buffer []byte) (interface{}, error) {
+	var inboundRequest newBlockInboundRequest
+	decodeStream := rlp.NewStream(bytes.NewReader(buffer), 0)
+	err := decodeStream.Decode(&inboundRequest)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlock{Number: inboundRequest.Payload.Number}, nil
+}
+
 func parseInboundRequest(buffer []byte) (req interface{}, err error) {
 	if bytes.Contains(buffer, portOpenPivot) {
 		return parseInboundPortOpenRequest(buffer)
@@ -555,6 +571,8 @@ func parseInboundRequest(buffer []byte) (req interface{}, err error) {
 		return parseInboundPortCloseRequest(buffer)
 	} else if bytes.Contains(buffer, goodbyePivot) {
 		return parseInboundGoodbyeRequest(buffer)
+	} else if bytes.Contains(buffer, newBlockPivot) {
+		return parseInboundNewBlockRequest(buffer)
 	}
 	return
 }
@@ -611,7 +629,7 @@ func NewMessage(writer io.Writer, requestID uint64, method string, args ...inter
 
 	switch method {
 	case "hello":
-		return nil, nil
+		return parseHelloResponse, nil
 	case "portclose":
 		return nil, nil
 	case "getblock":
@@ -620,6 +638,8 @@ func NewMessage(writer io.Writer, requestID uint64, method string, args ...inter
 		return parseBlockPeakResponse, nil
 	case "getblockheader2":
 		return parseBlockHeaderResponse, nil
+	case "getblockheaders":
+		return parseBlockHeadersResponse, nil
 	case "getblockquick2":
 		return parseBlockquickResponse, nil
 	case "getaccount":
@@ -75,8 +75,6 @@ type blockquickResponse struct {
 	}
 }
 
-// type helloResponse struct {}
-
 type ticketThanksResponse struct {
 	RequestID uint64
 	Payload   struct {
@@ -0,0 +1,74 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package edge
+
+import (
+	"testing"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+)
+
+// standInSigner only implements crypto.Signer, it counts the signatures so
+// the tests see that the signing goes through the interface
+type standInSigner struct {
+	signer *crypto.KeySigner
+	signed int
+}
+
+func (s *standInSigner) PublicKey() ([]byte, error) {
+	return s.signer.PublicKey()
+}
+
+func (s *standInSigner) Sign(hash []byte) ([]byte, error) {
+	s.signed++
+	return s.signer.Sign(hash)
+}
+
+func newStandInSigner(t *testing.T) (*standInSigner, util.Address) {
+	key, err := crypto.HexToECDSA("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &standInSigner{signer: crypto.NewKeySigner(key)}, util.PubkeyToAddress(crypto.MarshalPubkey(&key.PublicKey))
+}
+
+func TestSignerTicket(t *testing.T) {
+	signer, address := newStandInSigner(t)
+	ticket := &DeviceTicket{
+		BlockNumber:      1000,
+		BlockHash:        make([]byte, 32),
+		TotalConnections: 1,
+		TotalBytes:       1024,
+		LocalAddr:        []byte{},
+	}
+	if err := ticket.SignWith(signer); err != nil {
+		t.Fatal(err)
+	}
+	if signer.signed != 1 {
+		t.Fatalf("Ticket should be signed once by the signer but was signed %d times", signer.signed)
+	}
+	if !ticket.ValidateDeviceSig(address) {
+		t.Errorf("Ticket signature of the signer is not valid")
+	}
+}
+
+func TestSignerTransaction(t *testing.T) {
+	signer, address := newStandInSigner(t)
+	var to util.Address
+	tx := NewTransaction(1, 10, 21000, to, 1, []byte{}, 41043)
+	if err := tx.SignWith(signer); err != nil {
+		t.Fatal(err)
+	}
+	if signer.signed != 1 {
+		t.Fatalf("Transaction should be signed once by the signer but was signed %d times", signer.signed)
+	}
+	from, err := tx.From()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != address {
+		t.Errorf("Transaction was signed by %s instead of %s", from.HexString(), address.HexString())
+	}
+}
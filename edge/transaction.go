@@ -154,6 +154,11 @@ func (tx *Transaction) HashWithoutSig() ([]byte, error) {
 
 // Sign sign the transaction
 func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey) (err error) {
+	return tx.SignWith(crypto.NewKeySigner(privKey))
+}
+
+// SignWith signs the transaction with the given signer
+func (tx *Transaction) SignWith(signer crypto.Signer) (err error) {
 	var msgHash []byte
 	if tx.chainID > 0 {
 		msgHash, err = tx.HashWithSig()
@@ -163,7 +168,7 @@ func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey) (err error) {
 	if err != nil {
 		return err
 	}
-	sig, err := secp256k1.Sign(msgHash, privKey.D.Bytes())
+	sig, err := signer.Sign(msgHash)
 	if err != nil {
 		return err
 	}
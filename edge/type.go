@@ -61,6 +61,12 @@ type Goodbye struct {
 	Err     error
 }
 
+// NewBlock is the announcement of a new peak by a relay that negotiated
+// CapabilityBlocks
+type NewBlock struct {
+	Number uint64
+}
+
 type ServerObj struct {
 	Host         []byte
 	EdgePort     uint64
@@ -180,6 +186,16 @@ func (ac *Account) StateTree() MerkleTree {
 	return ac.stateTree
 }
 
+// Hash returns the hash of the account values, the state tree stores it as
+// the value of the account address
+func (ac *Account) Hash() ([]byte, error) {
+	balance := ac.Balance
+	if balance == nil {
+		balance = new(big.Int)
+	}
+	return util.RLPHash([]interface{}{uint64(ac.Nonce), balance, ac.StorageRoot, ac.Code})
+}
+
 // AccountRoot returns account root of account value, you can compare with accountroots[mod]
 func (acv *AccountValue) AccountRoot() []byte {
 	return acv.accountTree.RootHash
@@ -8,6 +8,8 @@ import (
 	"math/rand"
 	"sync/atomic"
 	"time"
+
+	"github.com/diodechain/diode_client/config"
 )
 
 // Backoff is a time.Duration counter, starting at Min. After every call to
@@ -33,8 +35,6 @@ func (b *Backoff) Duration() time.Duration {
 	return d
 }
 
-const maxInt64 = float64(math.MaxInt64 - 512)
-
 // ForAttempt returns the duration for a specific attempt. This is useful if
 // you have a large number of independent Backoffs, but don't want use
 // unnecessary memory storing the Backoff parameters per Backoff. The first
@@ -63,13 +63,13 @@ func (b *Backoff) ForAttempt(attempt float64) time.Duration {
 	//calculate this duration
 	minf := float64(min)
 	durf := minf * math.Pow(factor, attempt)
+	//cap before the jitter, so that waits at the cap are spread too
+	if durf > float64(max) {
+		durf = float64(max)
+	}
 	if b.Jitter {
 		durf = rand.Float64()*(durf-minf) + minf
 	}
-	//ensure float64 wont overflow int64
-	if durf > maxInt64 {
-		return max
-	}
 	dur := time.Duration(durf)
 	//keep within bounds
 	if dur < min {
@@ -107,3 +107,15 @@ func (b *Backoff) Copy() *Backoff {
 		Max:    b.Max,
 	}
 }
+
+// NewReconnectBackoff returns the jittered backoff that is used between
+// attempts to reconnect to the network, the jitter keeps devices from
+// reconnecting in lockstep after an outage
+func NewReconnectBackoff(cfg *config.Config) *Backoff {
+	return &Backoff{
+		Min:    cfg.ReconnectMin,
+		Max:    cfg.ReconnectMax,
+		Factor: 2,
+		Jitter: true,
+	}
+}
@@ -0,0 +1,51 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	cfg := &config.Config{
+		ReconnectMin: time.Second,
+		ReconnectMax: 30 * time.Second,
+	}
+	backoff := NewReconnectBackoff(cfg)
+	for i := 0; i < 10; i++ {
+		limit := cfg.ReconnectMin << uint(i)
+		if limit > cfg.ReconnectMax {
+			limit = cfg.ReconnectMax
+		}
+		wait := backoff.Duration()
+		if wait < cfg.ReconnectMin || wait > limit {
+			t.Fatalf("expected attempt %d to wait between %s and %s but got %s", i, cfg.ReconnectMin, limit, wait)
+		}
+	}
+	if backoff.Attempt() != 10 {
+		t.Fatalf("expected 10 attempts but got %v", backoff.Attempt())
+	}
+	backoff.Reset()
+	if wait := backoff.Duration(); wait != cfg.ReconnectMin {
+		t.Fatalf("expected the first wait after reset to be %s but got %s", cfg.ReconnectMin, wait)
+	}
+}
+
+func TestBackoffJitterAtCap(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 30 * time.Second, Factor: 2, Jitter: true}
+	waits := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		wait := b.ForAttempt(20)
+		if wait < b.Min || wait > b.Max {
+			t.Fatalf("expected the wait to be between %s and %s but got %s", b.Min, b.Max, wait)
+		}
+		waits[wait] = true
+	}
+	if len(waits) < 2 {
+		t.Fatalf("expected the waits at the cap to differ but got %v", waits)
+	}
+}
@@ -0,0 +1,111 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/blockquick"
+)
+
+const (
+	// blockPollInterval is the time between the getblockpeak calls to
+	// relays that don't announce new blocks
+	blockPollInterval = 15 * time.Second
+	// blockAnnouncePollInterval is the time between the getblockpeak calls
+	// to relays that announce new blocks, the poll measures the latency and
+	// catches up when an announcement was lost
+	blockAnnouncePollInterval = time.Minute
+	// blockBatchSize is the number of block headers that are downloaded at
+	// once when catching up with the relay
+	blockBatchSize = 100
+	// blockCatchUpSize is the number of block headers that are downloaded in
+	// one round, the next poll or announcement continues from there
+	blockCatchUpSize = 10 * blockBatchSize
+)
+
+// checkBlockPeak returns an error when the chain can't have grown from the
+// last valid block to the peak until now, the chain doesn't produce more
+// than one block per second
+func checkBlockPeak(last blockquick.BlockHeader, peak uint64, now time.Time) error {
+	elapsed := now.Unix() - int64(last.Timestamp())
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if max := last.Number() + uint64(elapsed) + windowSize; peak > max {
+		return fmt.Errorf("block peak %v is impossible, the last valid block %v is %v seconds old", peak, last.Number(), elapsed)
+	}
+	return nil
+}
+
+// addBlocks adds the block headers to the window and returns the headers that
+// were finalized, headers that the miners of the window didn't confirm yet
+// stay pending in the window and are not returned
+func addBlocks(bq *blockquick.Window, bhs []blockquick.BlockHeader) (finalized []blockquick.BlockHeader, err error) {
+	before, _ := bq.Last()
+	for _, bh := range bhs {
+		if err = bq.AddBlock(bh, false); err != nil {
+			err = fmt.Errorf("couldn't add block %v %v: %v", bh.Number(), bh.Hash(), err)
+			break
+		}
+	}
+	after, _ := bq.Last()
+	if after <= before {
+		return
+	}
+	for _, bh := range bq.Headers() {
+		if bh.Number() > before {
+			finalized = append(finalized, bh)
+		}
+	}
+	return
+}
+
+// blockSubscribers delivers the block headers that were validated to the
+// subscribed channels, every block number is delivered once and in order
+type blockSubscribers struct {
+	mx   sync.Mutex
+	subs map[chan<- blockquick.BlockHeader]bool
+	last uint64
+}
+
+func (bs *blockSubscribers) subscribe(ch chan<- blockquick.BlockHeader) {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	if bs.subs == nil {
+		bs.subs = make(map[chan<- blockquick.BlockHeader]bool)
+	}
+	bs.subs[ch] = true
+}
+
+func (bs *blockSubscribers) unsubscribe(ch chan<- blockquick.BlockHeader) {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	delete(bs.subs, ch)
+}
+
+// publish delivers the headers that are newer than the last delivered
+// header, it doesn't block, so a subscriber that doesn't keep up misses the
+// headers that don't fit into its channel. It returns the number of missed
+// headers.
+func (bs *blockSubscribers) publish(bhs []blockquick.BlockHeader) (missed int) {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	for _, bh := range bhs {
+		if bh.Number() <= bs.last {
+			continue
+		}
+		bs.last = bh.Number()
+		for ch := range bs.subs {
+			select {
+			case ch <- bh:
+			default:
+				missed++
+			}
+		}
+	}
+	return
+}
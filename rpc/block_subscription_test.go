@@ -0,0 +1,87 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestBlockSubscribers(t *testing.T) {
+	bhs := testHeaders(t, 5)
+	var bs blockSubscribers
+	fast := make(chan blockquick.BlockHeader, 10)
+	slow := make(chan blockquick.BlockHeader, 1)
+	bs.subscribe(fast)
+	bs.subscribe(slow)
+
+	if missed := bs.publish(bhs[:3]); missed != 2 {
+		t.Fatalf("slow subscriber should miss 2 headers but missed %d", missed)
+	}
+	// headers that were delivered already are skipped
+	if missed := bs.publish(bhs[1:4]); missed != 1 {
+		t.Fatalf("slow subscriber should miss 1 header but missed %d", missed)
+	}
+	bs.unsubscribe(slow)
+	bs.publish(bhs[4:])
+
+	if len(fast) != 5 {
+		t.Fatalf("fast subscriber should get 5 headers but got %d", len(fast))
+	}
+	for i := 1; i <= 5; i++ {
+		if bh := <-fast; bh.Number() != uint64(i) {
+			t.Fatalf("expected block %d but got %d", i, bh.Number())
+		}
+	}
+	if bh := <-slow; bh.Number() != 1 || len(slow) != 0 {
+		t.Fatalf("slow subscriber should only get block 1 but got %d", bh.Number())
+	}
+}
+
+func TestAddBlocks(t *testing.T) {
+	miner := testMiner(t)
+	bhs := mineTestHeaders(t, miner, util.EmptyBytes(32), 1, windowSize)
+	bq, err := blockquick.New(bhs, windowSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := bhs[windowSize-1].Hash()
+
+	// a header of a miner that is not in the window is never confirmed
+	selfSigned := mineTestHeaders(t, testMiner(t), last[:], windowSize+1, 1)
+	finalized, err := addBlocks(bq, selfSigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(finalized) != 0 {
+		t.Fatalf("self signed header should not be finalized but got %d headers", len(finalized))
+	}
+	if lvbn, _ := bq.Last(); lvbn != windowSize {
+		t.Fatalf("last valid block should stay %d but is %d", windowSize, lvbn)
+	}
+
+	finalized, err = addBlocks(bq, mineTestHeaders(t, miner, last[:], windowSize+1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(finalized) != 2 || finalized[0].Number() != windowSize+1 || finalized[1].Number() != windowSize+2 {
+		t.Fatalf("expected blocks %d and %d to be finalized but got %v", windowSize+1, windowSize+2, finalized)
+	}
+}
+
+func TestCheckBlockPeak(t *testing.T) {
+	last := testHeaders(t, 1)[0]
+	now := time.Unix(int64(last.Timestamp())+10, 0)
+	if err := checkBlockPeak(last, last.Number()+10+windowSize, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, peak := range []uint64{last.Number() + 11 + windowSize, 1 << 62} {
+		if err := checkBlockPeak(last, peak, now); err == nil {
+			t.Fatalf("block peak %d should be impossible", peak)
+		}
+	}
+}
@@ -27,26 +27,9 @@ func (client *Client) handleInboundRequest(inboundRequest interface{}) {
 				return
 			}
 			// Checking blocklist and allowlist
-			if len(client.config.Blocklists) > 0 {
-				if client.config.Blocklists[portOpen.DeviceID] {
-					err := fmt.Errorf(
-						"device %x is on the block list",
-						portOpen.DeviceID,
-					)
-					client.ResponsePortOpen(portOpen, err)
-					return
-				}
-			} else {
-				if len(client.config.Allowlists) > 0 {
-					if !client.config.Allowlists[portOpen.DeviceID] {
-						err := fmt.Errorf(
-							"device %x is not in the allow list",
-							portOpen.DeviceID,
-						)
-						client.ResponsePortOpen(portOpen, err)
-						return
-					}
-				}
+			if err := client.config.AccessLists.Check(portOpen.DeviceID); err != nil {
+				client.ResponsePortOpen(portOpen, err)
+				return
 			}
 
 			// find published port
@@ -135,6 +118,8 @@ func (client *Client) handleInboundRequest(inboundRequest interface{}) {
 		//  else {
 		// client.Log().Error("Couldn't find the portclose connected device %x", portClose.Ref)
 		// }
+	} else if newBlock, ok := inboundRequest.(edge.NewBlock); ok {
+		go client.addLatestBlocks(newBlock.Number)
 	} else if goodbye, ok := inboundRequest.(edge.Goodbye); ok {
 		client.Log().Warn("server disconnected, reason: %v", goodbye.Reason)
 		if !client.Closed() {
@@ -162,7 +147,7 @@ func (client *Client) isAllowlisted(port *config.Port, addr Address) bool {
 		}
 
 		for _, fleetAddr := range allowFleets {
-			isAccessWhilisted := client.IsDeviceAllowlisted(fleetAddr, addr)
+			isAccessWhilisted := client.IsDeviceAllowlisted(client.ctx, fleetAddr, addr)
 			if isAccessWhilisted {
 				return true
 			}
@@ -203,7 +188,7 @@ func (client *Client) handleInboundMessage(msg edge.Message) {
 			return
 		}
 		if call.Parse == nil {
-			// no Parse callback for hello and portclose
+			// no Parse callback for portclose
 			return
 		}
 		res, err := call.Parse(msg.Buffer)
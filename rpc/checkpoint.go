@@ -0,0 +1,252 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/util"
+)
+
+// checkpointKey keeps the checkpoint that the trusted block was pinned to
+const checkpointKey = "checkpoint"
+
+// checkpointDomain is the prefix of the signed digest of a checkpoint
+var checkpointDomain = []byte("diode-checkpoint-v1")
+
+var (
+	errCheckpointSigner = fmt.Errorf("a checkpoint file is only trusted with the address that signed it off, -checkpointsigner or -signedby for diode checkpoint import")
+	errCheckpointOlder  = fmt.Errorf("the checkpoint is older than the trusted block")
+)
+
+// Checkpoint is a trusted block that the blockquick validation starts from,
+// with the window of headers that end at the block
+type Checkpoint struct {
+	BlockNumber uint64                   `json:"block_number"`
+	BlockHash   string                   `json:"block_hash"`
+	Headers     []blockquick.BlockHeader `json:"headers,omitempty"`
+	// Signer is the address that signed off the checkpoint
+	Signer    string `json:"signer,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ExportCheckpoint returns the trusted block of the active identity and the
+// cached window of headers, signed with the client key
+func ExportCheckpoint() (*Checkpoint, error) {
+	lvbn, lvbh := restoreLastValid(activeIdentity())
+	cp := &Checkpoint{
+		BlockNumber: lvbn,
+		BlockHash:   util.EncodeToString(lvbh[:]),
+		Headers:     loadWindowCache(activeIdentity(), lvbn, lvbh),
+	}
+	if len(cp.Headers) == 0 || cp.Headers[len(cp.Headers)-1].Number() != lvbn {
+		// the window is fetched from the relays again
+		cp.Headers = nil
+	}
+	if err := cp.Sign(ClientSigner()); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// ParseCheckpoint reads a checkpoint file or a checkpoint that is given as
+// <block_number>:<block_hash>, a checkpoint file is only trusted when it was
+// signed off by signer
+func ParseCheckpoint(value string, signer string) (*Checkpoint, error) {
+	if parts := strings.SplitN(value, ":", 2); len(parts) == 2 && util.IsHex([]byte(parts[1])) {
+		num, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkpoint block number %s", parts[0])
+		}
+		cp := &Checkpoint{BlockNumber: num, BlockHash: parts[1]}
+		if _, err = cp.hash(); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+	return LoadCheckpoint(value, signer)
+}
+
+// LoadCheckpoint reads a checkpoint file, the file is only trusted when it
+// was signed off by the signer address
+func LoadCheckpoint(file string, signer string) (*Checkpoint, error) {
+	if len(signer) == 0 {
+		return nil, errCheckpointSigner
+	}
+	expected, err := util.DecodeAddress(signer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint signer %s: %v", signer, err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %v", file, err)
+	}
+	if err = cp.VerifySigner(expected); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *Checkpoint) hash() (hash crypto.Sha3, err error) {
+	b, err := util.DecodeString(cp.BlockHash)
+	if err != nil || len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid checkpoint block hash %s", cp.BlockHash)
+	}
+	copy(hash[:], b)
+	return
+}
+
+// digest returns the hash that the signer signs off, it covers the block
+// and the hashes of the window, checkpointDomain keeps it apart from other
+// signed digests
+func (cp *Checkpoint) digest() ([]byte, error) {
+	hash, err := cp.hash()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	buf.Write(checkpointDomain)
+	binary.Write(buf, binary.BigEndian, cp.BlockNumber)
+	buf.Write(hash[:])
+	for _, bh := range cp.Headers {
+		h := bh.Hash()
+		buf.Write(h[:])
+	}
+	return crypto.Sha256(buf.Bytes()), nil
+}
+
+// Sign signs off the checkpoint
+func (cp *Checkpoint) Sign(signer crypto.Signer) error {
+	msgHash, err := cp.digest()
+	if err != nil {
+		return err
+	}
+	addr, sig, err := signDigest(signer, msgHash)
+	if err != nil {
+		return err
+	}
+	cp.Signer = addr.HexString()
+	cp.Signature = util.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks that the headers end at the block and are linked, and
+// returns the address that signed off the checkpoint
+func (cp *Checkpoint) Verify() (signer util.Address, err error) {
+	hash, err := cp.hash()
+	if err != nil {
+		return
+	}
+	if n := len(cp.Headers); n > 0 {
+		last := cp.Headers[n-1]
+		if last.Number() != cp.BlockNumber || last.Hash() != hash {
+			return signer, fmt.Errorf("the headers of the checkpoint don't end at block %d", cp.BlockNumber)
+		}
+		for i := 1; i < n; i++ {
+			if cp.Headers[i].Number() != cp.Headers[i-1].Number()+1 || cp.Headers[i].Parent() != cp.Headers[i-1].Hash() {
+				return signer, fmt.Errorf("the headers of the checkpoint are not linked at block %d", cp.Headers[i].Number())
+			}
+		}
+	}
+	msgHash, err := cp.digest()
+	if err != nil {
+		return
+	}
+	sig, err := util.DecodeString(cp.Signature)
+	if err != nil {
+		return signer, fmt.Errorf("the checkpoint is not signed")
+	}
+	signer, err = recoverSigner(msgHash, sig)
+	if err != nil {
+		return signer, fmt.Errorf("invalid checkpoint signature: %v", err)
+	}
+	if len(cp.Signer) > 0 && !strings.EqualFold(cp.Signer, signer.HexString()) {
+		return signer, fmt.Errorf("the checkpoint was signed by %s and not by %s", signer.HexString(), cp.Signer)
+	}
+	return signer, nil
+}
+
+// VerifySigner checks the checkpoint like Verify and that it was signed off
+// by the expected address
+func (cp *Checkpoint) VerifySigner(expected util.Address) error {
+	signer, err := cp.Verify()
+	if err != nil {
+		return err
+	}
+	if signer != expected {
+		return fmt.Errorf("the checkpoint was signed off by %s and not by %s", signer.HexString(), expected.HexString())
+	}
+	return nil
+}
+
+// Import makes the checkpoint the trusted block of the active identity, a
+// checkpoint older than the stored trusted block is only imported with force
+func (cp *Checkpoint) Import(force bool) error {
+	hash, err := cp.hash()
+	if err != nil {
+		return err
+	}
+	if lvbn, err := db.DB.Get(identityKey(lvbnKey)); err == nil && util.DecodeBytesToUint(lvbn) > cp.BlockNumber && !force {
+		return errCheckpointOlder
+	}
+	SetLastValid(cp.BlockNumber, hash)
+	if len(cp.Headers) > 0 {
+		return storeWindowHeaders(cp.Headers)
+	}
+	return nil
+}
+
+// Pin makes the checkpoint the starting point of the blockquick validation,
+// instead of the chain of the relay that is contacted first. The checkpoint
+// is imported unless the trusted block is already newer, and it replaces the
+// default trusted block when a relay doesn't match the trusted block.
+func (cp *Checkpoint) Pin() (pinned bool, err error) {
+	hash, err := cp.hash()
+	if err != nil {
+		return
+	}
+	old, oldErr := db.DB.Get(identityKey(checkpointKey))
+	lvbn, _ := restoreLastValid(activeIdentity())
+	if oldErr == nil && bytes.Equal(old, cp.pinData(hash)) && lvbn >= cp.BlockNumber {
+		return false, nil
+	}
+	if err = cp.Import(false); err == errCheckpointOlder {
+		// the trusted block is newer, the pin is only the fallback
+		err = nil
+	} else if err != nil {
+		return
+	}
+	return true, db.DB.Put(identityKey(checkpointKey), cp.pinData(hash))
+}
+
+func (cp *Checkpoint) pinData(hash crypto.Sha3) []byte {
+	data := make([]byte, 8, 8+len(hash))
+	binary.BigEndian.PutUint64(data, cp.BlockNumber)
+	return append(data, hash[:]...)
+}
+
+// pinnedCheckpoint returns the block of the checkpoint that is pinned for
+// the identity
+func pinnedCheckpoint(identity string) (lvbn uint64, lvbh crypto.Sha3, ok bool) {
+	data, err := db.DB.Get(db.IdentityKey(identity, checkpointKey))
+	if err != nil || len(data) != 8+len(lvbh) {
+		return
+	}
+	lvbn = binary.BigEndian.Uint64(data[:8])
+	copy(lvbh[:], data[8:])
+	return lvbn, lvbh, true
+}
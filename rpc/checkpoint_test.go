@@ -0,0 +1,151 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestCheckpoint(t *testing.T) {
+	key, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bhs := testHeaders(t, 10)
+	last := bhs[len(bhs)-1]
+	hash := last.Hash()
+	cp := &Checkpoint{BlockNumber: last.Number(), BlockHash: util.EncodeToString(hash[:]), Headers: bhs}
+	if err = cp.Sign(crypto.NewKeySigner(key)); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := cp.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != util.PubkeyToAddress(crypto.MarshalPubkey(&key.PublicKey)) {
+		t.Fatalf("wrong signer %s", signer.HexString())
+	}
+
+	// the signature covers the headers
+	tampered := *cp
+	tampered.Headers = bhs[1:]
+	if _, err = tampered.Verify(); err == nil {
+		t.Fatalf("checkpoint with other headers should be rejected")
+	}
+	// the headers have to end at the block
+	tampered = *cp
+	tampered.BlockNumber--
+	if _, err = tampered.Verify(); err == nil {
+		t.Fatalf("checkpoint with headers after the block should be rejected")
+	}
+	// the headers have to be linked
+	tampered = *cp
+	tampered.Headers = append(append(bhs[:0:0], bhs[:4]...), bhs[5:]...)
+	if _, err = tampered.Verify(); err == nil {
+		t.Fatalf("checkpoint with unlinked headers should be rejected")
+	}
+}
+
+func TestPinCheckpoint(t *testing.T) {
+	withTestDB(t)
+
+	bhs := testHeaders(t, 10)
+	hash := bhs[9].Hash()
+	cp, err := ParseCheckpoint(fmt.Sprintf("10:%s", util.EncodeToString(hash[:])), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := cp.Pin()
+	if err != nil || !pinned {
+		t.Fatalf("checkpoint should be pinned %v", err)
+	}
+	if lvbn, lvbh := restoreLastValid(activeIdentity()); lvbn != 10 || lvbh != hash {
+		t.Fatalf("trusted block should be the checkpoint but got %d", lvbn)
+	}
+	if pinned, _ = cp.Pin(); pinned {
+		t.Fatalf("checkpoint shouldn't be pinned twice")
+	}
+
+	// a relay that doesn't match the trusted block resets it to the pin
+	db.DB.Del(identityKey(lvbnKey))
+	if lvbn, lvbh := restoreLastValid(activeIdentity()); lvbn != 10 || lvbh != hash {
+		t.Fatalf("trusted block should fall back to the checkpoint but got %d", lvbn)
+	}
+
+	// an older checkpoint doesn't roll the trusted block back unless forced
+	SetLastValid(10, hash)
+	older := bhs[4].Hash()
+	cp = &Checkpoint{BlockNumber: 5, BlockHash: util.EncodeToString(older[:])}
+	if err = cp.Import(false); err != errCheckpointOlder {
+		t.Fatalf("expected errCheckpointOlder but got %v", err)
+	}
+	if pinned, err = cp.Pin(); err != nil || !pinned {
+		t.Fatalf("older checkpoint should be pinned as the fallback %v", err)
+	}
+	if lvbn, _ := restoreLastValid(activeIdentity()); lvbn != 10 {
+		t.Fatalf("trusted block shouldn't be rolled back to %d", lvbn)
+	}
+	if err = cp.Import(true); err != nil {
+		t.Fatal(err)
+	}
+	if lvbn, lvbh := restoreLastValid(activeIdentity()); lvbn != 5 || lvbh != older {
+		t.Fatalf("forced import should roll back to the checkpoint but got %d", lvbn)
+	}
+
+	if _, err = ParseCheckpoint("10:0x1234", ""); err == nil {
+		t.Fatalf("checkpoint with a short hash should be rejected")
+	}
+}
+
+func TestParseCheckpointFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diode_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bhs := testHeaders(t, 10)
+	hash := bhs[9].Hash()
+	cp := &Checkpoint{BlockNumber: bhs[9].Number(), BlockHash: util.EncodeToString(hash[:]), Headers: bhs}
+	if err = cp.Sign(crypto.NewKeySigner(key)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "anchor.json")
+	if err = ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadCheckpoint(file, ""); err != errCheckpointSigner {
+		t.Fatalf("checkpoint file without an expected signer should be rejected but got %v", err)
+	}
+	other := util.Address{1}
+	if _, err = ParseCheckpoint(file, other.HexString()); err == nil {
+		t.Fatalf("checkpoint file of another signer should be rejected")
+	}
+	parsed, err := ParseCheckpoint(file, cp.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.BlockNumber != cp.BlockNumber || len(parsed.Headers) != len(bhs) {
+		t.Fatalf("wrong checkpoint %d with %d headers", parsed.BlockNumber, len(parsed.Headers))
+	}
+}
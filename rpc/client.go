@@ -10,7 +10,7 @@ package rpc
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -36,6 +36,10 @@ const (
 	packetLimit   = 65000
 	ticketBound   = 4194304
 	callQueueSize = 1024
+	// initialLatency in milliseconds ranks new clients behind measured ones
+	initialLatency = 100_000
+	// helloTimeout is how long to wait for the relay to answer the hello
+	helloTimeout = 10 * time.Second
 )
 
 var (
@@ -44,10 +48,15 @@ var (
 	errSendTransactionFailed        = fmt.Errorf("server returned false")
 	errClientClosed                 = fmt.Errorf("rpc client was closed")
 	errPortOpenTimeout              = fmt.Errorf("portopen timeout")
+	// clientCapabilities are the capabilities that the client asks for in
+	// the hello
+	clientCapabilities = []string{edge.CapabilityBatch, edge.CapabilityBlocks}
 )
 
 // Client struct for rpc client
 type Client struct {
+	// health is updated atomically, the first field is 64-bit aligned
+	health        relayHealth
 	host          string
 	backoff       Backoff
 	s             *SSL
@@ -60,11 +69,22 @@ type Client struct {
 	pool          *DataPool
 	config        *config.Config
 	bq            *blockquick.Window
-	lastTicket    *edge.DeviceTicket
-	latencySum    int64
-	latencyCount  int64
-	serverID      util.Address
-	onConnect     func(util.Address)
+	blocks        blockSubscribers
+	// blocksMx serializes the downloads of new block headers, they are
+	// started by the poll and by the announcements of the relay
+	blocksMx     sync.Mutex
+	lastTicket   *edge.DeviceTicket
+	lastTicketAt time.Time
+	ticketPolicy *TicketPolicy
+	latencySum   int64
+	latencyCount int64
+	serverID     util.Address
+	hello        edge.Hello
+	onConnect    func(util.Address)
+	// ctx is cancelled when the client is closed, it's the context of the
+	// calls that the client makes on its own
+	ctx    context.Context
+	cancel context.CancelFunc
 	// close event
 	OnClose func()
 
@@ -79,7 +99,7 @@ func getRequestID() uint64 {
 // NewClient returns rpc client
 func NewClient(host string, clientMan *ClientManager, cfg *config.Config, pool *DataPool) *Client {
 	client := &Client{
-		latencySum:   100_000,
+		latencySum:   initialLatency,
 		latencyCount: 1,
 		host:         host,
 		srv:          genserver.New("Client"),
@@ -95,8 +115,10 @@ func NewClient(host string, clientMan *ClientManager, cfg *config.Config, pool *
 		},
 		config:        cfg,
 		enableMetrics: cfg.EnableMetrics,
+		ticketPolicy:  NewTicketPolicy(cfg),
 	}
 
+	client.ctx, client.cancel = context.WithCancel(context.Background())
 	if client.enableMetrics {
 		client.metrics = NewMetrics()
 	}
@@ -112,6 +134,15 @@ func (client *Client) averageLatency() int64 {
 	return client.latencySum / client.latencyCount
 }
 
+// measuredLatency returns the average of the measurements without the
+// initial latency that new clients start with
+func (client *Client) measuredLatency() time.Duration {
+	if client.latencyCount <= 1 {
+		return 0
+	}
+	return time.Duration((client.latencySum-initialLatency)/(client.latencyCount-1)) * time.Millisecond
+}
+
 func (client *Client) addLatencyMeasurement(latency time.Duration) {
 	client.latencySum += latency.Milliseconds()
 	client.latencyCount++
@@ -165,24 +196,28 @@ func (client *Client) GetDeviceKey(ref string) string {
 	return fmt.Sprintf("%s:%s", prefix, ref)
 }
 
-func (client *Client) waitResponse(call *Call) (res interface{}, err error) {
+func (client *Client) waitResponse(ctx context.Context, call *Call) (res interface{}, err error) {
 	defer call.Clean(CLOSED)
+	// cancelled calls are removed, so that late responses are dropped
 	defer client.srv.Cast(func() { client.cm.RemoveCallByID(call.id) })
-	resp, ok := <-call.response
-	if !ok {
+	start := time.Now()
+	var resp interface{}
+	select {
+	case resp = <-call.response:
+	case <-call.done:
 		host, _ := client.Host()
-		err = CancelledError{host}
+		err = CancelledError{Host: host}
 		if call.sender != nil {
-			call.sender.sendErr = io.EOF
-			call.sender.Close()
+			call.sender.sendFailed(client, io.EOF)
 		}
 		return
+	case <-ctx.Done():
+		return nil, client.contextError(ctx, start)
 	}
 	if rpcError, ok := resp.(edge.Error); ok {
 		err = RPCError{rpcError}
 		if call.sender != nil {
-			call.sender.sendErr = RPCError{rpcError}
-			call.sender.Close()
+			call.sender.sendFailed(client, RPCError{rpcError})
 		}
 		return
 	}
@@ -190,6 +225,18 @@ func (client *Client) waitResponse(call *Call) (res interface{}, err error) {
 	return res, nil
 }
 
+// contextError returns the typed error for a call that ended with its context
+func (client *Client) contextError(ctx context.Context, start time.Time) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		timeout := time.Since(start)
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = deadline.Sub(start)
+		}
+		return TimeoutError{Timeout: timeout}
+	}
+	return CancelledError{Host: client.host, Err: ctx.Err()}
+}
+
 // RespondContext sends a message (a response) without expecting a response
 func (client *Client) RespondContext(requestID uint64, responseType string, method string, args ...interface{}) (call *Call, err error) {
 	buf := &bytes.Buffer{}
@@ -228,6 +275,7 @@ func (client *Client) CastContext(sender *ConnectedPort, method string, args ...
 		data:     buf,
 		Parse:    parseCallback,
 		response: make(chan interface{}),
+		done:     make(chan struct{}),
 	}
 	err = client.insertCall(call)
 	return
@@ -247,17 +295,26 @@ func (client *Client) insertCall(call *Call) (err error) {
 	return
 }
 
-// CallContext returns the response after calling the rpc
-func (client *Client) CallContext(method string, parse func(buffer []byte) (interface{}, error), args ...interface{}) (res interface{}, err error) {
+// CallContext returns the response after calling the rpc, the call is
+// removed when ctx is done before the response arrives
+func (client *Client) CallContext(ctx context.Context, method string, parse func(buffer []byte) (interface{}, error), args ...interface{}) (res interface{}, err error) {
 	var resCall *Call
 	var ts time.Time
 	var tsDiff time.Duration
+	if _, ok := ctx.Deadline(); !ok && client.config.RemoteRPCTimeout > 0 {
+		// without a deadline a relay that doesn't answer would never time
+		// out and its circuit breaker would never trip
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.config.RemoteRPCTimeout)
+		defer cancel()
+	}
 	resCall, err = client.CastContext(nil, method, args...)
 	if err != nil {
 		return
 	}
 	ts = time.Now()
-	res, err = client.waitResponse(resCall)
+	res, err = client.waitResponse(ctx, resCall)
+	client.recordHealth(err)
 	if err != nil {
 		switch err.(type) {
 		case CancelledError:
@@ -272,30 +329,68 @@ func (client *Client) CallContext(method string, parse func(buffer []byte) (inte
 	return
 }
 
+// recordHealth counts the result of a call and quarantines the relay when
+// the circuit breaker trips
+func (client *Client) recordHealth(err error) {
+	if client.health.record(err) {
+		client.Log().Warn("Relay timed out %d times in a row, quarantine it for %s", healthMaxFailures, healthCoolDown)
+		client.quarantine(quarantineTimeouts)
+	}
+}
+
+// invalidBlocks counts a blockquick validation failure and quarantines the
+// relay, it returns err
+func (client *Client) invalidBlocks(err error) error {
+	if client.health.validationFailed() {
+		client.Log().Warn("Relay sent invalid blocks, quarantine it for %s: %v", healthCoolDown, err)
+		client.quarantine(quarantineInvalidBlocks)
+	}
+	return err
+}
+
+func (client *Client) quarantine(reason string) {
+	if client.clientMan != nil {
+		client.clientMan.quarantine(client, reason)
+	}
+}
+
 // CheckTicket should client send traffic ticket to server
 func (client *Client) CheckTicket() error {
-	needTicket := false
+	reason := ""
 	timeout := client.callTimeout(func() {
 		if client.lastTicket != nil && !client.isRecentTicket(client.lastTicket) {
-			needTicket = true
+			reason = ticketReasonStale
 			return
 		}
-
-		if client.s.TotalBytes() > client.s.Counter()+ticketBound {
-			needTicket = true
-		}
+		reason = client.ticketPolicy.due(client.ticketUsage())
 	})
 
 	if timeout != nil {
 		return timeout
 	}
 
-	if needTicket {
-		return client.SubmitNewTicket()
+	if reason != "" {
+		return client.submitNewTicket(reason)
 	}
 	return nil
 }
 
+// ticketUsage returns the usage since the last signed ticket
+func (client *Client) ticketUsage() (usage ticketUsage) {
+	if total, counter := client.s.TotalBytes(), client.s.Counter(); total > counter {
+		usage.bytes = total - counter
+	}
+	if client.lastTicket != nil {
+		if total := client.s.TotalConnections(); total > client.lastTicket.TotalConnections {
+			usage.connections = total - client.lastTicket.TotalConnections
+		}
+	}
+	if !client.lastTicketAt.IsZero() {
+		usage.elapsed = time.Since(client.lastTicketAt)
+	}
+	return
+}
+
 func (client *Client) isRecentTicket(tck *edge.DeviceTicket) bool {
 	lvbn, _ := client.LastValid()
 
@@ -313,18 +408,18 @@ func (client *Client) isRecentTicket(tck *edge.DeviceTicket) bool {
 // Run blockquick algorithm, more information see: https://eprint.iacr.org/2019/579.pdf
 func (client *Client) validateNetwork() error {
 
-	lvbn, lvbh := restoreLastValid()
-	blockNumMin := lvbn - windowSize + 1
+	ctx := client.ctx
+	lvbn, lvbh := restoreLastValid(client.identity())
 
-	// Fetching at least window size blocks -- this should be cached on disk instead.
-	blockHeaders, err := client.GetBlockHeadersUnsafe(blockNumMin, lvbn)
+	// Fetching the window size blocks that are not cached on disk
+	blockHeaders, err := client.windowHeaders(ctx, lvbn, lvbh)
 	if err != nil {
-		client.Log().Error("Cannot fetch blocks %v-%v error: %v", blockNumMin, lvbn, err)
+		client.Log().Error("%v", err)
 		return err
 	}
 	if len(blockHeaders) != windowSize {
 		client.Log().Error("ValidateNetwork(): len(blockHeaders) != windowSize (%v, %v)", len(blockHeaders), windowSize)
-		return fmt.Errorf("validateNetwork(): len(blockHeaders) != windowSize (%v, %v)", len(blockHeaders), windowSize)
+		return client.invalidBlocks(fmt.Errorf("validateNetwork(): len(blockHeaders) != windowSize (%v, %v)", len(blockHeaders), windowSize))
 	}
 
 	// Checking last valid header
@@ -332,35 +427,35 @@ func (client *Client) validateNetwork() error {
 	if hash != lvbh {
 		// the lvbh was different, remove the lvbn
 		client.Log().Debug("Reference block does not match -- resetting lvbn.")
-		db.DB.Del(lvbnKey)
+		db.DB.Del(db.IdentityKey(client.identity(), lvbnKey))
 		return fmt.Errorf("sent reference block does not match %v: %v != %v", lvbn, lvbh, hash)
 	}
 
 	// Checking chain of previous blocks
 	for i := windowSize - 2; i >= 0; i-- {
 		if blockHeaders[i].Hash() != blockHeaders[i+1].Parent() {
-			return fmt.Errorf("recevied blocks parent is not his parent: %+v %+v", blockHeaders[i+1], blockHeaders[i])
+			return client.invalidBlocks(fmt.Errorf("recevied blocks parent is not his parent: %+v %+v", blockHeaders[i+1], blockHeaders[i]))
 		}
 		if !blockHeaders[i].ValidateSig() {
-			return fmt.Errorf("recevied blocks signature is not valid: %v", blockHeaders[i])
+			return client.invalidBlocks(fmt.Errorf("recevied blocks signature is not valid: %v", blockHeaders[i]))
 		}
 	}
 
 	// Starting to fetch new blocks
-	peak, err := client.GetBlockPeak()
+	peak, err := client.GetBlockPeak(ctx)
 	if err != nil {
 		return err
 	}
 	blockNumMax := peak - confirmationSize + 1
 	// fetch more blocks than windowSize
-	blocks, err := client.GetBlockquick(uint64(lvbn), uint64(windowSize+confirmationSize+1))
+	blocks, err := client.GetBlockquick(ctx, uint64(lvbn), uint64(windowSize+confirmationSize+1))
 	if err != nil {
 		return err
 	}
 
 	win, err := blockquick.New(blockHeaders, windowSize)
 	if err != nil {
-		return err
+		return client.invalidBlocks(err)
 	}
 
 	for _, block := range blocks {
@@ -369,14 +464,14 @@ func (client *Client) validateNetwork() error {
 			break
 		}
 		if err := win.AddBlock(block, true); err != nil {
-			return err
+			return client.invalidBlocks(err)
 		}
 	}
 
 	newlvbn, _ := win.Last()
 	if newlvbn == lvbn {
 		if peak-windowSize > lvbn {
-			return fmt.Errorf("couldn't validate any new blocks %v < %v", lvbn, peak)
+			return client.invalidBlocks(fmt.Errorf("couldn't validate any new blocks %v < %v", lvbn, peak))
 		}
 	}
 
@@ -392,8 +487,8 @@ func (client *Client) validateNetwork() error {
  */
 
 // GetBlockPeak returns block peak
-func (client *Client) GetBlockPeak() (uint64, error) {
-	rawBlockPeak, err := client.CallContext("getblockpeak", nil)
+func (client *Client) GetBlockPeak(ctx context.Context) (uint64, error) {
+	rawBlockPeak, err := client.CallContext(ctx, "getblockpeak", nil)
 	if err != nil {
 		return 0, err
 	}
@@ -404,21 +499,21 @@ func (client *Client) GetBlockPeak() (uint64, error) {
 }
 
 // GetBlockquick returns block headers used for blockquick algorithm
-func (client *Client) GetBlockquick(lastValid uint64, windowSize uint64) ([]blockquick.BlockHeader, error) {
-	rawSequence, err := client.CallContext("getblockquick2", nil, lastValid, windowSize)
+func (client *Client) GetBlockquick(ctx context.Context, lastValid uint64, windowSize uint64) ([]blockquick.BlockHeader, error) {
+	rawSequence, err := client.CallContext(ctx, "getblockquick2", nil, lastValid, windowSize)
 	if err != nil {
 		return nil, err
 	}
 	if sequence, ok := rawSequence.([]uint64); ok {
-		return client.GetBlockHeadersUnsafe2(sequence)
+		return client.GetBlockHeadersUnsafe2(ctx, sequence)
 	}
 	return nil, nil
 }
 
 // GetBlockHeaderUnsafe returns an unchecked block header from the server
-func (client *Client) GetBlockHeaderUnsafe(blockNum uint64) (bh blockquick.BlockHeader, err error) {
+func (client *Client) GetBlockHeaderUnsafe(ctx context.Context, blockNum uint64) (bh blockquick.BlockHeader, err error) {
 	var rawHeader interface{}
-	rawHeader, err = client.CallContext("getblockheader2", nil, blockNum)
+	rawHeader, err = client.CallContext(ctx, "getblockheader2", nil, blockNum)
 	if err != nil {
 		return
 	}
@@ -429,9 +524,13 @@ func (client *Client) GetBlockHeaderUnsafe(blockNum uint64) (bh blockquick.Block
 	return
 }
 
-// GetBlockHeadersUnsafe2 returns a range of block headers
+// GetBlockHeadersUnsafe2 returns a range of block headers, they are fetched
+// in batches when the relay supports it and one by one otherwise
 // TODO: use copy instead reference of BlockHeader
-func (client *Client) GetBlockHeadersUnsafe2(blockNumbers []uint64) ([]blockquick.BlockHeader, error) {
+func (client *Client) GetBlockHeadersUnsafe2(ctx context.Context, blockNumbers []uint64) ([]blockquick.BlockHeader, error) {
+	if client.Supports(edge.CapabilityBatch) {
+		return client.getBlockHeadersBatch(ctx, blockNumbers)
+	}
 	count := len(blockNumbers)
 	headersCount := 0
 	responses := make(map[uint64]blockquick.BlockHeader, count)
@@ -441,7 +540,7 @@ func (client *Client) GetBlockHeadersUnsafe2(blockNumbers []uint64) ([]blockquic
 	for _, i := range blockNumbers {
 		go func(bn uint64) {
 			defer wg.Done()
-			header, err := client.GetBlockHeaderUnsafe(bn)
+			header, err := client.GetBlockHeaderUnsafe(ctx, bn)
 			if err != nil {
 				return
 			}
@@ -467,6 +566,38 @@ func (client *Client) GetBlockHeadersUnsafe2(blockNumbers []uint64) ([]blockquic
 	return headers, nil
 }
 
+// getBlockHeadersBatch fetches the block headers with one getblockheaders
+// call for every edge.MaxBatchSize blocks
+func (client *Client) getBlockHeadersBatch(ctx context.Context, blockNumbers []uint64) ([]blockquick.BlockHeader, error) {
+	headers := make([]blockquick.BlockHeader, 0, len(blockNumbers))
+	for len(blockNumbers) > 0 {
+		count := len(blockNumbers)
+		if count > edge.MaxBatchSize {
+			count = edge.MaxBatchSize
+		}
+		args := make([]interface{}, count)
+		for i, bn := range blockNumbers[:count] {
+			args[i] = bn
+		}
+		rawHeaders, err := client.CallContext(ctx, "getblockheaders", nil, args...)
+		if err != nil {
+			return []blockquick.BlockHeader{}, err
+		}
+		batch, ok := rawHeaders.([]blockquick.BlockHeader)
+		if !ok || len(batch) != count {
+			return []blockquick.BlockHeader{}, fmt.Errorf("failed fetching all blocks")
+		}
+		for i, bh := range batch {
+			if bh.Number() != blockNumbers[i] {
+				return []blockquick.BlockHeader{}, fmt.Errorf("relay sent block %d instead of %d", bh.Number(), blockNumbers[i])
+			}
+		}
+		headers = append(headers, batch...)
+		blockNumbers = blockNumbers[count:]
+	}
+	return headers, nil
+}
+
 // GetBlockHeaderValid returns a validated recent block header
 // (only available for the last windowsSize blocks)
 func (client *Client) GetBlockHeaderValid(blockNum uint64) blockquick.BlockHeader {
@@ -476,7 +607,7 @@ func (client *Client) GetBlockHeaderValid(blockNum uint64) blockquick.BlockHeade
 }
 
 // GetBlockHeadersUnsafe returns a consecutive range of block headers
-func (client *Client) GetBlockHeadersUnsafe(blockNumMin uint64, blockNumMax uint64) ([]blockquick.BlockHeader, error) {
+func (client *Client) GetBlockHeadersUnsafe(ctx context.Context, blockNumMin uint64, blockNumMax uint64) ([]blockquick.BlockHeader, error) {
 	if blockNumMin > blockNumMax {
 		return nil, fmt.Errorf("GetBlockHeadersUnsafe(): blockNumMin needs to be <= max")
 	}
@@ -485,35 +616,35 @@ func (client *Client) GetBlockHeadersUnsafe(blockNumMin uint64, blockNumMax uint
 	for i := blockNumMin; i <= blockNumMax; i++ {
 		blockNumbers = append(blockNumbers, uint64(i))
 	}
-	return client.GetBlockHeadersUnsafe2(blockNumbers)
+	return client.GetBlockHeadersUnsafe2(ctx, blockNumbers)
 }
 
 // GetBlock returns block
 // TODO: make sure this rpc works (disconnect from server)
-func (client *Client) GetBlock(blockNum uint64) (interface{}, error) {
-	return client.CallContext("getblock", nil, blockNum)
+func (client *Client) GetBlock(ctx context.Context, blockNum uint64) (interface{}, error) {
+	return client.CallContext(ctx, "getblock", nil, blockNum)
 }
 
 // GetObject returns network object for device
-func (client *Client) GetObject(deviceID [20]byte) (*edge.DeviceTicket, error) {
+func (client *Client) GetObject(ctx context.Context, deviceID [20]byte) (*edge.DeviceTicket, error) {
 	if len(deviceID) != 20 {
 		return nil, fmt.Errorf("device ID must be 20 bytes")
 	}
 	// encDeviceID := util.EncodeToString(deviceID[:])
-	rawObject, err := client.CallContext("getobject", nil, deviceID[:])
+	rawObject, err := client.CallContext(ctx, "getobject", nil, deviceID[:])
 	if err != nil {
 		return nil, err
 	}
 	if device, ok := rawObject.(*edge.DeviceTicket); ok {
-		device.BlockHash, err = client.ResolveBlockHash(device.BlockNumber)
+		device.BlockHash, err = client.ResolveBlockHash(ctx, device.BlockNumber)
 		return device, err
 	}
 	return nil, nil
 }
 
 // GetNode returns network address for node
-func (client *Client) GetNode(nodeID [20]byte) (*edge.ServerObj, error) {
-	rawNode, err := client.CallContext("getnode", nil, nodeID[:])
+func (client *Client) GetNode(ctx context.Context, nodeID [20]byte) (*edge.ServerObj, error) {
+	rawNode, err := client.CallContext(ctx, "getnode", nil, nodeID[:])
 	if err != nil {
 		return nil, err
 	}
@@ -523,17 +654,57 @@ func (client *Client) GetNode(nodeID [20]byte) (*edge.ServerObj, error) {
 	return nil, fmt.Errorf("GetNode(): parseerror")
 }
 
-// Greet Initiates the connection
-// TODO: test compression flag
+// greet negotiates the protocol version and the capabilities with the
+// relay, relays that reject the version are greeted with the legacy hello
+// and no capabilities
 func (client *Client) greet() error {
-	_, err := client.CastContext(nil, "hello", uint64(1000))
-	if err != nil {
+	args := []interface{}{edge.ProtocolVersion}
+	for _, capability := range clientCapabilities {
+		args = append(args, capability)
+	}
+	ctx, cancel := context.WithTimeout(client.ctx, helloTimeout)
+	defer cancel()
+	res, err := client.CallContext(ctx, "hello", nil, args...)
+	hello, _ := res.(edge.Hello)
+	switch err.(type) {
+	case nil:
+	case RPCError, TimeoutError:
+		client.Log().Debug("Relay didn't accept protocol version %d, falling back to %d: %v", edge.ProtocolVersion, edge.LegacyProtocolVersion, err)
+		_, err = client.CastContext(nil, "hello", edge.LegacyProtocolVersion)
+		if err != nil {
+			return err
+		}
+		hello = edge.Hello{Version: edge.LegacyProtocolVersion}
+	default:
 		return err
 	}
-	return client.SubmitNewTicket()
+	// the relay might answer with capabilities that weren't asked for
+	negotiated := edge.Hello{Version: hello.Version}
+	for _, capability := range clientCapabilities {
+		if hello.Has(capability) {
+			negotiated.Capabilities = append(negotiated.Capabilities, capability)
+		}
+	}
+	client.srv.Call(func() { client.hello = negotiated })
+	client.Log().Debug("Negotiated protocol version %d with capabilities %v", negotiated.Version, negotiated.Capabilities)
+	return nil
+}
+
+// Supports returns true when the capability was negotiated with the relay
+func (client *Client) Supports(capability string) (ok bool) {
+	client.callTimeout(func() { ok = client.hello.Has(capability) })
+	return
 }
 
+// SubmitNewTicket signs a new ticket for the current primary and secondary
+// relay
 func (client *Client) SubmitNewTicket() (err error) {
+	return client.submitNewTicket(ticketReasonPrimary)
+}
+
+// submitNewTicket signs a new ticket and keeps it in the ticket history
+// before it's submitted
+func (client *Client) submitNewTicket(reason string) (err error) {
 	client.srv.Call(func() {
 		if client.bq == nil {
 			return
@@ -544,6 +715,11 @@ func (client *Client) SubmitNewTicket() (err error) {
 		if err != nil {
 			return
 		}
+		client.lastTicketAt = time.Now()
+		client.Log().Debug("Signed ticket for %d bytes and %d connections (%s)", ticket.TotalBytes, ticket.TotalConnections, reason)
+		if herr := recordTicket(client.identity(), client.host, reason, ticket); herr != nil {
+			client.Log().Error("Couldn't save ticket: %v", herr)
+		}
 
 		err = client.submitTicket(ticket)
 		if err == nil {
@@ -555,17 +731,7 @@ func (client *Client) SubmitNewTicket() (err error) {
 
 // SignTransaction return signed transaction
 func (client *Client) SignTransaction(tx *edge.Transaction) (err error) {
-	var privKey *ecdsa.PrivateKey
-	timeout := client.callTimeout(func() {
-		privKey, err = client.s.GetClientPrivateKey()
-	})
-	if err != nil {
-		return err
-	}
-	if timeout != nil {
-		return timeout
-	}
-	return tx.Sign(privKey)
+	return tx.SignWith(signerOf(client.config))
 }
 
 // NewTicket returns ticket
@@ -603,11 +769,7 @@ func (client *Client) newTicket() (*edge.DeviceTicket, error) {
 	if err := ticket.ValidateValues(); err != nil {
 		return nil, err
 	}
-	privKey, err := client.s.GetClientPrivateKey()
-	if err != nil {
-		return nil, err
-	}
-	err = ticket.Sign(privKey)
+	err = ticket.SignWith(signerOf(client.config))
 	if err != nil {
 		return nil, err
 	}
@@ -621,7 +783,7 @@ func (client *Client) newTicket() (*edge.DeviceTicket, error) {
 // SubmitTicket submit ticket to server
 // TODO: resend when got too old error
 func (client *Client) submitTicket(ticket *edge.DeviceTicket) error {
-	resp, err := client.CallContext("ticket", nil, uint64(ticket.BlockNumber), ticket.FleetAddr[:], uint64(ticket.TotalConnections), uint64(ticket.TotalBytes), ticket.LocalAddr, ticket.DeviceSig)
+	resp, err := client.CallContext(client.ctx, "ticket", nil, uint64(ticket.BlockNumber), ticket.FleetAddr[:], uint64(ticket.TotalConnections), uint64(ticket.TotalBytes), ticket.LocalAddr, ticket.DeviceSig)
 	if err != nil {
 		return fmt.Errorf("failed to submit ticket: %v", err)
 	}
@@ -637,7 +799,7 @@ func (client *Client) submitTicket(ticket *edge.DeviceTicket) error {
 			if lastTicket.ValidateDeviceSig(client.config.ClientAddr) {
 				client.s.setTotalBytes(lastTicket.TotalBytes + 1024)
 				client.s.totalConnections = lastTicket.TotalConnections + 1
-				err = client.SubmitNewTicket()
+				err = client.submitNewTicket(ticketReasonResubmit)
 				if err != nil {
 					return fmt.Errorf("failed to re-submit ticket: %v", err)
 				}
@@ -653,8 +815,8 @@ func (client *Client) submitTicket(ticket *edge.DeviceTicket) error {
 }
 
 // PortOpen call portopen RPC
-func (client *Client) PortOpen(deviceID [20]byte, port string, mode string) (*edge.PortOpen, error) {
-	rawPortOpen, err := client.CallContext("portopen", nil, deviceID[:], port, mode)
+func (client *Client) PortOpen(ctx context.Context, deviceID [20]byte, port string, mode string) (*edge.PortOpen, error) {
+	rawPortOpen, err := client.CallContext(ctx, "portopen", nil, deviceID[:], port, mode)
 	if err != nil {
 		// if error string is 4 bytes string, it's the timeout error from server
 		if len(err.Error()) == 4 {
@@ -688,17 +850,17 @@ func (client *Client) CastPortClose(ref string) (err error) {
 }
 
 // PortClose portclose RPC
-func (client *Client) PortClose(ref string) (interface{}, error) {
-	return client.CallContext("portclose", nil, ref)
+func (client *Client) PortClose(ctx context.Context, ref string) (interface{}, error) {
+	return client.CallContext(ctx, "portclose", nil, ref)
 }
 
 // Ping call ping RPC
-func (client *Client) Ping() (interface{}, error) {
-	return client.CallContext("ping", nil)
+func (client *Client) Ping(ctx context.Context) (interface{}, error) {
+	return client.CallContext(ctx, "ping", nil)
 }
 
 // SendTransaction send signed transaction to server
-func (client *Client) SendTransaction(tx *edge.Transaction) (result bool, err error) {
+func (client *Client) SendTransaction(ctx context.Context, tx *edge.Transaction) (result bool, err error) {
 	var encodedRLPTx []byte
 	var res interface{}
 	var ok bool
@@ -710,7 +872,7 @@ func (client *Client) SendTransaction(tx *edge.Transaction) (result bool, err er
 	if err != nil {
 		return
 	}
-	res, err = client.CallContext("sendtransaction", nil, encodedRLPTx)
+	res, err = client.CallContext(ctx, "sendtransaction", nil, encodedRLPTx)
 	if res, ok = res.(string); ok {
 		result = res == "ok"
 		if !result {
@@ -722,8 +884,8 @@ func (client *Client) SendTransaction(tx *edge.Transaction) (result bool, err er
 }
 
 // GetAccount returns account information: nonce, balance, storage root, code
-func (client *Client) GetAccount(blockNumber uint64, account [20]byte) (*edge.Account, error) {
-	rawAccount, err := client.CallContext("getaccount", nil, blockNumber, account[:])
+func (client *Client) GetAccount(ctx context.Context, blockNumber uint64, account [20]byte) (*edge.Account, error) {
+	rawAccount, err := client.CallContext(ctx, "getaccount", nil, blockNumber, account[:])
 	if err != nil {
 		return nil, err
 	}
@@ -734,8 +896,8 @@ func (client *Client) GetAccount(blockNumber uint64, account [20]byte) (*edge.Ac
 }
 
 // GetStateRoots returns state roots
-func (client *Client) GetStateRoots(blockNumber uint64) (*edge.StateRoots, error) {
-	rawStateRoots, err := client.CallContext("getstateroots", nil, blockNumber)
+func (client *Client) GetStateRoots(ctx context.Context, blockNumber uint64) (*edge.StateRoots, error) {
+	rawStateRoots, err := client.CallContext(ctx, "getstateroots", nil, blockNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -746,16 +908,16 @@ func (client *Client) GetStateRoots(blockNumber uint64) (*edge.StateRoots, error
 }
 
 // GetValidAccount returns valid account information: nonce, balance, storage root, code
-func (client *Client) GetValidAccount(blockNumber uint64, account [20]byte) (*edge.Account, error) {
+func (client *Client) GetValidAccount(ctx context.Context, blockNumber uint64, account [20]byte) (*edge.Account, error) {
 	if blockNumber <= 0 {
 		bn, _ := client.LastValid()
 		blockNumber = uint64(bn)
 	}
-	act, err := client.GetAccount(blockNumber, account)
+	act, err := client.GetAccount(ctx, blockNumber, account)
 	if err != nil {
 		return nil, err
 	}
-	sts, err := client.GetStateRoots(blockNumber)
+	sts, err := client.GetStateRoots(ctx, blockNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -766,8 +928,8 @@ func (client *Client) GetValidAccount(blockNumber uint64, account [20]byte) (*ed
 }
 
 // GetAccountNonce returns the nonce of the given account, or 0
-func (client *Client) GetAccountNonce(blockNumber uint64, account [20]byte) uint64 {
-	act, _ := client.GetValidAccount(blockNumber, account)
+func (client *Client) GetAccountNonce(ctx context.Context, blockNumber uint64, account [20]byte) uint64 {
+	act, _ := client.GetValidAccount(ctx, blockNumber, account)
 	if act == nil {
 		return 0
 	}
@@ -775,14 +937,14 @@ func (client *Client) GetAccountNonce(blockNumber uint64, account [20]byte) uint
 }
 
 // GetAccountValue returns account storage value
-func (client *Client) GetAccountValue(blockNumber uint64, account [20]byte, rawKey []byte) (*edge.AccountValue, error) {
+func (client *Client) GetAccountValue(ctx context.Context, blockNumber uint64, account [20]byte, rawKey []byte) (*edge.AccountValue, error) {
 	if blockNumber <= 0 {
 		bn, _ := client.LastValid()
 		blockNumber = uint64(bn)
 	}
 	// pad key to 32 bytes
 	key := util.PaddingBytesPrefix(rawKey, 0, 32)
-	rawAccountValue, err := client.CallContext("getaccountvalue", nil, blockNumber, account[:], key)
+	rawAccountValue, err := client.CallContext(ctx, "getaccountvalue", nil, blockNumber, account[:], key)
 	if err != nil {
 		return nil, err
 	}
@@ -793,8 +955,8 @@ func (client *Client) GetAccountValue(blockNumber uint64, account [20]byte, rawK
 }
 
 // GetAccountValueInt returns account value as Integer
-func (client *Client) GetAccountValueInt(blockNumber uint64, addr [20]byte, key []byte) big.Int {
-	raw, err := client.GetAccountValueRaw(blockNumber, addr, key)
+func (client *Client) GetAccountValueInt(ctx context.Context, blockNumber uint64, addr [20]byte, key []byte) big.Int {
+	raw, err := client.GetAccountValueRaw(ctx, blockNumber, addr, key)
 	var ret big.Int
 	if err != nil {
 		return ret
@@ -804,17 +966,17 @@ func (client *Client) GetAccountValueInt(blockNumber uint64, addr [20]byte, key
 }
 
 // GetAccountValueRaw returns account value
-func (client *Client) GetAccountValueRaw(blockNumber uint64, addr [20]byte, key []byte) ([]byte, error) {
+func (client *Client) GetAccountValueRaw(ctx context.Context, blockNumber uint64, addr [20]byte, key []byte) ([]byte, error) {
 	if blockNumber <= 0 {
 		bn, _ := client.LastValid()
 		blockNumber = uint64(bn)
 	}
-	acv, err := client.GetAccountValue(blockNumber, addr, key)
+	acv, err := client.GetAccountValue(ctx, blockNumber, addr, key)
 	if err != nil {
 		return NullData, err
 	}
 	// get account roots
-	acr, err := client.GetAccountRoots(blockNumber, addr)
+	acr, err := client.GetAccountRoots(ctx, blockNumber, addr)
 	if err != nil {
 		return NullData, err
 	}
@@ -835,12 +997,12 @@ func (client *Client) GetAccountValueRaw(blockNumber uint64, addr [20]byte, key
 }
 
 // GetAccountRoots returns account state roots
-func (client *Client) GetAccountRoots(blockNumber uint64, account [20]byte) (*edge.AccountRoots, error) {
+func (client *Client) GetAccountRoots(ctx context.Context, blockNumber uint64, account [20]byte) (*edge.AccountRoots, error) {
 	if blockNumber <= 0 {
 		bn, _ := client.LastValid()
 		blockNumber = uint64(bn)
 	}
-	rawAccountRoots, err := client.CallContext("getaccountroots", nil, blockNumber, account[:])
+	rawAccountRoots, err := client.CallContext(ctx, "getaccountroots", nil, blockNumber, account[:])
 	if err != nil {
 		return nil, err
 	}
@@ -851,9 +1013,9 @@ func (client *Client) GetAccountRoots(blockNumber uint64, account [20]byte) (*ed
 }
 
 // ResolveReverseBNS resolves the (primary) destination of the BNS entry
-func (client *Client) ResolveReverseBNS(addr Address) (name string, err error) {
+func (client *Client) ResolveReverseBNS(ctx context.Context, addr Address) (name string, err error) {
 	key := contract.BNSReverseEntryLocation(addr)
-	raw, err := client.GetAccountValueRaw(0, contract.BNSAddr, key)
+	raw, err := client.GetAccountValueRaw(ctx, 0, contract.BNSAddr, key)
 	if err != nil {
 		return name, err
 	}
@@ -867,15 +1029,15 @@ func (client *Client) ResolveReverseBNS(addr Address) (name string, err error) {
 	return string(raw[:30]), nil
 }
 
-func (client *Client) GetCacheOrResolveBNS(deviceName string) ([]Address, error) {
-	return client.pool.GetCacheOrResolveBNS(deviceName, client)
+func (client *Client) GetCacheOrResolveBNS(ctx context.Context, deviceName string) ([]Address, error) {
+	return client.pool.GetCacheOrResolveBNS(ctx, deviceName, client)
 }
 
 // ResolveBNS resolves the (primary) destination of the BNS entry
-func (client *Client) ResolveBNS(name string) (addr []Address, err error) {
+func (client *Client) ResolveBNS(ctx context.Context, name string) (addr []Address, err error) {
 	client.Log().Info("Resolving BNS: %s", name)
 	arrayKey := contract.BNSDestinationArrayLocation(name)
-	size := client.GetAccountValueInt(0, contract.BNSAddr, arrayKey)
+	size := client.GetAccountValueInt(ctx, 0, contract.BNSAddr, arrayKey)
 
 	// Fallback for old style DNS entries
 	intSize := size.Int64()
@@ -888,7 +1050,7 @@ func (client *Client) ResolveBNS(name string) (addr []Address, err error) {
 
 	if intSize == 0 {
 		key := contract.BNSEntryLocation(name)
-		raw, err := client.GetAccountValueRaw(0, contract.BNSAddr, key)
+		raw, err := client.GetAccountValueRaw(ctx, 0, contract.BNSAddr, key)
 		if err != nil {
 			return addr, err
 		}
@@ -903,7 +1065,7 @@ func (client *Client) ResolveBNS(name string) (addr []Address, err error) {
 
 	for i := int64(0); i < intSize; i++ {
 		key := contract.BNSDestinationArrayElementLocation(name, int(i))
-		raw, err := client.GetAccountValueRaw(0, contract.BNSAddr, key)
+		raw, err := client.GetAccountValueRaw(ctx, 0, contract.BNSAddr, key)
 		if err != nil {
 			client.Log().Error("Read invalid BNS record offset: %d %v (%v)", i, err, string(raw))
 			continue
@@ -920,9 +1082,9 @@ func (client *Client) ResolveBNS(name string) (addr []Address, err error) {
 }
 
 // ResolveBNSOwner resolves the owner of the BNS entry
-func (client *Client) ResolveBNSOwner(name string) (addr Address, err error) {
+func (client *Client) ResolveBNSOwner(ctx context.Context, name string) (addr Address, err error) {
 	key := contract.BNSOwnerLocation(name)
-	raw, err := client.GetAccountValueRaw(0, contract.BNSAddr, key)
+	raw, err := client.GetAccountValueRaw(ctx, 0, contract.BNSAddr, key)
 	if err != nil {
 		return [20]byte{}, err
 	}
@@ -935,7 +1097,7 @@ func (client *Client) ResolveBNSOwner(name string) (addr Address, err error) {
 }
 
 // ResolveBlockHash resolves a missing blockhash by blocknumber
-func (client *Client) ResolveBlockHash(blockNumber uint64) (blockHash []byte, err error) {
+func (client *Client) ResolveBlockHash(ctx context.Context, blockNumber uint64) (blockHash []byte, err error) {
 	if blockNumber == 0 {
 		return
 	}
@@ -943,7 +1105,7 @@ func (client *Client) ResolveBlockHash(blockNumber uint64) (blockHash []byte, er
 	if blockHeader.Number() == 0 {
 		lvbn, _ := client.bq.Last()
 		client.Log().Debug("Validating ticket based on unchecked block %v %v", blockNumber, lvbn)
-		blockHeader, err = client.GetBlockHeaderUnsafe(blockNumber)
+		blockHeader, err = client.GetBlockHeaderUnsafe(ctx, blockNumber)
 		if err != nil {
 			return
 		}
@@ -954,12 +1116,12 @@ func (client *Client) ResolveBlockHash(blockNumber uint64) (blockHash []byte, er
 }
 
 // IsDeviceAllowlisted returns is given address allowlisted
-func (client *Client) IsDeviceAllowlisted(fleetAddr Address, clientAddr Address) bool {
+func (client *Client) IsDeviceAllowlisted(ctx context.Context, fleetAddr Address, clientAddr Address) bool {
 	if fleetAddr == config.DefaultFleetAddr {
 		return true
 	}
 	key := contract.DeviceAllowlistKey(clientAddr)
-	num := client.GetAccountValueInt(0, fleetAddr, key)
+	num := client.GetAccountValueInt(ctx, 0, fleetAddr, key)
 
 	return num.Int64() == 1
 }
@@ -971,6 +1133,9 @@ func (client *Client) Closed() bool {
 
 // Close rpc client
 func (client *Client) Close() {
+	if client.cancel != nil {
+		client.cancel()
+	}
 	doCleanup := true
 	timeout := client.callTimeout(func() {
 		if client.isClosed {
@@ -1024,25 +1189,38 @@ func (client *Client) doStart() (err error) {
 	return
 }
 
+// SubscribeBlocks delivers every new block header that the client validated
+// to ch, the channel should be buffered because headers are dropped when it's
+// full
+func (client *Client) SubscribeBlocks(ch chan<- blockquick.BlockHeader) {
+	client.blocks.subscribe(ch)
+}
+
+// UnsubscribeBlocks stops delivering block headers to ch
+func (client *Client) UnsubscribeBlocks(ch chan<- blockquick.BlockHeader) {
+	client.blocks.unsubscribe(ch)
+}
+
 // watchLatestBlock keep downloading the latest blockheaders and
 // make sure the network is safe
 func (client *Client) watchLatestBlock() {
 	client.doWatchLatestBlock()
+	interval := blockPollInterval
+	if client.Supports(edge.CapabilityBlocks) {
+		interval = blockAnnouncePollInterval
+	}
 	client.srv.Cast(func() {
-		time.AfterFunc(15*time.Second, func() { client.watchLatestBlock() })
+		time.AfterFunc(interval, func() { client.watchLatestBlock() })
 	})
 }
 
 func (client *Client) doWatchLatestBlock() {
-	var bq *blockquick.Window
-	client.callTimeout(func() { bq = client.bq })
-	if bq == nil {
+	if client.validWindow() == nil {
 		return
 	}
-	lastblock, _ := bq.Last()
 
 	start := time.Now()
-	blockPeak, err := client.GetBlockPeak()
+	blockPeak, err := client.GetBlockPeak(client.ctx)
 	elapsed := time.Since(start)
 	client.srv.Cast(func() { client.addLatencyMeasurement(elapsed) })
 
@@ -1050,31 +1228,80 @@ func (client *Client) doWatchLatestBlock() {
 		client.Log().Error("Couldn't getblockpeak: %v", err)
 		return
 	}
+	client.addLatestBlocks(blockPeak)
+}
+
+// addLatestBlocks downloads the block headers up to the peak of the relay
+// and publishes the headers that the window finalized
+func (client *Client) addLatestBlocks(blockPeak uint64) {
+	client.blocksMx.Lock()
+	defer client.blocksMx.Unlock()
+	bq := client.validWindow()
+	if bq == nil || blockPeak <= confirmationSize {
+		return
+	}
+	lastblock, _ := bq.Last()
+	if err := checkBlockPeak(bq.GetBlockHeader(lastblock), blockPeak, time.Now()); err != nil {
+		client.Log().Error("%v", err)
+		client.invalidBlocks(err)
+		return
+	}
 
 	blockPeak -= confirmationSize
 	if lastblock >= blockPeak {
 		// Nothing to do
 		return
 	}
+	if blockPeak-lastblock > blockCatchUpSize {
+		blockPeak = lastblock + blockCatchUpSize
+	}
 
-	for num := lastblock + 1; num <= blockPeak; num++ {
-		blockHeader, err := client.GetBlockHeaderUnsafe(uint64(num))
+	var added []blockquick.BlockHeader
+	defer func() {
+		if len(added) > 0 {
+			client.storeLastValid()
+			client.publishBlocks(added)
+		}
+	}()
+	for num := lastblock + 1; num <= blockPeak; num += blockBatchSize {
+		blockNumbers := make([]uint64, 0, blockBatchSize)
+		for i := num; i <= blockPeak && i < num+blockBatchSize; i++ {
+			blockNumbers = append(blockNumbers, i)
+		}
+		blockHeaders, err := client.GetBlockHeadersUnsafe2(client.ctx, blockNumbers)
 		if err != nil {
-			client.Log().Error("Couldn't download block header %v", err)
+			client.Log().Error("Couldn't download block headers %v-%v: %v", blockNumbers[0], blockNumbers[len(blockNumbers)-1], err)
 			return
 		}
-		err = bq.AddBlock(blockHeader, false)
+		finalized, err := addBlocks(bq, blockHeaders)
+		added = append(added, finalized...)
 		if err != nil {
-			client.Log().Error("Couldn't add block %v %v: %v", num, blockHeader.Hash(), err)
+			client.Log().Error("%v", err)
+			client.invalidBlocks(err)
 			return
 		}
 	}
+}
 
-	client.storeLastValid()
-
+// publishBlocks delivers new validated block headers to the subscribers of
+// the client and of the client manager
+func (client *Client) publishBlocks(bhs []blockquick.BlockHeader) {
+	if missed := client.blocks.publish(bhs); missed > 0 {
+		client.Log().Debug("Block subscribers missed %d block headers", missed)
+	}
+	if client.clientMan != nil {
+		client.clientMan.publishBlocks(bhs)
+	}
+	// tickets that got too old are signed again without waiting for traffic
+	go client.CheckTicket()
 }
 
 func (client *Client) initialize() (err error) {
+	// the capabilities are known before the window is downloaded
+	err = client.greet()
+	if err != nil {
+		return fmt.Errorf("failed to greet server: %v", err)
+	}
 	err = client.validateNetwork()
 	if err != nil && strings.Contains(err.Error(), "sent reference block does not match") {
 		// the lvbn was removed, we can validate network again
@@ -1089,7 +1316,7 @@ func (client *Client) initialize() (err error) {
 		err = fmt.Errorf("failed to get server id: %v", err)
 		return
 	}
-	err = client.greet()
+	err = client.submitNewTicket(ticketReasonConnect)
 	if err != nil {
 		return fmt.Errorf("failed to submitTicket to server: %v", err)
 	}
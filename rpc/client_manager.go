@@ -5,12 +5,14 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
-	"sort"
+	"sync/atomic"
 	"time"
 
+	"github.com/diodechain/diode_client/blockquick"
 	"github.com/diodechain/diode_client/config"
 	"github.com/diodechain/diode_client/util"
 	"github.com/dominicletz/genserver"
@@ -27,6 +29,18 @@ type ClientManager struct {
 
 	waitingAny  []*genserver.Reply
 	waitingNode map[util.Address]*nodeRequest
+	relays      *relayCache
+	policy      *relayPolicy
+
+	consensus      *consensusChecker
+	consensusAlert *ConsensusAlert
+	consensusTimer *time.Timer
+	// blocks is used by all clients, so it has its own lock
+	blocks blockSubscribers
+
+	// backoff delays adding relays while none of them can be reached
+	backoff        *Backoff
+	reconnectTimer *time.Timer
 
 	pool   *DataPool
 	Config *config.Config
@@ -41,13 +55,18 @@ type nodeRequest struct {
 // NewClientManager returns a new manager rpc client
 func NewClientManager(cfg *config.Config) *ClientManager {
 	rand.Seed(time.Now().Unix())
+	policy := newRelayPolicy(cfg)
 	cm := &ClientManager{
 		srv:           genserver.New("ClientManager"),
 		clientMap:     make(map[util.Address]*Client),
 		waitingNode:   make(map[util.Address]*nodeRequest),
 		pool:          NewPool(),
+		relays:        &relayCache{relays: make(map[string]*Relay)},
+		policy:        policy,
+		backoff:       NewReconnectBackoff(cfg),
+		consensus:     newConsensusChecker(),
 		Config:        cfg,
-		targetClients: 5,
+		targetClients: policy.poolSize,
 	}
 	if !config.AppConfig.LogDateTime {
 		cm.srv.DeadlockCallback = nil
@@ -57,9 +76,12 @@ func NewClientManager(cfg *config.Config) *ClientManager {
 
 func (cm *ClientManager) Start() {
 	cm.srv.Call(func() {
+		// the database is opened after the client manager was created
+		cm.relays = loadRelayCache()
 		for x := 0; x < cm.targetClients; x++ {
 			cm.doAddClient()
 		}
+		cm.consensusTimer = time.AfterFunc(consensusInterval, cm.checkConsensus)
 	})
 	go cm.sortTopClients()
 }
@@ -84,6 +106,17 @@ func (cm *ClientManager) Stop() {
 			}
 		}
 
+		if err := cm.relays.save(); err != nil {
+			cm.Config.Logger.Error("Couldn't save relays: %v", err)
+		}
+
+		if cm.reconnectTimer != nil {
+			cm.reconnectTimer.Stop()
+		}
+		if cm.consensusTimer != nil {
+			cm.consensusTimer.Stop()
+			cm.consensusTimer = nil
+		}
 		// When the last client is closed this will return
 		cm.targetClients = 0
 		if len(cm.clients) == 0 {
@@ -101,6 +134,25 @@ func (cm *ClientManager) doAddClient() {
 	cm.startClient(host)
 }
 
+// doScheduleAddClient refills the pool after the backoff, so relays that
+// can't be reached aren't dialed in a tight loop
+func (cm *ClientManager) doScheduleAddClient() {
+	if cm.reconnectTimer != nil {
+		return
+	}
+	wait := cm.backoff.Duration()
+	CountReconnect()
+	cm.Config.Logger.Info("Could not connect to relay trying again in %s (attempt %d)", wait, int(cm.backoff.Attempt()))
+	cm.reconnectTimer = time.AfterFunc(wait, func() {
+		cm.srv.Cast(func() {
+			cm.reconnectTimer = nil
+			for x := len(cm.clients); x < cm.targetClients; x++ {
+				cm.doAddClient()
+			}
+		})
+	})
+}
+
 func (cm *ClientManager) startClient(host string) *Client {
 	if host == "" {
 		return nil
@@ -109,9 +161,13 @@ func (cm *ClientManager) startClient(host string) *Client {
 	n := len(cm.clientMap)
 	cm.Config.Logger.Debug("Adding relay#%d [] @ %s", n, host)
 	client := NewClient(host, cm, cm.Config, cm.pool)
+	connected := false
 	client.onConnect = func(nodeID util.Address) {
 		cm.Config.Logger.Debug("Added relay#%d [%s] @ %s", n, nodeID.HexString(), host)
 		cm.srv.Cast(func() {
+			connected = true
+			cm.backoff.Reset()
+			cm.relays.seen(host, nodeID, client.measuredLatency())
 			cm.clientMap[nodeID] = client
 			for _, c := range cm.waitingAny {
 				c.ReRun()
@@ -127,6 +183,9 @@ func (cm *ClientManager) startClient(host string) *Client {
 	}
 	client.srv.Terminate = func() {
 		cm.srv.Cast(func() {
+			if !connected {
+				cm.relays.failed(host)
+			}
 			for key, c := range cm.clientMap {
 				if c == client {
 					delete(cm.clientMap, key)
@@ -146,8 +205,12 @@ func (cm *ClientManager) startClient(host string) *Client {
 				}
 			}
 
-			for x := len(cm.clients); x < cm.targetClients; x++ {
-				cm.doAddClient()
+			if connected {
+				for x := len(cm.clients); x < cm.targetClients; x++ {
+					cm.doAddClient()
+				}
+			} else if len(cm.clients) < cm.targetClients {
+				cm.doScheduleAddClient()
 			}
 
 			if cm.targetClients == 0 {
@@ -171,7 +234,9 @@ func (cm *ClientManager) GetClient(nodeID util.Address) (client *Client) {
 	return client
 }
 
-func (cm *ClientManager) GetClientorConnect(nodeID util.Address) (client *Client, err error) {
+// GetClientorConnect returns the client of the node, the address of the node
+// is looked up with ctx when it's not connected yet
+func (cm *ClientManager) GetClientorConnect(ctx context.Context, nodeID util.Address) (client *Client, err error) {
 	if client = cm.GetClient(nodeID); client != nil {
 		return
 	}
@@ -181,7 +246,7 @@ func (cm *ClientManager) GetClientorConnect(nodeID util.Address) (client *Client
 	if fclient == nil {
 		return nil, fmt.Errorf("couldn't found nearest server in pool %s", nodeID.HexString())
 	}
-	serverObj, err := fclient.GetNode(nodeID)
+	serverObj, err := fclient.GetNode(ctx, nodeID)
 	if err != nil {
 		fclient.Log().Error("GetServer(): failed to getnode %v", err)
 		return
@@ -197,6 +262,7 @@ func (cm *ClientManager) GetClientorConnect(nodeID util.Address) (client *Client
 	}
 
 	host := net.JoinHostPort(string(serverObj.Host), fmt.Sprintf("%d", serverObj.EdgePort))
+	cm.srv.Cast(func() { cm.relays.learned(host, nodeID) })
 	client, err = cm.connect(nodeID, host)
 	if err != nil {
 		err = fmt.Errorf("couldn't connect to server: '%s' with error '%v'", host, err)
@@ -273,51 +339,240 @@ func (cm *ClientManager) topClient(n int) *Client {
 func (cm *ClientManager) sortTopClients() {
 	cm.srv.Cast(func() {
 		cm.doSortTopClients()
+		cm.doUpdateRelays()
 		time.AfterFunc(time.Minute, func() { cm.sortTopClients() })
 	})
 }
 
 func (cm *ClientManager) doSortTopClients() {
-	onlineClients := make(ByLatency, 0, len(cm.clientMap))
+	now := time.Now()
+	onlineClients := make([]*Client, 0, len(cm.clientMap))
+	quarantined := make([]*Client, 0)
 	for _, client := range cm.clientMap {
-		onlineClients = append(onlineClients, client)
+		if client.health.quarantined(now) {
+			quarantined = append(quarantined, client)
+		} else {
+			onlineClients = append(onlineClients, client)
+		}
+	}
+	// quarantined relays are only used when there is no other relay
+	if len(onlineClients) == 0 {
+		onlineClients = quarantined
 	}
 
-	var before [2]*Client
-	copy(before[:], cm.topClients[:])
+	before := cm.topClients
+	cm.topClients = cm.policy.rankTopClients(onlineClients, before)
+	if cm.topClients != before && cm.topClients[0] != nil && cm.topClients[0].ticketPolicy.signOnPrimaryChange() {
+		go cm.topClients[0].SubmitNewTicket()
+	}
+}
 
-	sort.Sort(onlineClients)
-	if len(onlineClients) > 0 {
-		cm.topClients[0] = onlineClients[0]
-		if len(onlineClients) > 1 {
-			cm.topClients[1] = onlineClients[1]
-		} else {
-			cm.topClients[1] = nil
+// quarantine steers the primary and secondary relay away from a relay that
+// tripped its circuit breaker, the relay isn't dialed again during the
+// cool-down
+func (cm *ClientManager) quarantine(client *Client, reason string) {
+	cm.srv.Cast(func() {
+		until := time.Unix(0, atomic.LoadInt64(&client.health.quarantinedUntil))
+		cm.relays.quarantine(client.host, until, reason)
+		cm.doSortTopClients()
+	})
+}
+
+// checkConsensus compares the validated chains of the connected relays, the
+// relays that disagree with the majority are closed and quarantined
+func (cm *ClientManager) checkConsensus() {
+	cm.srv.Cast(func() {
+		// the client manager was stopped
+		if cm.consensusTimer == nil {
+			return
+		}
+		clients := make([]*Client, 0, len(cm.clientMap))
+		for _, client := range cm.clientMap {
+			clients = append(clients, client)
 		}
-	} else {
-		cm.topClients[0] = nil
+		// the windows are read outside of the genserver, clients call the
+		// client manager while they hold their own genserver
+		go func() {
+			number, views := chainViews(clients)
+			if len(views) == 0 {
+				return
+			}
+			cm.srv.Cast(func() { cm.doApplyConsensus(number, views) })
+		}()
+		cm.consensusTimer = time.AfterFunc(consensusInterval, cm.checkConsensus)
+	})
+}
+
+func (cm *ClientManager) doApplyConsensus(number uint64, views []chainView) {
+	alert, untrusted := cm.consensus.check(number, views)
+	if alert == nil {
+		return
+	}
+	cm.consensusAlert = alert
+	forkCounter.Inc(1)
+	cm.Config.Logger.Error("Relays disagree on block %d: %v", number, alert.Hashes)
+	for _, view := range untrusted {
+		client := view.client
+		client.health.validationFailed()
+		until := time.Unix(0, atomic.LoadInt64(&client.health.quarantinedUntil))
+		cm.relays.quarantine(client.host, until, view.reason)
+		cm.Config.Logger.Warn("Relay %s @ %s is untrusted (%s), quarantine it for %s", view.nodeID.HexString(), client.host, view.reason, healthCoolDown)
+		go client.Close()
 	}
+	cm.doSortTopClients()
+}
 
-	if cm.topClients != before && cm.topClients[0] != nil {
-		go cm.topClients[0].SubmitNewTicket()
+// chainViews returns the hashes of the latest block that all relays
+// validated, relays that validated more than a window ahead are skipped
+func chainViews(clients []*Client) (number uint64, views []chainView) {
+	windows := make(map[*Client]*blockquick.Window, len(clients))
+	for _, client := range clients {
+		bq := client.validWindow()
+		if bq == nil {
+			continue
+		}
+		last, _ := bq.Last()
+		if len(windows) == 0 || last < number {
+			number = last
+		}
+		windows[client] = bq
+	}
+	for client, bq := range windows {
+		bh := bq.GetBlockHeader(number)
+		if bh.Number() != number {
+			continue
+		}
+		views = append(views, chainView{client: client, nodeID: client.serverID, hash: bh.Hash()})
+	}
+	return
+}
+
+// SubscribeBlocks delivers every new block header that any of the relays
+// validated to ch, each block number is delivered once, from the relay that
+// validated it first. The channel should be buffered because headers are
+// dropped when it's full.
+func (cm *ClientManager) SubscribeBlocks(ch chan<- blockquick.BlockHeader) {
+	cm.blocks.subscribe(ch)
+}
+
+// UnsubscribeBlocks stops delivering block headers to ch
+func (cm *ClientManager) UnsubscribeBlocks(ch chan<- blockquick.BlockHeader) {
+	cm.blocks.unsubscribe(ch)
+}
+
+func (cm *ClientManager) publishBlocks(bhs []blockquick.BlockHeader) {
+	if missed := cm.blocks.publish(bhs); missed > 0 {
+		cm.Config.Logger.Debug("Block subscribers missed %d block headers", missed)
+	}
+}
+
+// ConsensusAlert returns the latest disagreement of the relays, or nil when
+// the relays always agreed
+func (cm *ClientManager) ConsensusAlert() (alert *ConsensusAlert) {
+	cm.srv.Call(func() { alert = cm.consensusAlert })
+	return
+}
+
+// RelayScores returns the health of the connected relays and of the relays
+// that are quarantined
+func (cm *ClientManager) RelayScores() (scores []RelayScore) {
+	cm.srv.Call(func() {
+		now := time.Now()
+		hosts := make(map[string]bool, len(cm.clients))
+		for _, client := range cm.clients {
+			if cm.clientMap[client.serverID] != client {
+				continue
+			}
+			score := scoreOf(client)
+			if r := cm.relays.relays[client.host]; r != nil && score.QuarantinedUntil.After(now) {
+				score.QuarantineReason = r.QuarantineReason
+			}
+			score.Primary = client == cm.topClients[0]
+			score.Secondary = client == cm.topClients[1]
+			hosts[client.host] = true
+			scores = append(scores, score)
+		}
+		for _, r := range cm.relays.list() {
+			if hosts[r.Host] || !r.quarantined(now) {
+				continue
+			}
+			scores = append(scores, RelayScore{
+				Host:             r.Host,
+				NodeID:           r.NodeID,
+				LatencyMS:        r.Latency.Milliseconds(),
+				QuarantinedUntil: r.QuarantinedUntil,
+				QuarantineReason: r.QuarantineReason,
+			})
+		}
+	})
+	sortScores(scores)
+	return
+}
+
+// doUpdateRelays stores the latency of the connected relays
+func (cm *ClientManager) doUpdateRelays() {
+	for nodeID, client := range cm.clientMap {
+		cm.relays.seen(client.host, nodeID, client.measuredLatency())
+	}
+	if err := cm.relays.update(time.Now()); err != nil {
+		cm.Config.Logger.Error("Couldn't save relays: %v", err)
 	}
 }
 
+// doSelectNextHost prefers the pinned relays, then the known-good relays with
+// the lowest latency, then the bootstrap nodes and then the other relays
+// learned from getnode, excluded relays are skipped
 func (cm *ClientManager) doSelectNextHost() string {
 	hosts := make(map[string]bool, len(cm.clients))
 	for _, c := range cm.clients {
 		hosts[c.host] = true
 	}
+	nodes := make(map[string]bool, len(cm.clientMap))
+	for nodeID := range cm.clientMap {
+		nodes[nodeID.HexString()] = true
+	}
+	now := time.Now()
+	unused := func(r *Relay) bool {
+		return !hosts[r.Host] && !nodes[r.NodeID]
+	}
+
+	for _, host := range cm.policy.pinned {
+		if !hosts[host] {
+			return host
+		}
+	}
+
+	good, other := cm.relays.candidates(now)
+	for _, r := range cm.policy.orderRelays(good) {
+		if unused(r) {
+			return r.Host
+		}
+	}
 
 	var candidates []string
+	var failing []string
 	for _, c := range cm.Config.RemoteRPCAddrs {
-		if _, ok := hosts[c]; !ok {
+		var nodeID string
+		if r := cm.relays.relays[c]; r != nil {
+			nodeID = r.NodeID
+		}
+		if hosts[c] || cm.policy.excludes(c, nodeID) {
+			continue
+		}
+		if cm.relays.isFailing(c, now) {
+			failing = append(failing, c)
+		} else {
 			candidates = append(candidates, c)
 		}
 	}
 
 	if len(candidates) > 0 {
-		return candidates[rand.Intn(len(candidates))]
+		return cm.policy.pickHost(candidates)
+	}
+	for _, r := range cm.policy.orderRelays(other) {
+		if unused(r) {
+			return r.Host
+		}
 	}
-	return ""
+	return cm.policy.pickHost(failing)
 }
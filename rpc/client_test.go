@@ -0,0 +1,63 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/dominicletz/genserver"
+)
+
+func TestCallContext(t *testing.T) {
+	config.AppConfig = testConfig()
+	calls := make(chan *Call, 2)
+	client := &Client{
+		host:   "silent:41046",
+		srv:    genserver.New("Client"),
+		cm:     NewCallManager(callQueueSize),
+		config: config.AppConfig,
+	}
+	// the relay never answers
+	client.cm.SendCallPtr = func(call *Call) error {
+		calls <- call
+		return nil
+	}
+	waitRemoved := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for client.cm.TotalCallLength() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the call to be removed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.CallContext(ctx, "ping", nil)
+	if _, ok := err.(TimeoutError); !ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a TimeoutError but got %v", err)
+	}
+	waitRemoved()
+	// late responses are dropped
+	if err := (<-calls).enqueueResponse("pong"); err == nil {
+		t.Fatalf("expected the late response to be dropped")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		<-calls
+		cancel()
+	}()
+	_, err = client.CallContext(ctx, "ping", nil)
+	if _, ok := err.(CancelledError); !ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a CancelledError but got %v", err)
+	}
+	waitRemoved()
+}
@@ -31,6 +31,11 @@ type ConnectedPort struct {
 	client        *Client
 	sendErr       error
 	host          string
+	// reopen opens the port through another relay when the relay of the
+	// port went away, it's only set for ports that can continue after that
+	reopen func(failed *Client) (*Client, string, error)
+	// moving is closed when the port was moved to another relay
+	moving chan struct{}
 }
 
 // New returns a new connected port
@@ -67,23 +72,93 @@ func (port *ConnectedPort) SendRemote(data []byte) (err error) {
 		return
 	}
 
+	var moving chan struct{}
 	port.srv.Call(func() {
 		if port.sendErr != nil {
 			err = port.sendErr
 			return
 		}
+		if port.moving != nil {
+			moving = port.moving
+			return
+		}
 
 		var call *Call
-		call, err = port.client.CastContext(port, "portsend", port.Ref, data)
+		client := port.client
+		call, err = client.CastContext(port, "portsend", port.Ref, data)
 		if err == nil {
 			go func() {
-				port.client.waitResponse(call)
+				client.waitResponse(client.ctx, call)
 			}()
+		} else if err == errClientClosed && port.reopen != nil {
+			// the data is dropped, the port is moved to another relay
+			err = nil
 		}
 	})
+	if moving != nil {
+		<-moving
+		return port.SendRemote(data)
+	}
 	return
 }
 
+// sendFailed closes the port after sending through client failed, ports that
+// can be reopened are kept when the relay went away
+func (port *ConnectedPort) sendFailed(client *Client, err error) {
+	port.srv.Cast(func() {
+		if port.client != client {
+			return
+		}
+		if _, ok := err.(RPCError); !ok && port.reopen != nil {
+			return
+		}
+		if port.sendErr == nil {
+			port.sendErr = err
+		}
+		port.close()
+	})
+}
+
+// failover moves the port to another relay after its relay went away, the
+// port is closed when no other relay can reach the device
+func (port *ConnectedPort) failover(failed *Client) {
+	var reopen func(*Client) (*Client, string, error)
+	moving := make(chan struct{})
+	port.srv.Call(func() {
+		if port.closed() || port.client != failed || port.moving != nil {
+			return
+		}
+		reopen = port.reopen
+		port.moving = moving
+	})
+	if reopen == nil {
+		return
+	}
+
+	port.Log().Info("Relay went away, reopening port %s", port.Ref)
+	client, ref, err := reopen(failed)
+	port.srv.Call(func() {
+		port.moving = nil
+		close(moving)
+		if port.closed() {
+			if err == nil {
+				client.CastPortClose(ref)
+			}
+			return
+		}
+		if err != nil {
+			port.Log().Warn("Couldn't reopen port %s: %v", port.Ref, err)
+			port.close()
+			return
+		}
+		port.client = client
+		port.Ref = ref
+		port.host = client.host
+		client.pool.SetPort(client.GetDeviceKey(ref), port)
+		port.Log().Info("Reopened port %s", ref)
+	})
+}
+
 // Shutdown the connection of port
 func (port *ConnectedPort) Shutdown() {
 	if port == nil {
@@ -0,0 +1,137 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+)
+
+const (
+	// consensusInterval is the time between the comparisons of the chains
+	// that the connected relays validated
+	consensusInterval = time.Minute
+	// the hashes that relays reported are kept for consensusHistory blocks
+	// to detect relays that change their chain
+	consensusHistory = 1000
+)
+
+// Reasons to distrust a relay
+const (
+	quarantineMinorityFork = "minority fork"
+	quarantineEquivocation = "equivocation"
+)
+
+// chainView is the hash of a block in the validated chain of a relay
+type chainView struct {
+	client *Client
+	nodeID util.Address
+	hash   crypto.Sha3
+}
+
+// ConsensusAlert describes relays that disagree on the validated chain
+type ConsensusAlert struct {
+	Time        time.Time `json:"time"`
+	BlockNumber uint64    `json:"block_number"`
+	// Hashes are the node ids of the relays by the block hash they validated
+	Hashes map[string][]string `json:"hashes,omitempty"`
+	// Equivocating relays changed the hash of a block they reported before
+	Equivocating []string `json:"equivocating,omitempty"`
+	// Untrusted relays are in the minority or equivocating
+	Untrusted []string `json:"untrusted,omitempty"`
+}
+
+// untrustedView is a relay that is not trusted anymore
+type untrustedView struct {
+	chainView
+	reason string
+}
+
+// consensusChecker compares the chains of the relays, it's only used within
+// the ClientManager genserver
+type consensusChecker struct {
+	reported map[util.Address]map[uint64]crypto.Sha3
+}
+
+func newConsensusChecker() *consensusChecker {
+	return &consensusChecker{reported: make(map[util.Address]map[uint64]crypto.Sha3)}
+}
+
+// check compares the hashes of the block number that the relays validated,
+// the relays that disagree with the majority and the relays that reported
+// another hash before are untrusted, there is no majority when the largest
+// group of relays is not more than half of them
+func (cc *consensusChecker) check(number uint64, views []chainView) (alert *ConsensusAlert, untrusted []untrustedView) {
+	newAlert := func() *ConsensusAlert {
+		if alert == nil {
+			alert = &ConsensusAlert{Time: time.Now(), BlockNumber: number}
+		}
+		return alert
+	}
+
+	honest := make([]chainView, 0, len(views))
+	for _, view := range views {
+		reported := cc.reported[view.nodeID]
+		if reported == nil {
+			reported = make(map[uint64]crypto.Sha3)
+			cc.reported[view.nodeID] = reported
+		}
+		if hash, ok := reported[number]; ok && hash != view.hash {
+			a := newAlert()
+			a.Equivocating = append(a.Equivocating, view.nodeID.HexString())
+			untrusted = append(untrusted, untrustedView{view, quarantineEquivocation})
+			continue
+		}
+		reported[number] = view.hash
+		for num := range reported {
+			if num+consensusHistory < number {
+				delete(reported, num)
+			}
+		}
+		honest = append(honest, view)
+	}
+
+	groups := make(map[crypto.Sha3][]chainView)
+	for _, view := range honest {
+		groups[view.hash] = append(groups[view.hash], view)
+	}
+	if len(groups) > 1 {
+		a := newAlert()
+		a.Hashes = make(map[string][]string, len(groups))
+		var majority crypto.Sha3
+		size := 0
+		for hash, group := range groups {
+			ids := make([]string, 0, len(group))
+			for _, view := range group {
+				ids = append(ids, view.nodeID.HexString())
+			}
+			sort.Strings(ids)
+			a.Hashes[util.EncodeToString(hash[:])] = ids
+			if len(group) > size {
+				majority, size = hash, len(group)
+			}
+		}
+		if size*2 > len(honest) {
+			for hash, group := range groups {
+				if hash == majority {
+					continue
+				}
+				for _, view := range group {
+					untrusted = append(untrusted, untrustedView{view, quarantineMinorityFork})
+				}
+			}
+		}
+	}
+
+	if alert != nil {
+		for _, view := range untrusted {
+			alert.Untrusted = append(alert.Untrusted, view.nodeID.HexString())
+		}
+		sort.Strings(alert.Untrusted)
+	}
+	return
+}
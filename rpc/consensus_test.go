@@ -0,0 +1,49 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"testing"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestConsensusChecker(t *testing.T) {
+	cc := newConsensusChecker()
+	honest, fake := crypto.Sha3{1}, crypto.Sha3{2}
+	a, b, c, evil := util.Address{1}, util.Address{2}, util.Address{3}, util.Address{4}
+	view := func(nodeID util.Address, hash crypto.Sha3) chainView {
+		return chainView{nodeID: nodeID, hash: hash}
+	}
+
+	alert, untrusted := cc.check(100, []chainView{view(a, honest), view(b, honest), view(c, honest)})
+	if alert != nil || len(untrusted) > 0 {
+		t.Fatalf("expected the relays to agree but got %+v", alert)
+	}
+
+	// a single relay feeding another chain is in the minority
+	alert, untrusted = cc.check(101, []chainView{view(a, honest), view(b, honest), view(evil, fake)})
+	if alert == nil || len(alert.Hashes) != 2 {
+		t.Fatalf("expected an alert for two chains but got %+v", alert)
+	}
+	if len(untrusted) != 1 || untrusted[0].nodeID != evil || untrusted[0].reason != quarantineMinorityFork {
+		t.Fatalf("expected the minority relay to be untrusted but got %+v", untrusted)
+	}
+
+	// without a majority no relay can be blamed
+	alert, untrusted = cc.check(102, []chainView{view(a, honest), view(evil, fake)})
+	if alert == nil || len(untrusted) > 0 {
+		t.Fatalf("expected an alert without untrusted relays but got %+v %+v", alert, untrusted)
+	}
+
+	// a relay that changes the hash of a block it reported before
+	alert, untrusted = cc.check(100, []chainView{view(a, honest), view(c, fake)})
+	if alert == nil || len(alert.Equivocating) != 1 || alert.Equivocating[0] != c.HexString() {
+		t.Fatalf("expected an equivocating relay but got %+v", alert)
+	}
+	if len(untrusted) != 1 || untrusted[0].nodeID != c || untrusted[0].reason != quarantineEquivocation {
+		t.Fatalf("expected the equivocating relay to be untrusted but got %+v", untrusted)
+	}
+}
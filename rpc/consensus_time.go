@@ -0,0 +1,142 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+)
+
+// averageBlockTime is the expected time between two blocks
+const averageBlockTime = 15 * time.Second
+
+// timeDomain is the prefix of the signed digest of the consensus time
+var timeDomain = []byte("diode-time-v1")
+
+// ConsensusTime is the current time as bounded by the latest validated
+// block, the times are unix seconds
+type ConsensusTime struct {
+	BlockNumber uint64 `json:"block_number"`
+	BlockHash   string `json:"block_hash"`
+	Min         int64  `json:"min"`
+	Max         int64  `json:"max"`
+	Midpoint    int64  `json:"midpoint"`
+	Radius      int64  `json:"radius"`
+	SystemTime  int64  `json:"system_time"`
+	// Skew is the distance of the system time to the range of the
+	// consensus time, it's negative when the system clock is behind
+	Skew int64 `json:"skew"`
+	// Nonce, Signer and Signature are only set for signed responses
+	Nonce     string `json:"nonce,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ConsensusClock keeps the timestamp of the latest validated block, the
+// time since the block was received is measured with the monotonic clock,
+// so it doesn't depend on the system clock
+type ConsensusClock struct {
+	mx        sync.Mutex
+	number    uint64
+	hash      crypto.Sha3
+	timestamp uint64
+	received  time.Time
+}
+
+// Update moves the clock to a newer block
+func (cc *ConsensusClock) Update(bh blockquick.BlockHeader) {
+	cc.update(bh.Number(), bh.Hash(), bh.Timestamp(), time.Now())
+}
+
+func (cc *ConsensusClock) update(number uint64, hash crypto.Sha3, timestamp uint64, received time.Time) {
+	cc.mx.Lock()
+	defer cc.mx.Unlock()
+	if number <= cc.number {
+		return
+	}
+	cc.number = number
+	cc.hash = hash
+	cc.timestamp = timestamp
+	cc.received = received
+}
+
+// Now returns the consensus time, ok is false before the first block
+func (cc *ConsensusClock) Now() (ct ConsensusTime, ok bool) {
+	return cc.at(time.Now())
+}
+
+func (cc *ConsensusClock) at(now time.Time) (ct ConsensusTime, ok bool) {
+	cc.mx.Lock()
+	defer cc.mx.Unlock()
+	if cc.number == 0 {
+		return
+	}
+	// the block was mined before it was received, it's at most a window
+	// of blocks old
+	min := int64(cc.timestamp) + int64(now.Sub(cc.received)/time.Second)
+	max := min + int64(windowSize*averageBlockTime/time.Second)
+	ct = ConsensusTime{
+		BlockNumber: cc.number,
+		BlockHash:   util.EncodeToString(cc.hash[:]),
+		Min:         min,
+		Max:         max,
+		Midpoint:    (min + max) / 2,
+		Radius:      (max - min) / 2,
+		SystemTime:  now.Unix(),
+	}
+	if ct.SystemTime < min {
+		ct.Skew = ct.SystemTime - min
+	} else if ct.SystemTime > max {
+		ct.Skew = ct.SystemTime - max
+	}
+	return ct, true
+}
+
+// digest returns the hash that is signed, like in roughtime it covers the
+// nonce of the request, timeDomain keeps it apart from other signed digests
+func (ct *ConsensusTime) digest(nonce []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(timeDomain)
+	binary.Write(buf, binary.BigEndian, ct.BlockNumber)
+	binary.Write(buf, binary.BigEndian, ct.Midpoint)
+	binary.Write(buf, binary.BigEndian, ct.Radius)
+	buf.Write(nonce)
+	return crypto.Sha256(buf.Bytes())
+}
+
+// Sign signs the midpoint and radius together with the nonce of the request
+func (ct *ConsensusTime) Sign(signer crypto.Signer, nonce []byte) error {
+	addr, sig, err := signDigest(signer, ct.digest(nonce))
+	if err != nil {
+		return err
+	}
+	ct.Nonce = util.EncodeToString(nonce)
+	ct.Signer = addr.HexString()
+	ct.Signature = util.EncodeToString(sig)
+	return nil
+}
+
+// Verify returns the address that signed the time for the nonce
+func (ct *ConsensusTime) Verify(nonce []byte) (signer util.Address, err error) {
+	sig, err := util.DecodeString(ct.Signature)
+	if err != nil {
+		return signer, fmt.Errorf("the time is not signed")
+	}
+	signer, err = recoverSigner(ct.digest(nonce), sig)
+	if err != nil {
+		return signer, fmt.Errorf("invalid time signature: %v", err)
+	}
+	if len(ct.Signer) > 0 && !strings.EqualFold(ct.Signer, signer.HexString()) {
+		return signer, fmt.Errorf("the time was signed by %s and not by %s", signer.HexString(), ct.Signer)
+	}
+	return signer, nil
+}
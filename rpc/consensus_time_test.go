@@ -0,0 +1,90 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestConsensusClock(t *testing.T) {
+	var cc ConsensusClock
+	if _, ok := cc.Now(); ok {
+		t.Fatalf("clock without a block shouldn't have a time")
+	}
+	received := time.Unix(1000000, 0)
+	cc.update(10, crypto.Sha3{1}, 1000000, received)
+	// older blocks don't move the clock back
+	cc.update(9, crypto.Sha3{2}, 2000000, received)
+
+	window := int64(windowSize * averageBlockTime / time.Second)
+	tests := []struct {
+		now  time.Time
+		min  int64
+		skew int64
+	}{
+		{now: received, min: 1000000, skew: 0},
+		{now: received.Add(time.Minute), min: 1000060, skew: 0},
+		{now: received.Add(time.Hour), min: 1003600, skew: 0},
+	}
+	for _, test := range tests {
+		ct, ok := cc.at(test.now)
+		if !ok || ct.BlockNumber != 10 {
+			t.Fatalf("clock should be at block 10")
+		}
+		if ct.Min != test.min || ct.Max != test.min+window || ct.Skew != test.skew {
+			t.Fatalf("expected min %d skew %d but got %+v", test.min, test.skew, ct)
+		}
+	}
+}
+
+func TestConsensusClockSkew(t *testing.T) {
+	var cc ConsensusClock
+	received := time.Now()
+	cc.update(1, crypto.Sha3{1}, uint64(received.Add(-time.Hour).Unix()), received)
+	ct, _ := cc.at(received)
+	if ct.Skew <= 0 {
+		t.Fatalf("system clock ahead of the block time should have a positive skew %+v", ct)
+	}
+
+	cc = ConsensusClock{}
+	cc.update(1, crypto.Sha3{1}, uint64(received.Add(time.Hour).Unix()), received)
+	ct, _ = cc.at(received)
+	if ct.Skew != -3600 {
+		t.Fatalf("system clock behind the block time should have a skew of -3600 %+v", ct)
+	}
+}
+
+func TestConsensusTimeSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cc ConsensusClock
+	cc.update(1, crypto.Sha3{1}, 1000000, time.Now())
+	ct, _ := cc.Now()
+	nonce := []byte("nonce")
+	if err = ct.Sign(crypto.NewKeySigner(key), nonce); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ct.Verify(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != util.PubkeyToAddress(crypto.MarshalPubkey(&key.PublicKey)) {
+		t.Fatalf("wrong signer %s", signer.HexString())
+	}
+	if _, err = ct.Verify([]byte("other")); err == nil {
+		t.Fatalf("signature with another nonce should be rejected")
+	}
+	ct.Midpoint++
+	if _, err = ct.Verify(nonce); err == nil {
+		t.Fatalf("signature of another time should be rejected")
+	}
+}
@@ -4,6 +4,7 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -54,7 +55,7 @@ func (p *DataPool) getCacheBNS(key string) (bns []Address, ok bool) {
 	return
 }
 
-func (p *DataPool) GetCacheOrResolveBNS(deviceName string, client *Client) ([]Address, error) {
+func (p *DataPool) GetCacheOrResolveBNS(ctx context.Context, deviceName string, client *Client) ([]Address, error) {
 	p.Lock(deviceName)
 	defer p.Unlock(deviceName)
 
@@ -64,7 +65,7 @@ func (p *DataPool) GetCacheOrResolveBNS(deviceName string, client *Client) ([]Ad
 		return bns, nil
 	}
 	var err error
-	bns, err = client.ResolveBNS(deviceName)
+	bns, err = client.ResolveBNS(ctx, deviceName)
 	if err == nil {
 		p.SetCacheBNS(bnsKey, bns)
 	}
@@ -92,12 +93,17 @@ func (p *DataPool) Unlock(name string) {
 	})
 }
 
-// ClosePorts closes all ports belonging to the given client
+// ClosePorts closes all ports belonging to the given client, ports that can
+// be reopened are moved to another relay instead
 func (p *DataPool) ClosePorts(client *Client) {
 	p.srv.Call(func() {
 		for k, v := range p.devices {
 			if v.client == client {
-				v.Close()
+				if v.reopen != nil {
+					go v.failover(client)
+				} else {
+					v.Close()
+				}
 				delete(p.devices, k)
 			}
 		}
@@ -13,7 +13,7 @@ import (
 
 // Dial connects to the BNS address on the named network.
 func (socksServer *Server) Dial(network, addr string) (net.Conn, error) {
-	return socksServer.DialContext(context.Background(), network, addr)
+	return socksServer.DialContext(socksServer.ctx, network, addr)
 }
 
 // DialContext connects to the BNS address on the named network using
@@ -32,7 +32,7 @@ func (socksServer *Server) DialContext(ctx context.Context, network, addr string
 
 	retChan := make(chan error, 1)
 	go func() {
-		err := socksServer.connectDeviceAndLoop(deviceID, port, protocol, mode, func(connPort *ConnectedPort) (net.Conn, error) {
+		err := socksServer.connectDeviceAndLoop(ctx, deviceID, port, protocol, mode, func(connPort *ConnectedPort) (net.Conn, error) {
 			retChan <- nil
 			return connDiode, nil
 		})
@@ -105,7 +105,7 @@ func (e2eServer *E2EServer) InternalClientConnect() error {
 
 func (e2eServer *E2EServer) ctx() *openssl.Ctx {
 	// This creates a new certificate each time of 48 hour validity.
-	return initSSLCtx(config.AppConfig)
+	return initSSLCtx(e2eServer.port.client.config)
 }
 
 func (e2eServer *E2EServer) checkPeer(ssl *openssl.Conn) error {
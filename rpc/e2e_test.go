@@ -29,6 +29,7 @@ func newTestE2EServer(remoteConn net.Conn, peer Address) (e2eServer E2EServer) {
 			latencySum:   100_000,
 			latencyCount: 1,
 			srv:          genserver.New("Port"),
+			config:       config.AppConfig,
 			s: &SSL{
 				addr: "localhost:41046",
 			},
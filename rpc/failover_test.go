@@ -0,0 +1,161 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/rpc/relaytest"
+	"github.com/diodechain/diode_client/util"
+)
+
+// failoverConfig returns the config of a device that connects to both relays,
+// each device has its own identity in the database of the test
+func failoverConfig(t *testing.T, base *config.Config, identity string, relay *relaytest.Relay) *config.Config {
+	cfg := *base
+	cfg.Identity = identity
+	pubKey, err := signerOf(&cfg).PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ClientAddr = util.PubkeyToAddress(pubKey)
+	lvbn, lvbh := relay.Chain.Checkpoint()
+	setLastValid(identity, lvbn, lvbh)
+	return &cfg
+}
+
+// TestBindFailover connects a publishing and a binding device to two relays
+// and closes the relay of a tls bind, the open connection of the bind is
+// closed and new connections of the binds use the other relay
+func TestBindFailover(t *testing.T) {
+	relays := make([]*relaytest.Relay, 2)
+	for i := range relays {
+		relay, err := relaytest.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+		relays[i] = relay
+	}
+	// both relays serve the same chain
+	relays[1].Chain = relays[0].Chain
+
+	dir, err := ioutil.TempDir("", "rpc_failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &config.Config{
+		DBPath:           filepath.Join(dir, "private.db"),
+		RetryTimes:       3,
+		EdgeE2ETimeout:   6 * time.Second,
+		RemoteRPCTimeout: 5 * time.Second,
+		RetryWait:        time.Second,
+		RemoteRPCAddrs:   config.StringValues{relays[0].Addr(), relays[1].Addr()},
+		RelayPoolSize:    2,
+		FleetAddr:        config.DefaultFleetAddr,
+		LogMode:          config.LogToConsole,
+	}
+	l, _ := config.NewLogger(base)
+	base.Logger = &l
+	config.AppConfig = base
+	clidb, err := db.OpenFile(base.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = clidb
+	defer func() {
+		clidb.Close()
+		os.RemoveAll(dir)
+	}()
+
+	publisherCfg := failoverConfig(t, base, "publisher", relays[0])
+	publisher := NewClientManager(publisherCfg)
+	publisher.GetPool().SetPublishedPorts(map[int]*config.Port{
+		80: {
+			SrcHost:  "127.0.0.1",
+			Src:      relaytest.Echo(t),
+			To:       80,
+			Mode:     config.PublicPublishedMode,
+			Protocol: config.AnyProtocol,
+		},
+	})
+	publisher.Start()
+	defer publisher.Stop()
+	binder := NewClientManager(failoverConfig(t, base, "binder", relays[0]))
+	binder.Start()
+	defer binder.Stop()
+	for _, cm := range []*ClientManager{publisher, binder} {
+		for _, relay := range relays {
+			relaytest.WaitFor(t, "the devices to connect to both relays", func() bool {
+				return cm.GetClient(relay.ID()) != nil
+			})
+		}
+	}
+
+	socksServer, err := NewSocksServer(Config{}, binder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socksServer.Close()
+	tlsPort, tcpPort := relaytest.FreePort(t), relaytest.FreePort(t)
+	socksServer.SetBinds([]config.Bind{{
+		To:        publisherCfg.ClientAddr.HexString(),
+		ToPort:    80,
+		LocalPort: tlsPort,
+		Protocol:  config.TLSProtocol,
+	}, {
+		To:        publisherCfg.ClientAddr.HexString(),
+		ToPort:    80,
+		LocalPort: tcpPort,
+		Protocol:  config.TCPProtocol,
+	}})
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(tlsPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	relaytest.ExpectEcho(t, conn, "before the failover")
+
+	failed, other := relays[0], relays[1]
+	if failed.OpenPorts() == 0 {
+		failed, other = other, failed
+	}
+	if failed.OpenPorts() != 1 || other.OpenPorts() != 0 {
+		t.Fatalf("expected the tls bind to use one relay but got %d and %d ports", failed.OpenPorts(), other.OpenPorts())
+	}
+	failed.Close()
+
+	// the stream can't be resumed through another relay, the application
+	// sees the connection close and has to reconnect
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("the connection of the tls bind should be closed with its relay")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatalf("the connection of the tls bind wasn't closed with its relay")
+	}
+	relaytest.WaitFor(t, "the binder to drop the failed relay", func() bool {
+		return binder.GetClient(failed.ID()) == nil
+	})
+
+	for _, port := range []int{tlsPort, tcpPort} {
+		conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		relaytest.ExpectEcho(t, conn, "through the other relay")
+	}
+	if other.OpenPorts() != 2 {
+		t.Fatalf("expected two ports through the other relay but got %d", other.OpenPorts())
+	}
+}
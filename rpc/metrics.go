@@ -11,6 +11,12 @@ import (
 	gometrics "github.com/rcrowley/go-metrics"
 )
 
+// reconnectCounter counts the attempts to reconnect to the network
+var reconnectCounter = gometrics.GetOrRegisterCounter("reconnect", nil)
+
+// forkCounter counts the checks that found relays with different chains
+var forkCounter = gometrics.GetOrRegisterCounter("consensus.fork", nil)
+
 // TODO: Enable other metrics?
 // TODO: Update logger
 type Metrics struct {
@@ -53,6 +59,16 @@ func (metrics *Metrics) UpdateWriteTimer(d time.Duration) {
 	metrics.writeTimer.Update(d)
 }
 
+// CountReconnect counts an attempt to reconnect to the network
+func CountReconnect() {
+	reconnectCounter.Inc(1)
+}
+
+// Reconnects returns the number of attempts to reconnect to the network
+func Reconnects() int64 {
+	return reconnectCounter.Count()
+}
+
 func (metrics *Metrics) Report() {
 	gometrics.Log(gometrics.DefaultRegistry, 10*time.Second, log.New(os.Stderr, "", log.LstdFlags))
 }
@@ -0,0 +1,243 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/edge"
+	"github.com/diodechain/diode_client/util"
+)
+
+// Kinds of proofs
+const (
+	AccountProof = "account"
+	StorageProof = "storage"
+)
+
+// Proof describes how an account or a storage value was verified against
+// the state of a block that the client validated
+type Proof struct {
+	Kind        string `json:"kind"`
+	BlockNumber uint64 `json:"block_number"`
+	BlockHash   string `json:"block_hash"`
+	// StateHash is the state root in the block header
+	StateHash string `json:"state_hash"`
+	Account   string `json:"account"`
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value,omitempty"`
+	// Balance and Nonce are only set for account proofs
+	Balance string `json:"balance,omitempty"`
+	Nonce   int64  `json:"nonce,omitempty"`
+	// Trees are the merkle proofs from the state root to the value, the
+	// storage proof follows the account proof
+	Trees  []ProofTree  `json:"trees"`
+	Checks []ProofCheck `json:"checks"`
+	Valid  bool         `json:"valid"`
+}
+
+// ProofTree is a merkle proof, its root hash is one of the 16 roots at the
+// position of the modulo
+type ProofTree struct {
+	Name     string      `json:"name"`
+	Roots    []string    `json:"roots"`
+	RootHash string      `json:"root_hash"`
+	Modulo   uint64      `json:"modulo"`
+	Nodes    []ProofNode `json:"nodes"`
+}
+
+// ProofNode is a node of a merkle proof
+type ProofNode struct {
+	Path   string      `json:"path"`
+	Hash   string      `json:"hash"`
+	Modulo uint64      `json:"modulo,omitempty"`
+	Leaves []ProofLeaf `json:"leaves,omitempty"`
+}
+
+// ProofLeaf is a key value pair in a bucket of a merkle proof
+type ProofLeaf struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ProofCheck is one step of the verification
+type ProofCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (p *Proof) check(name string, ok bool, format string, args ...interface{}) bool {
+	p.Checks = append(p.Checks, ProofCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+	p.Valid = p.Valid && ok
+	return ok
+}
+
+func newProofTree(name string, roots [][]byte, tree edge.MerkleTree) (pt ProofTree, err error) {
+	pt = ProofTree{
+		Name:     name,
+		RootHash: util.EncodeToString(tree.RootHash),
+		Modulo:   tree.Modulo,
+	}
+	for _, root := range roots {
+		pt.Roots = append(pt.Roots, util.EncodeToString(root))
+	}
+	nodes, err := tree.ProofNodes()
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		pn := ProofNode{Path: node.Path, Hash: util.EncodeToString(node.Hash), Modulo: node.Modulo}
+		for _, leave := range node.Leaves {
+			pn.Leaves = append(pn.Leaves, ProofLeaf{Key: util.EncodeToString(leave.Key), Value: util.EncodeToString(leave.Value)})
+		}
+		pt.Nodes = append(pt.Nodes, pn)
+	}
+	return
+}
+
+// validHeader returns the validated header of the block number, the last
+// valid block is used when blockNumber is 0
+func (client *Client) validHeader(blockNumber uint64) (bh blockquick.BlockHeader, err error) {
+	if client.validWindow() == nil {
+		return bh, fmt.Errorf("the client didn't validate any block yet")
+	}
+	if blockNumber == 0 {
+		blockNumber, _ = client.LastValid()
+	}
+	bh = client.GetBlockHeaderValid(blockNumber)
+	if bh.Number() != blockNumber {
+		return bh, fmt.Errorf("block %d is not in the validated window", blockNumber)
+	}
+	return bh, nil
+}
+
+// ProveAccount fetches the account and the state roots of the block and
+// verifies that the account is part of the state of the validated block
+func (client *Client) ProveAccount(ctx context.Context, blockNumber uint64, account Address) (*Proof, error) {
+	bh, err := client.validHeader(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	hash := bh.Hash()
+	proof := &Proof{
+		Kind:        AccountProof,
+		BlockNumber: bh.Number(),
+		BlockHash:   util.EncodeToString(hash[:]),
+		StateHash:   util.EncodeToString(bh.StateHash()),
+		Account:     account.HexString(),
+		Valid:       true,
+	}
+	if _, err = client.proveAccount(ctx, proof, account); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func (client *Client) proveAccount(ctx context.Context, proof *Proof, account Address) (*edge.Account, error) {
+	act, err := client.GetAccount(ctx, proof.BlockNumber, account)
+	if err != nil {
+		return nil, err
+	}
+	sts, err := client.GetStateRoots(ctx, proof.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if act == nil || sts == nil {
+		return nil, fmt.Errorf("unexpected response for account %s", account.HexString())
+	}
+	tree, err := newProofTree("state", sts.StateRoots, act.StateTree())
+	if err != nil {
+		return nil, err
+	}
+	proof.Trees = append(proof.Trees, tree)
+	if proof.Kind == AccountProof {
+		proof.Balance = util.ToString(act.Balance)
+		proof.Nonce = act.Nonce
+	}
+
+	stateRoot := util.EncodeToString(sts.StateRoot())
+	proof.check("state roots", stateRoot == proof.StateHash, "hash of the state roots %s, state hash of the block %s", stateRoot, proof.StateHash)
+	index := sts.Find(act.StateRoot())
+	proof.check("account", index >= 0 && uint64(index) == act.StateTree().Modulo, "root hash of the account proof is state root %d, expected %d", index, act.StateTree().Modulo)
+	proof.checkAccountValues(act.StateTree(), act, account)
+	return act, nil
+}
+
+// checkAccountValues verifies that the account proof stores the hash of the
+// nonce, balance, storage root and code that the relay returned, without it
+// the values aren't bound to the state
+func (p *Proof) checkAccountValues(tree edge.MerkleTree, act *edge.Account, account Address) {
+	hash, err := act.Hash()
+	if err != nil {
+		p.check("account values", false, "couldn't hash the account: %v", err)
+		return
+	}
+	value, err := tree.Get(account[:])
+	if err != nil {
+		p.check("account values", false, "account %s in the account proof", account.HexString())
+		return
+	}
+	p.check("account values", bytes.Equal(value, hash), "hash of the account values %s, value of the account proof %s", util.EncodeToString(hash), util.EncodeToString(value))
+}
+
+// ProveStorage fetches the storage value of the contract and verifies that
+// it's part of the storage of the contract in the state of the validated
+// block
+func (client *Client) ProveStorage(ctx context.Context, blockNumber uint64, contract Address, rawKey []byte) (*Proof, error) {
+	bh, err := client.validHeader(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	hash := bh.Hash()
+	key := util.PaddingBytesPrefix(rawKey, 0, 32)
+	proof := &Proof{
+		Kind:        StorageProof,
+		BlockNumber: bh.Number(),
+		BlockHash:   util.EncodeToString(hash[:]),
+		StateHash:   util.EncodeToString(bh.StateHash()),
+		Account:     contract.HexString(),
+		Key:         util.EncodeToString(key),
+		Valid:       true,
+	}
+	act, err := client.proveAccount(ctx, proof, contract)
+	if err != nil {
+		return nil, err
+	}
+	acv, err := client.GetAccountValue(ctx, proof.BlockNumber, contract, key)
+	if err != nil {
+		return nil, err
+	}
+	acr, err := client.GetAccountRoots(ctx, proof.BlockNumber, contract)
+	if err != nil {
+		return nil, err
+	}
+	if acv == nil || acr == nil {
+		return nil, fmt.Errorf("unexpected response for storage of %s", contract.HexString())
+	}
+	accountTree := acv.AccountTree()
+	tree, err := newProofTree("storage", acr.AccountRoots, accountTree)
+	if err != nil {
+		return nil, err
+	}
+	proof.Trees = append(proof.Trees, tree)
+	proof.checkStorage(act.StorageRoot, acr, accountTree, key)
+	return proof, nil
+}
+
+// checkStorage verifies the storage proof against the storage root of the
+// account and reads the value of the key from it
+func (p *Proof) checkStorage(storageRoot []byte, acr *edge.AccountRoots, tree edge.MerkleTree, key []byte) {
+	roots := acr.StorageRoot()
+	p.check("storage roots", bytes.Equal(roots, storageRoot), "hash of the storage roots %s, storage root of the account %s", util.EncodeToString(roots), util.EncodeToString(storageRoot))
+	index := acr.Find(tree.RootHash)
+	p.check("storage", index >= 0 && uint64(index) == tree.Modulo, "root hash of the storage proof is storage root %d, expected %d", index, tree.Modulo)
+	value, err := tree.Get(key)
+	if p.check("value", err == nil, "key %s in the storage proof", util.EncodeToString(key)) {
+		p.Value = util.EncodeToString(value)
+	}
+}
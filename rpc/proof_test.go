@@ -0,0 +1,114 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/diodechain/diode_client/edge"
+	"github.com/diodechain/diode_client/util"
+)
+
+// the storage proof of the edge merkle tree tests
+var (
+	testStorageRoots = [][]uint8{{0xdf, 0xa5, 0x3a, 0x7b, 0x26, 0x46, 0xc9, 0x7a, 0x2b, 0xf0, 0x32, 0x65, 0xd, 0x4d, 0xda, 0x13, 0x19, 0xad, 0x8f, 0xde, 0xcd, 0x53, 0x18, 0xb8, 0xb9, 0xa9, 0x4b, 0x45, 0xc3, 0x78, 0xd1, 0xf9}, []uint8{0x74, 0x9e, 0x41, 0xd, 0xc0, 0x43, 0xef, 0x28, 0x6e, 0xf2, 0xbe, 0x4c, 0xe4, 0xe2, 0x51, 0xfc, 0x15, 0x33, 0x59, 0xd5, 0x88, 0xa5, 0x19, 0x36, 0x55, 0x3d, 0xc7, 0x15, 0x57, 0xd1, 0x4c, 0x54}, []uint8{0x73, 0xfc, 0x54, 0xec, 0x65, 0x4a, 0xcc, 0x34, 0x78, 0x92, 0xd9, 0xe5, 0x18, 0xd5, 0xb8, 0xad, 0x45, 0x61, 0x3e, 0x5c, 0x69, 0x86, 0x5b, 0x35, 0xbe, 0x3b, 0xce, 0x7f, 0xc7, 0x47, 0xca, 0xa3}, []uint8{0x4, 0x2f, 0xb7, 0xf6, 0x40, 0xcd, 0x4f, 0x4a, 0x31, 0x32, 0xa2, 0x34, 0x80, 0x11, 0x10, 0xee, 0x10, 0xb1, 0x55, 0xec, 0x20, 0x90, 0xbf, 0xec, 0xa2, 0x3, 0x1, 0x7c, 0x3d, 0xcd, 0xd2, 0x7c}, []uint8{0x60, 0xd7, 0x3, 0x4e, 0x5e, 0x2b, 0x6d, 0x5e, 0xe7, 0xb6, 0xdb, 0x5b, 0x2a, 0x34, 0xa9, 0x78, 0xd, 0x44, 0x94, 0x36, 0x93, 0xab, 0xbc, 0x70, 0x47, 0x47, 0x71, 0x44, 0x47, 0x29, 0xfb, 0x72}, []uint8{0xa1, 0xb3, 0xc3, 0x26, 0xb6, 0xdd, 0x6b, 0xb4, 0x81, 0x5b, 0xd5, 0x6a, 0x7, 0x16, 0x5c, 0xaf, 0xf9, 0x48, 0x7e, 0x2f, 0x81, 0x77, 0xb0, 0x89, 0xb7, 0x6e, 0xae, 0x84, 0xe5, 0xa3, 0x70, 0x69}, []uint8{0x77, 0x55, 0x18, 0x3, 0xee, 0xcd, 0xa8, 0xc7, 0xf0, 0x52, 0xd8, 0x6c, 0xb8, 0xb4, 0xcb, 0xff, 0xa2, 0x21, 0x3c, 0x9, 0x58, 0xfe, 0x6b, 0x49, 0xc1, 0xe2, 0xd0, 0x38, 0xab, 0x60, 0x22, 0x99}, []uint8{0x60, 0xd1, 0xc8, 0x9a, 0x2f, 0x49, 0xab, 0x70, 0xdc, 0x3e, 0x58, 0x9e, 0x2a, 0xa0, 0xd3, 0x2a, 0xf, 0x18, 0x9, 0x77, 0x9c, 0xbc, 0x66, 0x9f, 0xe5, 0x7e, 0x91, 0x7f, 0x66, 0x37, 0xab, 0xf1}, []uint8{0x80, 0x40, 0x8, 0xf4, 0x1e, 0xbe, 0x1, 0x8d, 0x65, 0x66, 0xf7, 0x29, 0x8a, 0x8f, 0xbd, 0x70, 0x43, 0x2b, 0x45, 0x55, 0xa7, 0x83, 0xeb, 0x52, 0x73, 0xac, 0xb2, 0x4f, 0x38, 0x48, 0xab, 0x5a}, []uint8{0x33, 0x69, 0x61, 0xb4, 0xba, 0xcb, 0xdd, 0x4a, 0xe8, 0x2d, 0x20, 0x7f, 0xd6, 0xe, 0xd8, 0x1b, 0xc3, 0xff, 0xee, 0xa4, 0x1, 0xc4, 0x81, 0x4d, 0x7d, 0x52, 0xd8, 0x59, 0xef, 0xa3, 0x6, 0xca}, []uint8{0x5b, 0x7f, 0xd2, 0x89, 0xa9, 0x18, 0xf2, 0xd8, 0x42, 0x35, 0x19, 0xbe, 0xa9, 0xdf, 0xf5, 0xcc, 0x4, 0xf4, 0xce, 0x92, 0xdd, 0x7a, 0xad, 0xe5, 0x1a, 0xba, 0xa4, 0xb4, 0xe0, 0x91, 0xdb, 0x36}, []uint8{0x82, 0xe8, 0xcf, 0x8a, 0xdb, 0xf0, 0xff, 0x22, 0x70, 0xbb, 0xa7, 0x1a, 0x7e, 0x5a, 0xda, 0xcc, 0xf5, 0x73, 0x47, 0xd1, 0x4b, 0xbf, 0xa1, 0x78, 0x6d, 0xc2, 0x16, 0x9a, 0x60, 0x25, 0x4d, 0x46}, []uint8{0xfb, 0x58, 0x65, 0xbb, 0x11, 0xa2, 0x16, 0x3b, 0x86, 0x54, 0xbb, 0xe9, 0x6c, 0x99, 0x62, 0xf7, 0xce, 0x31, 0xa9, 0xf3, 0xe3, 0x5d, 0xc3, 0xd5, 0x16, 0xa3, 0x2c, 0x5e, 0xc6, 0x20, 0x88, 0x8c}, []uint8{0x69, 0xa2, 0xb3, 0x66, 0x4f, 0x95, 0x8f, 0x79, 0x88, 0xfe, 0x5d, 0x44, 0xf8, 0x6e, 0x7d, 0x57, 0x34, 0x22, 0x4, 0x6, 0xa0, 0x27, 0xdf, 0xbb, 0xc6, 0x95, 0x96, 0x37, 0x5e, 0x60, 0xb3, 0xcc}, []uint8{0xac, 0xaf, 0x97, 0x9a, 0x64, 0xcf, 0xb2, 0xd4, 0xa5, 0xec, 0x9, 0x79, 0x87, 0xcd, 0x15, 0xb4, 0xb8, 0xac, 0x1f, 0x9, 0x3a, 0x24, 0x30, 0x13, 0xd8, 0x9a, 0xb0, 0x18, 0xec, 0xab, 0xd6, 0x61}, []uint8{0x88, 0xd4, 0xe9, 0x9, 0xeb, 0x6d, 0x90, 0x57, 0xad, 0x57, 0x93, 0x19, 0xa3, 0x8, 0x93, 0xc6, 0x4c, 0xb9, 0xe7, 0x67, 0xf8, 0x23, 0xbd, 0x34, 0x36, 0x32, 0xd2, 0xba, 0xd6, 0xd5, 0x69, 0x18}}
+	testStorageKey   = []byte{0x81, 0x34, 0x4, 0x93, 0xdf, 0xd9, 0x81, 0xb9, 0x6d, 0x4, 0x44, 0x77, 0x51, 0xfa, 0x73, 0xee, 0x12, 0x15, 0xd8, 0x54, 0xb3, 0x11, 0xc2, 0x20, 0x7, 0x47, 0xdf, 0xf9, 0x6d, 0xf, 0xfa, 0x49}
+	testStorageTree  = []interface{}{[]uint8{0x4, 0x1c, 0xd1, 0x97, 0x54, 0x15, 0xf3, 0xe8, 0x9f, 0xc, 0x9, 0x6d, 0x42, 0xe4, 0x5f, 0xdc, 0xe3, 0x28, 0xa5, 0xeb, 0xf6, 0x8d, 0xd9, 0x38, 0xee, 0x5f, 0x84, 0xca, 0x7a, 0xc4, 0xb6, 0x28}, []interface{}{[]uint8{0xd9, 0x7c, 0xec, 0xc8, 0x30, 0x12, 0x2c, 0x87, 0x5c, 0x1f, 0xa6, 0xf7, 0xe5, 0xc4, 0x69, 0x5e, 0xfe, 0x3f, 0x1, 0x71, 0x9d, 0x5c, 0xae, 0x76, 0xa2, 0xbb, 0xb, 0x28, 0xb7, 0xcb, 0xb0, 0x2d}, []interface{}{[]uint8{0x1b, 0x6, 0x3b, 0xc7, 0x40, 0xed, 0xe8, 0x41, 0x39, 0x89, 0x67, 0xc0, 0x8d, 0x8c, 0x57, 0x55, 0xb4, 0xe6, 0x3a, 0x12, 0x3b, 0x1e, 0x90, 0x74, 0x44, 0x75, 0x83, 0x48, 0x42, 0x15, 0xee, 0x9f}, []interface{}{[]interface{}{[]interface{}{[]interface{}{[]uint8{0xe2, 0x4f, 0x1a, 0x4c, 0x1b, 0x5, 0x7d, 0x80, 0x18, 0xf, 0x7c, 0x97, 0x18, 0x42, 0xb, 0xbe, 0x8e, 0x36, 0x9c, 0x69, 0x48, 0x89, 0x50, 0x66, 0x22, 0x36, 0x6c, 0x5a, 0xdd, 0x24, 0x3f, 0x4c}, []interface{}{[]interface{}{[]interface{}{[]uint8{0x31, 0x31, 0x31, 0x30, 0x30, 0x30, 0x31, 0x30, 0x30}, []uint8{0x6}, []interface{}{[]uint8{0x58, 0x6e, 0xc1, 0xcb, 0x7f, 0x76, 0x9e, 0x3c, 0x65, 0xc6, 0x31, 0xff, 0x93, 0x54, 0x44, 0x7, 0xd7, 0xd5, 0x2f, 0xe4, 0xe9, 0xa7, 0x9c, 0x7e, 0x58, 0x7c, 0x69, 0x23, 0x9e, 0x88, 0x80, 0xb4}, []uint8{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xcb, 0x5f, 0xed, 0xa8, 0x79, 0xf9, 0x14, 0xa3, 0x71, 0xd3, 0xd0, 0x21, 0x20, 0x65, 0x5d, 0xd0, 0xbf, 0xaf, 0x54, 0x94}}, []interface{}{[]uint8{0x81, 0x34, 0x4, 0x93, 0xdf, 0xd9, 0x81, 0xb9, 0x6d, 0x4, 0x44, 0x77, 0x51, 0xfa, 0x73, 0xee, 0x12, 0x15, 0xd8, 0x54, 0xb3, 0x11, 0xc2, 0x20, 0x7, 0x47, 0xdf, 0xf9, 0x6d, 0xf, 0xfa, 0x49}, []uint8{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xc2, 0x6, 0xe1, 0x25, 0x5c, 0xba, 0xce, 0x8b, 0xa9, 0x4, 0xda, 0xa2, 0x59, 0xd7, 0xa5, 0xb7, 0xf9, 0xe, 0x2d, 0x50}}}, []uint8{0xa4, 0xd5, 0xe2, 0x56, 0xca, 0xb5, 0x7a, 0x2b, 0x29, 0x9e, 0xba, 0xe3, 0xf4, 0xea, 0x39, 0x12, 0xd3, 0xba, 0x91, 0x98, 0xab, 0xf0, 0x77, 0xda, 0x5, 0xb7, 0x35, 0xb3, 0xeb, 0x5f, 0xc1, 0x45}}, []uint8{0x22, 0xb, 0x7b, 0x5f, 0x81, 0x4c, 0x6e, 0x2b, 0xa8, 0x6c, 0x4d, 0xda, 0x9b, 0x4c, 0xd0, 0x5, 0x27, 0x9f, 0x55, 0xa1, 0x34, 0x72, 0x80, 0xfe, 0xfe, 0xcd, 0xe5, 0xc3, 0x1e, 0x44, 0x29, 0xde}}}, []uint8{0x5f, 0x45, 0x14, 0x55, 0x60, 0xf3, 0x89, 0x95, 0x63, 0x0, 0xe2, 0xa6, 0x17, 0xfa, 0x39, 0x7b, 0xb1, 0x28, 0xc8, 0xc1, 0x23, 0xa1, 0x1d, 0xeb, 0xa9, 0x78, 0x4c, 0x78, 0x70, 0x66, 0xf3, 0x9a}}, []uint8{0xbe, 0x4, 0x0, 0x98, 0xdb, 0xe7, 0x31, 0x9d, 0x6d, 0xa0, 0xc0, 0x6b, 0xc7, 0x54, 0x35, 0x19, 0x99, 0xcb, 0x1, 0xdb, 0xfc, 0x2d, 0x7f, 0x57, 0x22, 0x35, 0x5a, 0x20, 0x98, 0x47, 0x6e, 0xd5}}, []uint8{0xd7, 0xf1, 0xd6, 0x91, 0x99, 0x1f, 0x80, 0x6d, 0xb, 0x27, 0x5b, 0x8c, 0x87, 0x1, 0x6f, 0xcf, 0x73, 0x4a, 0xd4, 0x70, 0x60, 0xc8, 0xcd, 0x14, 0x53, 0xdd, 0x5, 0x86, 0x56, 0x57, 0x7d, 0x1f}}}}}
+	testStorageValue = []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xc2, 0x6, 0xe1, 0x25, 0x5c, 0xba, 0xce, 0x8b, 0xa9, 0x4, 0xda, 0xa2, 0x59, 0xd7, 0xa5, 0xb7, 0xf9, 0xe, 0x2d, 0x50}
+)
+
+func TestCheckStorage(t *testing.T) {
+	tree, err := edge.NewMerkleTree(testStorageTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acr := &edge.AccountRoots{AccountRoots: testStorageRoots}
+	storageRoot := acr.StorageRoot()
+
+	proof := &Proof{Valid: true}
+	proof.checkStorage(storageRoot, acr, tree, testStorageKey)
+	if !proof.Valid || proof.Value != util.EncodeToString(testStorageValue) {
+		t.Fatalf("expected a valid proof of the value but got %+v", proof)
+	}
+
+	// a tampered root of the tree doesn't match the storage root of the
+	// account anymore and the proof doesn't lead to it
+	tampered := make([][]byte, len(testStorageRoots))
+	for i, root := range testStorageRoots {
+		tampered[i] = append([]byte{}, root...)
+	}
+	tampered[tree.Modulo][0] ^= 0xff
+	proof = &Proof{Valid: true}
+	proof.checkStorage(storageRoot, &edge.AccountRoots{AccountRoots: tampered}, tree, testStorageKey)
+	if proof.Valid || failedChecks(proof) != 2 {
+		t.Fatalf("expected the storage roots and the storage check to fail but got %+v", proof.Checks)
+	}
+
+	// the roots are fine but the account has another storage root
+	proof = &Proof{Valid: true}
+	proof.checkStorage(bytes.Repeat([]byte{1}, 32), acr, tree, testStorageKey)
+	if proof.Valid || failedChecks(proof) != 1 || proof.Checks[0].OK {
+		t.Fatalf("expected the storage roots check to fail but got %+v", proof.Checks)
+	}
+
+	// the key is not part of the proof
+	missing := append([]byte{}, testStorageKey...)
+	missing[0] ^= 0xff
+	proof = &Proof{Valid: true}
+	proof.checkStorage(storageRoot, acr, tree, missing)
+	if proof.Valid || len(proof.Value) > 0 {
+		t.Fatalf("expected the value check to fail but got %+v", proof.Checks)
+	}
+}
+
+func failedChecks(proof *Proof) (failed int) {
+	for _, check := range proof.Checks {
+		if !check.OK {
+			failed++
+		}
+	}
+	return
+}
+
+func TestCheckAccountValues(t *testing.T) {
+	account := Address{1, 2, 3}
+	act := &edge.Account{
+		StorageRoot: bytes.Repeat([]byte{2}, 32),
+		Nonce:       3,
+		Code:        bytes.Repeat([]byte{4}, 32),
+		Balance:     big.NewInt(5),
+	}
+	hash, err := act.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := edge.NewMerkleTree([]interface{}{[]byte{}, []byte{0}, []interface{}{account[:], hash}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof := &Proof{Valid: true}
+	proof.checkAccountValues(tree, act, account)
+	if !proof.Valid {
+		t.Fatalf("expected valid account values but got %+v", proof.Checks)
+	}
+
+	// a relay can't change the values without changing the state
+	forged := *act
+	forged.Balance = big.NewInt(500)
+	proof = &Proof{Valid: true}
+	proof.checkAccountValues(tree, &forged, account)
+	if proof.Valid {
+		t.Fatalf("expected the forged balance to fail but got %+v", proof.Checks)
+	}
+
+	// the proof has to contain the account
+	proof = &Proof{Valid: true}
+	proof.checkAccountValues(tree, act, Address{9})
+	if proof.Valid {
+		t.Fatalf("expected the missing account to fail but got %+v", proof.Checks)
+	}
+}
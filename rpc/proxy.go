@@ -78,7 +78,7 @@ func (proxyServer *ProxyServer) isAllowedDevice(deviceName string) (err error) {
 		return
 	}
 	if !util.IsHex([]byte(deviceName)) {
-		deviceIDs, err = client.GetCacheOrResolveBNS(deviceName)
+		deviceIDs, err = client.GetCacheOrResolveBNS(socksServer.ctx, deviceName)
 		if err != nil {
 			return
 		}
@@ -91,20 +91,7 @@ func (proxyServer *ProxyServer) isAllowedDevice(deviceName string) (err error) {
 	deviceID := deviceIDs[0]
 
 	// Checking blocklist and allowlist
-	if len(socksServer.Config.Blocklists) > 0 {
-		if socksServer.Config.Blocklists[deviceID] {
-			err = fmt.Errorf("device %x is in the block list", deviceName)
-			return
-		}
-	} else {
-		if len(socksServer.Config.Allowlists) > 0 {
-			if !socksServer.Config.Allowlists[deviceID] {
-				err = fmt.Errorf("device %x is not in the allow list", deviceName)
-				return
-			}
-		}
-	}
-	return nil
+	return socksServer.Config.AccessLists.Check(deviceID)
 }
 
 func (proxyServer *ProxyServer) pipeProxy(w http.ResponseWriter, r *http.Request) {
@@ -133,7 +120,7 @@ func (proxyServer *ProxyServer) pipeProxy(w http.ResponseWriter, r *http.Request
 	}
 
 	protocol := config.TLSProtocol
-	err = proxyServer.socksServer.connectDeviceAndLoop(deviceID, port, protocol, mode, func(*ConnectedPort) (net.Conn, error) {
+	err = proxyServer.socksServer.connectDeviceAndLoop(r.Context(), deviceID, port, protocol, mode, func(*ConnectedPort) (net.Conn, error) {
 		upgrader := websocket.Upgrader{
 			CheckOrigin:       func(_ *http.Request) bool { return true },
 			EnableCompression: true,
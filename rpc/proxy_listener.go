@@ -73,7 +73,7 @@ func (pl *proxyListener) run() {
 			}
 
 			protocol := config.TLSProtocol
-			err = pl.proxy.socksServer.connectDeviceAndLoop(deviceID, port, protocol, mode, func(*ConnectedPort) (net.Conn, error) {
+			err = pl.proxy.socksServer.connectDeviceAndLoop(pl.proxy.socksServer.ctx, deviceID, port, protocol, mode, func(*ConnectedPort) (net.Conn, error) {
 				return conn, nil
 			})
 
@@ -0,0 +1,162 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the circuit breaker quarantines a relay after healthMaxFailures calls in
+	// a row timed out, or after a single blockquick validation failure
+	healthMaxFailures = 5
+	// quarantined relays are not used as primary or secondary relay and are
+	// not dialed again for healthCoolDown
+	healthCoolDown = 10 * time.Minute
+)
+
+// Reasons to quarantine a relay
+const (
+	quarantineTimeouts      = "timeouts"
+	quarantineInvalidBlocks = "invalid blocks"
+)
+
+// relayHealth counts the outcome of the calls to a relay, it's updated
+// concurrently by the callers of the client
+type relayHealth struct {
+	calls              uint64
+	errors             uint64
+	timeouts           uint64
+	validationFailures uint64
+	// failures counts the timeouts since the last successful call
+	failures uint64
+	// quarantinedUntil is the unix time in nanoseconds
+	quarantinedUntil int64
+}
+
+// record counts the result of a call and returns true when the circuit
+// breaker trips
+func (h *relayHealth) record(err error) bool {
+	atomic.AddUint64(&h.calls, 1)
+	switch e := err.(type) {
+	case nil:
+		atomic.StoreUint64(&h.failures, 0)
+	case TimeoutError:
+		atomic.AddUint64(&h.timeouts, 1)
+		if atomic.AddUint64(&h.failures, 1) >= healthMaxFailures {
+			return h.trip()
+		}
+	case CancelledError:
+		// calls that were cancelled by the caller don't tell anything about
+		// the relay, e.Err is nil when the connection was closed
+		if e.Err == nil {
+			atomic.AddUint64(&h.errors, 1)
+		}
+	default:
+		atomic.AddUint64(&h.errors, 1)
+	}
+	return false
+}
+
+// validationFailed counts a blockquick validation failure and trips the
+// circuit breaker
+func (h *relayHealth) validationFailed() bool {
+	atomic.AddUint64(&h.validationFailures, 1)
+	return h.trip()
+}
+
+// trip quarantines the relay, it returns false when the relay was already
+// quarantined
+func (h *relayHealth) trip() bool {
+	atomic.StoreUint64(&h.failures, 0)
+	now := time.Now()
+	until := now.Add(healthCoolDown).UnixNano()
+	for {
+		old := atomic.LoadInt64(&h.quarantinedUntil)
+		if old > now.UnixNano() {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&h.quarantinedUntil, old, until) {
+			return true
+		}
+	}
+}
+
+// quarantined returns true during the cool-down of the circuit breaker
+func (h *relayHealth) quarantined(now time.Time) bool {
+	return atomic.LoadInt64(&h.quarantinedUntil) > now.UnixNano()
+}
+
+// score returns the share of the calls that succeeded from 0 to 1, relays
+// that sent invalid blocks score 0
+func (h *relayHealth) score() float64 {
+	if atomic.LoadUint64(&h.validationFailures) > 0 {
+		return 0
+	}
+	calls := atomic.LoadUint64(&h.calls)
+	if calls == 0 {
+		return 1
+	}
+	failed := atomic.LoadUint64(&h.errors) + atomic.LoadUint64(&h.timeouts)
+	if failed >= calls {
+		return 0
+	}
+	return float64(calls-failed) / float64(calls)
+}
+
+// RelayScore is the health of a relay as seen by the client
+type RelayScore struct {
+	Host               string    `json:"host"`
+	NodeID             string    `json:"node_id,omitempty"`
+	Connected          bool      `json:"connected"`
+	Primary            bool      `json:"primary,omitempty"`
+	Secondary          bool      `json:"secondary,omitempty"`
+	LatencyMS          int64     `json:"latency_ms,omitempty"`
+	Calls              uint64    `json:"calls"`
+	Errors             uint64    `json:"errors"`
+	Timeouts           uint64    `json:"timeouts"`
+	ValidationFailures uint64    `json:"validation_failures"`
+	Score              float64   `json:"score"`
+	QuarantinedUntil   time.Time `json:"quarantined_until"`
+	QuarantineReason   string    `json:"quarantine_reason,omitempty"`
+}
+
+// scoreOf returns the score of a connected client
+func scoreOf(client *Client) RelayScore {
+	h := &client.health
+	score := RelayScore{
+		Host:               client.host,
+		Connected:          true,
+		LatencyMS:          client.measuredLatency().Milliseconds(),
+		Calls:              atomic.LoadUint64(&h.calls),
+		Errors:             atomic.LoadUint64(&h.errors),
+		Timeouts:           atomic.LoadUint64(&h.timeouts),
+		ValidationFailures: atomic.LoadUint64(&h.validationFailures),
+		Score:              h.score(),
+	}
+	if client.serverID != [20]byte{} {
+		score.NodeID = client.serverID.HexString()
+	}
+	if until := atomic.LoadInt64(&h.quarantinedUntil); until > 0 {
+		score.QuarantinedUntil = time.Unix(0, until)
+	}
+	return score
+}
+
+// sortScores sorts the connected relays by score and latency before the
+// relays that are only quarantined
+func sortScores(scores []RelayScore) {
+	sort.SliceStable(scores, func(i, j int) bool {
+		a, b := scores[i], scores[j]
+		if a.Connected != b.Connected {
+			return a.Connected
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.LatencyMS < b.LatencyMS
+	})
+}
@@ -0,0 +1,72 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestRelayHealth(t *testing.T) {
+	var h relayHealth
+	if h.score() != 1 {
+		t.Fatalf("expected a new relay to score 1 but got %v", h.score())
+	}
+	h.record(nil)
+	h.record(fmt.Errorf("not found"))
+	// cancelled by the caller
+	h.record(CancelledError{Err: context.Canceled})
+	if h.errors != 1 || h.calls != 3 {
+		t.Fatalf("expected 1 error in 3 calls but got %d in %d", h.errors, h.calls)
+	}
+
+	// a successful call resets the timeouts in a row
+	for i := 0; i < healthMaxFailures-1; i++ {
+		if h.record(TimeoutError{}) {
+			t.Fatalf("expected the circuit breaker to trip after %d timeouts", healthMaxFailures)
+		}
+	}
+	h.record(nil)
+	for i := 0; i < healthMaxFailures-1; i++ {
+		h.record(TimeoutError{})
+	}
+	if h.quarantined(time.Now()) {
+		t.Fatalf("expected the relay not to be quarantined")
+	}
+	if !h.record(TimeoutError{}) || !h.quarantined(time.Now()) {
+		t.Fatalf("expected the circuit breaker to trip after %d timeouts", healthMaxFailures)
+	}
+	if h.quarantined(time.Now().Add(healthCoolDown)) {
+		t.Fatalf("expected the quarantine to end after the cool-down")
+	}
+	if h.validationFailed() {
+		t.Fatalf("expected the circuit breaker to trip only once during the cool-down")
+	}
+	if h.score() != 0 {
+		t.Fatalf("expected a relay with invalid blocks to score 0 but got %v", h.score())
+	}
+}
+
+func TestRelayQuarantine(t *testing.T) {
+	rc := &relayCache{relays: make(map[string]*Relay)}
+	rc.seen("bad:41046", util.Address{1}, 10*time.Millisecond)
+	rc.seen("good:41046", util.Address{2}, 20*time.Millisecond)
+	now := time.Now()
+	rc.quarantine("bad:41046", now.Add(healthCoolDown), quarantineTimeouts)
+
+	good, _ := rc.candidates(now)
+	if len(good) != 1 || good[0].Host != "good:41046" {
+		t.Fatalf("expected the quarantined relay to be skipped but got %+v", good)
+	}
+	if !rc.isFailing("bad:41046", now) {
+		t.Fatalf("expected the quarantined relay to be failing")
+	}
+	if good, _ = rc.candidates(now.Add(healthCoolDown)); len(good) != 2 {
+		t.Fatalf("expected the relay to be used again after the cool-down but got %+v", good)
+	}
+}
@@ -0,0 +1,184 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/util"
+)
+
+// Relay selection policies
+const (
+	// LowestLatencyPolicy connects to the known relays with the lowest latency
+	// and uses the fastest relay as primary
+	LowestLatencyPolicy = "lowest-latency"
+	// StickyPrimaryPolicy is like LowestLatencyPolicy but keeps the primary
+	// relay until it disconnects
+	StickyPrimaryPolicy = "sticky-primary"
+	// RandomPolicy connects to random relays and uses the fastest relay as
+	// primary
+	RandomPolicy = "random"
+)
+
+// ValidRelayPolicy returns an error when name is not a relay selection policy
+func ValidRelayPolicy(name string) error {
+	switch name {
+	case LowestLatencyPolicy, StickyPrimaryPolicy, RandomPolicy:
+		return nil
+	}
+	return fmt.Errorf("unknown relay policy %s, expected %s, %s or %s", name, LowestLatencyPolicy, StickyPrimaryPolicy, RandomPolicy)
+}
+
+// relayPolicy decides which relays the ClientManager connects to and which
+// of the connected relays are used as primary and secondary
+type relayPolicy struct {
+	name     string
+	poolSize int
+	// pinned relays are always connected
+	pinned []string
+	// excluded relays are never selected, they are given by host or node id
+	excluded map[string]bool
+	// region is the prefix of the relay host names that are preferred
+	region string
+	// the primary and secondary relay are only replaced by a relay that is
+	// faster by more than hysteresis
+	hysteresis time.Duration
+}
+
+func newRelayPolicy(cfg *config.Config) *relayPolicy {
+	p := &relayPolicy{
+		name:       cfg.RelayPolicy,
+		poolSize:   cfg.RelayPoolSize,
+		excluded:   make(map[string]bool, len(cfg.ExcludedRelays)),
+		region:     strings.ToLower(cfg.RelayRegion),
+		hysteresis: cfg.RelayHysteresis,
+	}
+	if len(p.name) == 0 {
+		p.name = LowestLatencyPolicy
+	}
+	if p.poolSize <= 0 {
+		p.poolSize = 5
+	}
+	for _, host := range cfg.PinnedRelays {
+		if !util.StringsContain(p.pinned, host) {
+			p.pinned = append(p.pinned, host)
+		}
+	}
+	if len(p.pinned) > p.poolSize {
+		p.poolSize = len(p.pinned)
+	}
+	for _, relay := range cfg.ExcludedRelays {
+		p.excluded[strings.ToLower(relay)] = true
+	}
+	return p
+}
+
+// excludes returns true when the relay host or node id was excluded
+func (p *relayPolicy) excludes(host string, nodeID string) bool {
+	return p.excluded[strings.ToLower(host)] || (len(nodeID) > 0 && p.excluded[strings.ToLower(nodeID)])
+}
+
+// inRegion returns true when the host name of the relay starts with the
+// preferred region, e.g. eu1.prenet.diode.io for the region eu
+func (p *relayPolicy) inRegion(host string) bool {
+	if len(p.region) == 0 {
+		return false
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.HasPrefix(strings.ToLower(host), p.region)
+}
+
+// orderRelays applies the policy to the relay candidates of the cache
+func (p *relayPolicy) orderRelays(relays []*Relay) []*Relay {
+	ordered := make([]*Relay, 0, len(relays))
+	for _, r := range relays {
+		if !p.excludes(r.Host, r.NodeID) {
+			ordered = append(ordered, r)
+		}
+	}
+	if p.name == RandomPolicy {
+		rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return p.inRegion(ordered[i].Host) && !p.inRegion(ordered[j].Host)
+	})
+	return ordered
+}
+
+// pickHost returns a random host, hosts of the preferred region first
+func (p *relayPolicy) pickHost(hosts []string) string {
+	var inRegion []string
+	for _, host := range hosts {
+		if p.inRegion(host) {
+			inRegion = append(inRegion, host)
+		}
+	}
+	if len(inRegion) > 0 {
+		hosts = inRegion
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	return hosts[rand.Intn(len(hosts))]
+}
+
+// rankTopClients returns the new primary and secondary relay, the current
+// ones keep their place unless another relay is faster by more than the
+// hysteresis or is in the preferred region
+func (p *relayPolicy) rankTopClients(clients []*Client, current [2]*Client) (top [2]*Client) {
+	online := make(ByLatency, 0, len(clients))
+	for _, client := range clients {
+		if !p.excludes(client.host, client.serverID.HexString()) {
+			online = append(online, client)
+		}
+	}
+	sort.Stable(online)
+	sort.SliceStable(online, func(i, j int) bool {
+		return p.inRegion(online[i].host) && !p.inRegion(online[j].host)
+	})
+
+	isOnline := func(client *Client) bool {
+		for _, c := range online {
+			if c == client {
+				return true
+			}
+		}
+		return false
+	}
+	margin := p.hysteresis.Milliseconds()
+	for i := range top {
+		var best *Client
+		for _, c := range online {
+			if c != top[0] {
+				best = c
+				break
+			}
+		}
+		top[i] = best
+		cur := current[i]
+		if best == nil || cur == nil || cur == best || cur == top[0] || !isOnline(cur) {
+			continue
+		}
+		if i == 0 && p.name == StickyPrimaryPolicy {
+			top[i] = cur
+			continue
+		}
+		if p.inRegion(best.host) && !p.inRegion(cur.host) {
+			continue
+		}
+		if best.averageLatency()+margin >= cur.averageLatency() {
+			top[i] = cur
+		}
+	}
+	return
+}
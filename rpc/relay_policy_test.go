@@ -0,0 +1,82 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/util"
+)
+
+func testRelayClient(host string, id byte, latency int64) *Client {
+	return &Client{host: host, serverID: util.Address{id}, latencySum: latency, latencyCount: 1}
+}
+
+func TestRankTopClients(t *testing.T) {
+	policy := newRelayPolicy(&config.Config{RelayHysteresis: 20 * time.Millisecond})
+	primary := testRelayClient("us1.prenet.diode.io:41046", 1, 100)
+	secondary := testRelayClient("us2.prenet.diode.io:41046", 2, 120)
+	clients := []*Client{primary, secondary}
+
+	top := policy.rankTopClients(clients, [2]*Client{})
+	if top[0] != primary || top[1] != secondary {
+		t.Fatalf("expected the relays sorted by latency but got %s %s", top[0].host, top[1].host)
+	}
+
+	// small latency changes don't switch the primary
+	secondary.latencySum = 90
+	if top = policy.rankTopClients(clients, top); top[0] != primary {
+		t.Fatalf("expected the primary to be kept but got %s", top[0].host)
+	}
+	secondary.latencySum = 70
+	if top = policy.rankTopClients(clients, top); top[0] != secondary || top[1] != primary {
+		t.Fatalf("expected the faster relay to become primary but got %s", top[0].host)
+	}
+
+	// sticky primary is only replaced when it disconnects
+	sticky := newRelayPolicy(&config.Config{RelayPolicy: StickyPrimaryPolicy})
+	primary.latencySum = 10
+	if top = sticky.rankTopClients(clients, top); top[0] != secondary {
+		t.Fatalf("expected the sticky primary to be kept but got %s", top[0].host)
+	}
+	if top = sticky.rankTopClients([]*Client{primary}, top); top[0] != primary || top[1] != nil {
+		t.Fatalf("expected the remaining relay to become primary but got %+v", top)
+	}
+
+	// excluded relays are never used and the region is preferred
+	regional := newRelayPolicy(&config.Config{
+		RelayRegion:    "eu",
+		ExcludedRelays: config.StringValues{primary.serverID.HexString()},
+	})
+	europe := testRelayClient("eu1.prenet.diode.io:41046", 3, 200)
+	top = regional.rankTopClients([]*Client{primary, secondary, europe}, [2]*Client{primary, secondary})
+	if top[0] != europe || top[1] != secondary {
+		t.Fatalf("expected the regional relay first and no excluded relay but got %+v", top)
+	}
+}
+
+func TestOrderRelays(t *testing.T) {
+	policy := newRelayPolicy(&config.Config{
+		RelayPoolSize:  1,
+		PinnedRelays:   config.StringValues{"pinned:41046", "other:41046"},
+		RelayRegion:    "as",
+		ExcludedRelays: config.StringValues{"excluded:41046"},
+	})
+	if policy.poolSize != 2 {
+		t.Fatalf("expected the pool to fit the pinned relays but got %d", policy.poolSize)
+	}
+	relays := policy.orderRelays([]*Relay{
+		{Host: "eu1.prenet.diode.io:41046"},
+		{Host: "excluded:41046"},
+		{Host: "as1.prenet.diode.io:41046"},
+	})
+	if len(relays) != 2 || relays[0].Host != "as1.prenet.diode.io:41046" {
+		t.Fatalf("expected the regional relay first and no excluded relay but got %+v", relays)
+	}
+	if host := policy.pickHost([]string{"us1.prenet.diode.io:41046", "as2.prenet.diode.io:41046"}); host != "as2.prenet.diode.io:41046" {
+		t.Fatalf("expected the regional host but got %s", host)
+	}
+}
@@ -0,0 +1,214 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/util"
+)
+
+const (
+	relayCacheKey  = "relays"
+	relayCacheSize = 64
+	// relays that were connected within relayMaxAge are known-good
+	relayMaxAge = 7 * 24 * time.Hour
+	// relays that failed relayMaxFailures times in a row are skipped for
+	// relayFailureTimeout
+	relayMaxFailures    = 3
+	relayFailureTimeout = time.Hour
+	// changes of the latency or the last seen time are only written every
+	// relaySaveInterval
+	relaySaveInterval = time.Hour
+)
+
+// Relay is a relay node that the client connected to or learned of from getnode
+type Relay struct {
+	Host        string        `json:"host"`
+	NodeID      string        `json:"node_id,omitempty"`
+	Latency     time.Duration `json:"latency,omitempty"`
+	LastSeen    time.Time     `json:"last_seen"`
+	Failures    int           `json:"failures,omitempty"`
+	LastFailure time.Time     `json:"last_failure"`
+	// QuarantinedUntil is the end of the cool-down after the circuit breaker
+	// of the relay tripped
+	QuarantinedUntil time.Time `json:"quarantined_until"`
+	QuarantineReason string    `json:"quarantine_reason,omitempty"`
+}
+
+// known returns true when the client was connected to the relay recently
+func (r *Relay) known(now time.Time) bool {
+	return !r.LastSeen.IsZero() && now.Sub(r.LastSeen) < relayMaxAge
+}
+
+// failing returns true when the last connection attempts all failed
+func (r *Relay) failing(now time.Time) bool {
+	return r.Failures >= relayMaxFailures && now.Sub(r.LastFailure) < relayFailureTimeout
+}
+
+// quarantined returns true during the cool-down of the circuit breaker
+func (r *Relay) quarantined(now time.Time) bool {
+	return r.QuarantinedUntil.After(now)
+}
+
+// relayCache keeps the relays in the database, it's only used within the
+// ClientManager genserver
+type relayCache struct {
+	relays map[string]*Relay
+	// dirty is set when relays were added or their failure state changed,
+	// touched when only the latency or the last seen time changed
+	dirty   bool
+	touched bool
+	saved   time.Time
+}
+
+func loadRelayCache() *relayCache {
+	rc := &relayCache{relays: make(map[string]*Relay), saved: time.Now()}
+	if db.DB == nil {
+		return rc
+	}
+	data, err := db.DB.Get(relayCacheKey)
+	if err != nil {
+		return rc
+	}
+	var relays []*Relay
+	if err = json.Unmarshal(data, &relays); err != nil {
+		return rc
+	}
+	for _, r := range relays {
+		rc.relays[r.Host] = r
+	}
+	return rc
+}
+
+func (rc *relayCache) relay(host string) *Relay {
+	r := rc.relays[host]
+	if r == nil {
+		r = &Relay{Host: host}
+		rc.relays[host] = r
+		rc.dirty = true
+	}
+	return r
+}
+
+// learned adds a relay that was returned by getnode
+func (rc *relayCache) learned(host string, nodeID util.Address) {
+	id := nodeID.HexString()
+	if r := rc.relays[host]; r != nil && r.NodeID == id {
+		return
+	}
+	rc.relay(host).NodeID = id
+	rc.dirty = true
+}
+
+// seen updates a relay the client is connected to
+func (rc *relayCache) seen(host string, nodeID util.Address, latency time.Duration) {
+	r := rc.relay(host)
+	id := nodeID.HexString()
+	if r.NodeID != id || r.Failures > 0 {
+		rc.dirty = true
+	} else {
+		rc.touched = true
+	}
+	r.NodeID = id
+	r.LastSeen = time.Now()
+	r.Failures = 0
+	if latency > 0 {
+		r.Latency = latency
+	}
+}
+
+// failed counts a connection attempt that didn't succeed
+func (rc *relayCache) failed(host string) {
+	r := rc.relay(host)
+	r.Failures++
+	r.LastFailure = time.Now()
+	rc.dirty = true
+}
+
+// quarantine skips the relay until the cool-down has passed
+func (rc *relayCache) quarantine(host string, until time.Time, reason string) {
+	r := rc.relay(host)
+	r.QuarantinedUntil = until
+	r.QuarantineReason = reason
+	rc.dirty = true
+}
+
+// candidates returns the relays that are not failing, the known-good relays
+// sorted by latency first and the other relays in random order after them
+func (rc *relayCache) candidates(now time.Time) (good []*Relay, other []*Relay) {
+	for _, r := range rc.relays {
+		if r.failing(now) || r.quarantined(now) {
+			continue
+		}
+		if r.known(now) {
+			good = append(good, r)
+		} else {
+			other = append(other, r)
+		}
+	}
+	sort.Slice(good, func(i, j int) bool {
+		if good[i].Latency == good[j].Latency {
+			return good[i].LastSeen.After(good[j].LastSeen)
+		}
+		return good[i].Latency < good[j].Latency
+	})
+	rand.Shuffle(len(other), func(i, j int) { other[i], other[j] = other[j], other[i] })
+	return
+}
+
+// isFailing returns true when connections to the host failed recently or the
+// host is quarantined
+func (rc *relayCache) isFailing(host string, now time.Time) bool {
+	r := rc.relays[host]
+	return r != nil && (r.failing(now) || r.quarantined(now))
+}
+
+// list returns all relays, the most recently seen first
+func (rc *relayCache) list() []*Relay {
+	relays := make([]*Relay, 0, len(rc.relays))
+	for _, r := range rc.relays {
+		relays = append(relays, r)
+	}
+	sort.Slice(relays, func(i, j int) bool {
+		return relays[i].LastSeen.After(relays[j].LastSeen)
+	})
+	return relays
+}
+
+// update writes the relays when relays were added or their failure state
+// changed, other changes are written every relaySaveInterval
+func (rc *relayCache) update(now time.Time) error {
+	if rc.dirty || (rc.touched && now.Sub(rc.saved) >= relaySaveInterval) {
+		return rc.save()
+	}
+	return nil
+}
+
+// save writes the relays to the database when they changed, only the
+// relayCacheSize most recently seen relays are kept
+func (rc *relayCache) save() error {
+	if !(rc.dirty || rc.touched) || db.DB == nil {
+		return nil
+	}
+	relays := rc.list()
+	if len(relays) > relayCacheSize {
+		for _, r := range relays[relayCacheSize:] {
+			delete(rc.relays, r.Host)
+		}
+		relays = relays[:relayCacheSize]
+	}
+	data, err := json.Marshal(relays)
+	if err != nil {
+		return err
+	}
+	rc.dirty = false
+	rc.touched = false
+	rc.saved = time.Now()
+	return db.DB.Put(relayCacheKey, data)
+}
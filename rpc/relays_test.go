@@ -0,0 +1,97 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/util"
+)
+
+func TestRelayCache(t *testing.T) {
+	withTestDB(t)
+
+	rc := loadRelayCache()
+	slow, fast, learned, broken := util.Address{1}, util.Address{2}, util.Address{3}, util.Address{4}
+	rc.seen("slow:41046", slow, 300*time.Millisecond)
+	rc.seen("fast:41046", fast, 20*time.Millisecond)
+	rc.learned("learned:41046", learned)
+	rc.seen("broken:41046", broken, 10*time.Millisecond)
+	for i := 0; i < relayMaxFailures; i++ {
+		rc.failed("broken:41046")
+	}
+	if err := rc.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new client manager starts with the relays of the last run
+	rc = loadRelayCache()
+	good, other := rc.candidates(time.Now())
+	if len(good) != 2 || good[0].Host != "fast:41046" || good[1].Host != "slow:41046" {
+		t.Fatalf("expected the known-good relays sorted by latency but got %+v", good)
+	}
+	if good[0].Latency != 20*time.Millisecond || good[0].NodeID != fast.HexString() {
+		t.Fatalf("relay wasn't restored: %+v", good[0])
+	}
+	if len(other) != 1 || other[0].Host != "learned:41046" {
+		t.Fatalf("expected the learned relay but got %+v", other)
+	}
+	if !rc.isFailing("broken:41046", time.Now()) {
+		t.Fatalf("expected the broken relay to be skipped")
+	}
+	if rc.isFailing("broken:41046", time.Now().Add(relayFailureTimeout)) {
+		t.Fatalf("expected the broken relay to be retried after the timeout")
+	}
+
+	// relays that weren't seen for a long time are not known-good anymore
+	good, other = rc.candidates(time.Now().Add(relayMaxAge))
+	if len(good) != 0 || len(other) != 4 {
+		t.Fatalf("expected all relays to be outdated but got %+v %+v", good, other)
+	}
+}
+
+func TestRelayCacheUpdate(t *testing.T) {
+	withTestDB(t)
+	latency := func() time.Duration {
+		for _, r := range loadRelayCache().relays {
+			return r.Latency
+		}
+		return 0
+	}
+
+	rc := loadRelayCache()
+	node := util.Address{1}
+	rc.seen("relay:41046", node, 20*time.Millisecond)
+	if err := rc.update(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if latency() != 20*time.Millisecond {
+		t.Fatalf("expected the new relay to be written")
+	}
+
+	// latency changes are only written every relaySaveInterval
+	rc.seen("relay:41046", node, 30*time.Millisecond)
+	if err := rc.update(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if latency() != 20*time.Millisecond {
+		t.Fatalf("expected the latency change to be delayed")
+	}
+	if err := rc.update(time.Now().Add(relaySaveInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if latency() != 30*time.Millisecond {
+		t.Fatalf("expected the latency change to be written after the interval")
+	}
+
+	// failures are written right away
+	rc.failed("relay:41046")
+	if err := rc.update(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if r := loadRelayCache().relays["relay:41046"]; r == nil || r.Failures != 1 {
+		t.Fatalf("expected the failure to be written but got %+v", r)
+	}
+}
@@ -0,0 +1,177 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package relaytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/crypto/secp256k1"
+	"github.com/diodechain/diode_client/util"
+	bert "github.com/diodechain/gobert"
+)
+
+const (
+	// ChainLength is the number of blocks that a new chain starts with
+	ChainLength = 200
+	// checkpointDistance is how far the checkpoint is behind the peak, the
+	// clients validate the blocks after the checkpoint with blockquick
+	checkpointDistance = 50
+)
+
+// block is a mined block header with the fields that are sent in
+// getblockheader2 responses
+type block struct {
+	header    blockquick.BlockHeader
+	hash      crypto.Sha3
+	txHash    []byte
+	stateHash []byte
+	prevBlock []byte
+	minerSig  []byte
+	timestamp uint64
+	number    uint64
+	nonce     uint64
+}
+
+// Chain is a synthetic block chain that is signed by a single miner, so the
+// miner owns all of the blockquick window
+type Chain struct {
+	mx          sync.RWMutex
+	miner       crypto.Signer
+	minerPubkey []byte
+	blocks      []*block
+}
+
+// NewChain returns a chain of ChainLength blocks mined by a new miner key
+func NewChain() (*Chain, error) {
+	minerKey, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	chain := &Chain{
+		miner:       crypto.NewKeySigner(minerKey),
+		minerPubkey: crypto.MarshalPubkey(&minerKey.PublicKey),
+	}
+	if err = chain.Mine(ChainLength); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Mine appends n blocks to the chain
+func (chain *Chain) Mine(n int) error {
+	chain.mx.Lock()
+	defer chain.mx.Unlock()
+	for i := 0; i < n; i++ {
+		b := &block{
+			txHash:    util.EmptyBytes(32),
+			stateHash: make([]byte, 32),
+			prevBlock: util.EmptyBytes(32),
+			timestamp: uint64(time.Now().Unix()),
+			number:    uint64(len(chain.blocks)),
+		}
+		if _, err := rand.Read(b.stateHash); err != nil {
+			return err
+		}
+		if len(chain.blocks) > 0 {
+			parent := chain.blocks[len(chain.blocks)-1]
+			b.prevBlock = parent.hash[:]
+		}
+		if err := chain.sign(b); err != nil {
+			return err
+		}
+		chain.blocks = append(chain.blocks, b)
+	}
+	return nil
+}
+
+// sign adds the miner signature to the block, the hash is computed like in
+// blockquick.BlockHeader.HashWithoutSig
+func (chain *Chain) sign(b *block) (err error) {
+	encHeader, err := bert.Encode([6]bert.Term{
+		b.prevBlock,
+		b.stateHash,
+		b.txHash,
+		b.timestamp,
+		b.number,
+		b.nonce})
+	if err != nil {
+		return
+	}
+	b.minerSig, err = chain.miner.Sign(crypto.Sha256(encHeader))
+	if err != nil {
+		return
+	}
+	b.header, err = blockquick.NewHeader(b.txHash, b.stateHash, b.prevBlock, b.minerSig, chain.minerPubkey, b.timestamp, b.number, b.nonce)
+	if err != nil {
+		return fmt.Errorf("failed to mine block %d: %v", b.number, err)
+	}
+	b.hash = b.header.Hash()
+	return
+}
+
+// Peak returns the number of the latest block
+func (chain *Chain) Peak() uint64 {
+	chain.mx.RLock()
+	defer chain.mx.RUnlock()
+	return uint64(len(chain.blocks) - 1)
+}
+
+// Header returns the block header of the given number
+func (chain *Chain) Header(num uint64) (bh blockquick.BlockHeader, ok bool) {
+	b := chain.block(num)
+	if b == nil {
+		return
+	}
+	return b.header, true
+}
+
+// Checkpoint returns a trusted block that clients can start the blockquick
+// validation from, see rpc.SetLastValid
+func (chain *Chain) Checkpoint() (uint64, crypto.Sha3) {
+	chain.mx.RLock()
+	defer chain.mx.RUnlock()
+	b := chain.blocks[len(chain.blocks)-1-checkpointDistance]
+	return b.number, b.hash
+}
+
+func (chain *Chain) block(num uint64) *block {
+	chain.mx.RLock()
+	defer chain.mx.RUnlock()
+	if num >= uint64(len(chain.blocks)) {
+		return nil
+	}
+	return chain.blocks[num]
+}
+
+// headerPayload returns the getblockheader2 response of the block
+func (chain *Chain) headerPayload(b *block) []interface{} {
+	item := func(key string, value []byte) []interface{} {
+		return []interface{}{key, value}
+	}
+	return []interface{}{
+		"response",
+		[]interface{}{
+			item("transaction_hash", b.txHash),
+			item("state_hash", b.stateHash),
+			item("block_hash", b.hash[:]),
+			item("previous_block", b.prevBlock),
+			item("nonce", util.DecodeUintToBytes(b.nonce)),
+			item("miner_signature", b.minerSig),
+			item("timestamp", util.DecodeUintToBytes(b.timestamp)),
+			item("number", util.DecodeUintToBytes(b.number)),
+		},
+		secp256k1.CompressPubkeyBytes(chain.minerPubkey),
+	}
+}
+
+// newKey returns a new secp256k1 key
+func newKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+}
@@ -0,0 +1,152 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package relaytest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/rlp"
+	"github.com/diodechain/diode_client/util"
+	"github.com/diodechain/openssl"
+)
+
+// firstRequestID is the first id of the requests of the relay, the clients
+// keep their responses in the same call table as their own requests, so the
+// ids of both sides must not collide
+const firstRequestID = 1 << 48
+
+// message is the rlp encoding of requests and responses in both directions
+type message struct {
+	RequestID uint64
+	Payload   []interface{}
+}
+
+// conn is the connection of a client to the relay
+type conn struct {
+	relay  *Relay
+	ssl    *openssl.Conn
+	device util.Address
+	wmx    sync.Mutex
+	// blocks is set when the client negotiated the blocks capability
+	blocks int32
+	cd     sync.Once
+
+	// requests of the relay that wait for a response of the client
+	mx            sync.Mutex
+	lastRequestID uint64
+	pending       map[uint64]chan []interface{}
+}
+
+// readLoop handles the messages of the client until the connection is closed
+func (c *conn) readLoop() {
+	for {
+		buf, err := c.read()
+		if err != nil {
+			return
+		}
+		var msg message
+		if err = rlp.DecodeBytes(buf, &msg); err != nil || len(msg.Payload) == 0 {
+			continue
+		}
+		method := string(argBytes(msg.Payload, 0))
+		if method == "response" || method == "error" {
+			c.mx.Lock()
+			ch := c.pending[msg.RequestID]
+			delete(c.pending, msg.RequestID)
+			c.mx.Unlock()
+			if ch != nil {
+				ch <- msg.Payload
+			}
+			continue
+		}
+		c.relay.handle(c, msg.RequestID, method, msg.Payload[1:])
+	}
+}
+
+// read returns the next length prefixed message
+func (c *conn) read() ([]byte, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(c.ssl, lenBuf); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(c.ssl, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// send writes a length prefixed message
+func (c *conn) send(requestID uint64, payload ...interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := rlp.Encode(buf, message{RequestID: requestID, Payload: payload}); err != nil {
+		return err
+	}
+	if buf.Len() > 0xffff {
+		return fmt.Errorf("message too long: %d bytes", buf.Len())
+	}
+	frame := make([]byte, 2, 2+buf.Len())
+	binary.BigEndian.PutUint16(frame, uint16(buf.Len()))
+	frame = append(frame, buf.Bytes()...)
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+	_, err := c.ssl.Write(frame)
+	return err
+}
+
+// respond sends the response to a request of the client
+func (c *conn) respond(requestID uint64, payload ...interface{}) {
+	c.send(requestID, payload...)
+}
+
+// respondError sends an error response to a request of the client
+func (c *conn) respondError(requestID uint64, method string, reason string) {
+	c.send(requestID, "error", method, reason)
+}
+
+// request sends a request to the client without waiting for the response
+func (c *conn) request(method string, args ...interface{}) {
+	c.send(c.nextRequestID(), append([]interface{}{method}, args...)...)
+}
+
+// call sends a request to the client and returns the payload of the response
+func (c *conn) call(timeout time.Duration, method string, args ...interface{}) ([]interface{}, error) {
+	requestID := c.nextRequestID()
+	ch := make(chan []interface{}, 1)
+	c.mx.Lock()
+	c.pending[requestID] = ch
+	c.mx.Unlock()
+	defer func() {
+		c.mx.Lock()
+		delete(c.pending, requestID)
+		c.mx.Unlock()
+	}()
+	if err := c.send(requestID, append([]interface{}{method}, args...)...); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%s timeout", method)
+	}
+}
+
+func (c *conn) nextRequestID() uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.lastRequestID++
+	return c.lastRequestID
+}
+
+func (c *conn) close() {
+	c.cd.Do(func() {
+		c.ssl.Close()
+	})
+}
@@ -0,0 +1,77 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package relaytest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitTimeout is how long WaitFor and ExpectEcho wait
+const waitTimeout = 10 * time.Second
+
+// Echo starts a tcp service on localhost that returns everything it
+// receives and returns its port, the service stops with the test
+func Echo(t testing.TB) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// FreePort returns a port of localhost that nothing listens on
+func FreePort(t testing.TB) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// ExpectEcho sends data through the connection to an Echo service and waits
+// for it to return
+func ExpectEcho(t testing.TB, conn net.Conn, data string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(waitTimeout))
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Fatalf("expected %q but got %q", data, buf)
+	}
+}
+
+// WaitFor polls the condition until it's true and fails the test after ten
+// seconds
+func WaitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
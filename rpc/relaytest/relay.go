@@ -0,0 +1,577 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+
+// Package relaytest implements an in-process relay for integration tests. It
+// speaks the server side of the edge protocol over TLS: hello, ticket,
+// getobject, getnode, portopen, portsend and portclose, and serves a
+// synthetic signed chain for the blockquick validation.
+//
+// The hello negotiates the batch and blocks capabilities, Legacy makes the
+// relay behave like a relay that predates the negotiation. Mine announces the
+// new blocks to the clients that negotiated the blocks capability.
+//
+// Echo, FreePort, ExpectEcho and WaitFor are shared by the tests that
+// tunnel through relays.
+package relaytest
+
+import (
+	"crypto/ecdsa"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/edge"
+	"github.com/diodechain/diode_client/util"
+	bert "github.com/diodechain/gobert"
+	"github.com/diodechain/openssl"
+)
+
+// portOpenTimeout is how long the relay waits for the device to accept a port
+const portOpenTimeout = 10 * time.Second
+
+// Relay is a relay that listens on localhost, clients connect to it with
+// Addr() as their only diodeaddrs entry
+type Relay struct {
+	// Chain is the block chain that is served to the clients, relays share
+	// a chain when it's set before clients connect
+	Chain *Chain
+	// Legacy rejects versioned hellos and the getblockheaders batch, it must
+	// be set before clients connect
+	Legacy bool
+
+	signer   crypto.Signer
+	id       util.Address
+	ctx      *openssl.Ctx
+	listener net.Listener
+
+	mx      sync.Mutex
+	conns   []*conn
+	tickets map[util.Address]*edge.DeviceTicket
+	ports   map[string]*port
+	lastRef uint64
+	closeCh chan struct{}
+	cd      sync.Once
+	// silent is set when the relay stops answering requests
+	silent int32
+}
+
+// port is an open port between two connections, both ends use the same ref
+type port struct {
+	ref    string
+	source *conn
+	target *conn
+}
+
+// other returns the end of the port that c sends to
+func (p *port) other(c *conn) *conn {
+	if p.source == c {
+		return p.target
+	}
+	return p.source
+}
+
+// New starts a relay with a new key and chain on a random port of localhost
+func New() (*Relay, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	chain, err := NewChain()
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := newSSLCtx(key)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	relay := &Relay{
+		Chain:    chain,
+		signer:   crypto.NewKeySigner(key),
+		id:       util.PubkeyToAddress(crypto.MarshalPubkey(&key.PublicKey)),
+		ctx:      ctx,
+		listener: listener,
+		tickets:  make(map[util.Address]*edge.DeviceTicket),
+		ports:    make(map[string]*port),
+		closeCh:  make(chan struct{}),
+	}
+	go relay.acceptLoop()
+	return relay, nil
+}
+
+// Addr returns the host:port that the relay listens on
+func (relay *Relay) Addr() string {
+	return relay.listener.Addr().String()
+}
+
+// ID returns the node id of the relay
+func (relay *Relay) ID() util.Address {
+	return relay.id
+}
+
+// Ticket returns the last ticket that the device submitted, or nil
+func (relay *Relay) Ticket(device util.Address) *edge.DeviceTicket {
+	relay.mx.Lock()
+	defer relay.mx.Unlock()
+	return relay.tickets[device]
+}
+
+// OpenPorts returns the number of ports that are open through the relay
+func (relay *Relay) OpenPorts() int {
+	relay.mx.Lock()
+	defer relay.mx.Unlock()
+	return len(relay.ports)
+}
+
+// Mine adds n blocks to the chain and announces the new peak to the clients
+// that negotiated the blocks capability
+func (relay *Relay) Mine(n int) error {
+	if err := relay.Chain.Mine(n); err != nil {
+		return err
+	}
+	peak := relay.Chain.Peak()
+	relay.mx.Lock()
+	conns := append([]*conn{}, relay.conns...)
+	relay.mx.Unlock()
+	for _, c := range conns {
+		if atomic.LoadInt32(&c.blocks) == 1 {
+			c.request("newblock", peak)
+		}
+	}
+	return nil
+}
+
+// Close stops the relay and disconnects all clients
+func (relay *Relay) Close() {
+	relay.cd.Do(func() {
+		close(relay.closeCh)
+		relay.listener.Close()
+		relay.mx.Lock()
+		conns := relay.conns
+		relay.mx.Unlock()
+		for _, c := range conns {
+			c.close()
+		}
+	})
+}
+
+func (relay *Relay) closed() bool {
+	select {
+	case <-relay.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (relay *Relay) acceptLoop() {
+	for {
+		tcpConn, err := relay.listener.Accept()
+		if err != nil {
+			if relay.closed() {
+				return
+			}
+			continue
+		}
+		go relay.serve(tcpConn)
+	}
+}
+
+// serve runs the handshake and reads the messages of the client
+func (relay *Relay) serve(tcpConn net.Conn) {
+	ssl, err := openssl.Server(tcpConn, relay.ctx)
+	if err != nil {
+		tcpConn.Close()
+		return
+	}
+	if err = ssl.Handshake(); err != nil {
+		ssl.Close()
+		return
+	}
+	device, err := peerAddress(ssl)
+	if err != nil {
+		ssl.Close()
+		return
+	}
+	c := &conn{
+		relay:         relay,
+		ssl:           ssl,
+		device:        device,
+		pending:       make(map[uint64]chan []interface{}),
+		lastRequestID: firstRequestID,
+	}
+	relay.mx.Lock()
+	if relay.closed() {
+		relay.mx.Unlock()
+		ssl.Close()
+		return
+	}
+	relay.conns = append(relay.conns, c)
+	relay.mx.Unlock()
+
+	c.readLoop()
+	relay.disconnect(c)
+}
+
+// disconnect removes the connection and closes its ports on the other end
+func (relay *Relay) disconnect(c *conn) {
+	c.close()
+	var others []*port
+	relay.mx.Lock()
+	for i, other := range relay.conns {
+		if other == c {
+			relay.conns = append(relay.conns[:i], relay.conns[i+1:]...)
+			break
+		}
+	}
+	for ref, p := range relay.ports {
+		if p.source == c || p.target == c {
+			delete(relay.ports, ref)
+			others = append(others, p)
+		}
+	}
+	relay.mx.Unlock()
+	for _, p := range others {
+		p.other(c).request("portclose", p.ref)
+	}
+}
+
+// deviceConn returns the newest connection of the device
+func (relay *Relay) deviceConn(device util.Address) *conn {
+	relay.mx.Lock()
+	defer relay.mx.Unlock()
+	for i := len(relay.conns) - 1; i >= 0; i-- {
+		if c := relay.conns[i]; c.device == device {
+			return c
+		}
+	}
+	return nil
+}
+
+// Silence stops answering requests other than the hello, like a relay that
+// hangs while the connection stays open
+func (relay *Relay) Silence(silent bool) {
+	var value int32
+	if silent {
+		value = 1
+	}
+	atomic.StoreInt32(&relay.silent, value)
+}
+
+// handle answers a request of the client c
+func (relay *Relay) handle(c *conn, requestID uint64, method string, args []interface{}) {
+	if method != "hello" && atomic.LoadInt32(&relay.silent) == 1 {
+		return
+	}
+	switch method {
+	case "hello":
+		relay.handleHello(c, requestID, args)
+	case "getblockpeak":
+		c.respond(requestID, "response", relay.Chain.Peak())
+	case "getblockheader2":
+		b := relay.Chain.block(argUint(args, 0))
+		if b == nil {
+			c.respondError(requestID, method, "block not found")
+			return
+		}
+		c.respond(requestID, relay.Chain.headerPayload(b)...)
+	case "getblockheaders":
+		if relay.Legacy {
+			c.respondError(requestID, method, "not implemented")
+			return
+		}
+		payload := []interface{}{"response"}
+		for i := range args {
+			b := relay.Chain.block(argUint(args, i))
+			if b == nil {
+				c.respondError(requestID, method, "block not found")
+				return
+			}
+			payload = append(payload, relay.Chain.headerPayload(b)[1:])
+		}
+		c.respond(requestID, payload...)
+	case "getblockquick2":
+		// consecutive blocks after the last valid block, up to the peak
+		lastValid, count := argUint(args, 0), argUint(args, 1)
+		sequence := []uint64{}
+		for num := lastValid + 1; num <= relay.Chain.Peak() && num <= lastValid+count; num++ {
+			sequence = append(sequence, num)
+		}
+		c.respond(requestID, "response", sequence)
+	case "ticket":
+		relay.handleTicket(c, requestID, args)
+	case "getobject":
+		var device util.Address
+		copy(device[:], argBytes(args, 0))
+		ticket := relay.Ticket(device)
+		if ticket == nil {
+			c.respondError(requestID, method, "not found")
+			return
+		}
+		c.respond(requestID, "response", []interface{}{
+			"location",
+			ticket.ServerID[:],
+			ticket.BlockNumber,
+			ticket.FleetAddr[:],
+			ticket.TotalConnections,
+			ticket.TotalBytes,
+			ticket.LocalAddr,
+			ticket.DeviceSig,
+			ticket.ServerSig,
+		})
+	case "getnode":
+		relay.handleGetNode(c, requestID, args)
+	case "portopen":
+		// waiting for the device must not block the messages of c
+		go relay.handlePortOpen(c, requestID, args)
+	case "portsend":
+		ref := string(argBytes(args, 0))
+		target := relay.portTarget(c, ref)
+		if target == nil {
+			c.respondError(requestID, method, "port does not exist")
+			return
+		}
+		target.request("portsend", ref, argBytes(args, 1))
+		c.respond(requestID, "response", "ok")
+	case "portclose":
+		ref := string(argBytes(args, 0))
+		target := relay.portTarget(c, ref)
+		if target == nil {
+			c.respondError(requestID, method, "port does not exist")
+			return
+		}
+		relay.mx.Lock()
+		delete(relay.ports, ref)
+		relay.mx.Unlock()
+		target.request("portclose", ref)
+		c.respond(requestID, "response", "ok")
+	default:
+		c.respondError(requestID, method, "not implemented")
+	}
+}
+
+// handleHello answers with the version and the capabilities that the relay
+// shares with the client
+func (relay *Relay) handleHello(c *conn, requestID uint64, args []interface{}) {
+	version := argUint(args, 0)
+	if version == edge.LegacyProtocolVersion {
+		c.respond(requestID, "response", "ok")
+		return
+	}
+	if relay.Legacy || version < edge.ProtocolVersion {
+		c.respondError(requestID, "hello", "version not supported")
+		return
+	}
+	payload := []interface{}{"response", "ok", edge.ProtocolVersion}
+	for i := 1; i < len(args); i++ {
+		switch capability := string(argBytes(args, i)); capability {
+		case edge.CapabilityBatch:
+			payload = append(payload, capability)
+		case edge.CapabilityBlocks:
+			atomic.StoreInt32(&c.blocks, 1)
+			payload = append(payload, capability)
+		}
+	}
+	c.respond(requestID, payload...)
+}
+
+// handleTicket validates the device signature and countersigns the ticket
+func (relay *Relay) handleTicket(c *conn, requestID uint64, args []interface{}) {
+	blockNumber := argUint(args, 0)
+	b := relay.Chain.block(blockNumber)
+	if b == nil {
+		c.respondError(requestID, "ticket", "block not found")
+		return
+	}
+	ticket := &edge.DeviceTicket{
+		ServerID:         relay.id,
+		BlockNumber:      blockNumber,
+		BlockHash:        b.hash[:],
+		TotalConnections: argUint(args, 2),
+		TotalBytes:       argUint(args, 3),
+		LocalAddr:        argBytes(args, 4),
+		DeviceSig:        argBytes(args, 5),
+	}
+	copy(ticket.FleetAddr[:], argBytes(args, 1))
+	if !ticket.ValidateDeviceSig(c.device) {
+		c.respondError(requestID, "ticket", "wrong signature")
+		return
+	}
+	hash, err := ticket.Hash()
+	if err == nil {
+		ticket.ServerSig, err = relay.signer.Sign(hash)
+	}
+	if err != nil {
+		c.respondError(requestID, "ticket", err.Error())
+		return
+	}
+	relay.mx.Lock()
+	relay.tickets[c.device] = ticket
+	relay.mx.Unlock()
+	c.respond(requestID, "response", "thanks!", util.DecodeUintToBytes(ticket.TotalBytes))
+}
+
+// handleGetNode returns the signed server object of the relay
+func (relay *Relay) handleGetNode(c *conn, requestID uint64, args []interface{}) {
+	var nodeID util.Address
+	copy(nodeID[:], argBytes(args, 0))
+	if nodeID != relay.id {
+		c.respondError(requestID, "getnode", "not found")
+		return
+	}
+	host, portStr, _ := net.SplitHostPort(relay.Addr())
+	edgePort, _ := strconv.ParseUint(portStr, 10, 64)
+	serverPort := uint64(0)
+	data, err := bert.Encode([3]bert.Term{[]byte(host), edgePort, serverPort})
+	if err != nil {
+		c.respondError(requestID, "getnode", err.Error())
+		return
+	}
+	sig, err := relay.signer.Sign(crypto.Sha256(data))
+	if err != nil {
+		c.respondError(requestID, "getnode", err.Error())
+		return
+	}
+	c.respond(requestID, "response", []interface{}{"server", []byte(host), edgePort, serverPort, sig})
+}
+
+// handlePortOpen asks the device to accept the port and returns the ref of
+// the port to the client c
+func (relay *Relay) handlePortOpen(c *conn, requestID uint64, args []interface{}) {
+	var device util.Address
+	copy(device[:], argBytes(args, 0))
+	portName := string(argBytes(args, 1))
+	target := relay.deviceConn(device)
+	if target == nil {
+		c.respondError(requestID, "portopen", "not found")
+		return
+	}
+
+	relay.mx.Lock()
+	relay.lastRef++
+	ref := fmt.Sprintf("%08x", relay.lastRef)
+	relay.mx.Unlock()
+
+	resp, err := target.call(portOpenTimeout, "portopen", portName, ref, c.device[:])
+	if err != nil {
+		c.respondError(requestID, "portopen", err.Error())
+		return
+	}
+	if len(resp) == 0 || string(argBytes(resp, 0)) != "response" {
+		reason := "port open failed"
+		if len(resp) > 0 {
+			reason = string(argBytes(resp, len(resp)-1))
+		}
+		c.respondError(requestID, "portopen", reason)
+		return
+	}
+	relay.mx.Lock()
+	relay.ports[ref] = &port{ref: ref, source: c, target: target}
+	relay.mx.Unlock()
+	c.respond(requestID, "response", "ok", ref)
+}
+
+// portTarget returns the other end of the port of c
+func (relay *Relay) portTarget(c *conn, ref string) *conn {
+	relay.mx.Lock()
+	defer relay.mx.Unlock()
+	p := relay.ports[ref]
+	if p == nil || (p.source != c && p.target != c) {
+		return nil
+	}
+	return p.other(c)
+}
+
+// peerAddress returns the address of the client certificate
+func peerAddress(ssl *openssl.Conn) (util.Address, error) {
+	cert, err := ssl.PeerCertificate()
+	if err != nil {
+		return util.Address{}, err
+	}
+	pubKey, err := cert.PublicKey()
+	if err != nil {
+		return util.Address{}, err
+	}
+	der, err := pubKey.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return util.Address{}, err
+	}
+	rawPubKey, err := crypto.DerToPublicKey(der)
+	if err != nil {
+		return util.Address{}, err
+	}
+	return util.PubkeyToAddress(rawPubKey), nil
+}
+
+// newSSLCtx returns a server context with a self-signed certificate of the
+// relay key that requests the certificate of the clients
+func newSSLCtx(key *ecdsa.PrivateKey) (*openssl.Ctx, error) {
+	der, err := crypto.ECDSAToDer(key)
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := openssl.LoadPrivateKeyFromPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+	info := &openssl.CertificateInfo{
+		Serial:       big.NewInt(1),
+		Issued:       -24 * time.Hour,
+		Expires:      24 * time.Hour,
+		Country:      "US",
+		Organization: "Private",
+		CommonName:   "relaytest",
+	}
+	cert, err := openssl.NewCertificate(info, privKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = cert.Sign(privKey, openssl.EVP_SHA256); err != nil {
+		return nil, err
+	}
+	ctx, err := openssl.NewCtxWithVersion(openssl.TLSv1_2)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.UseCertificate(cert); err != nil {
+		return nil, err
+	}
+	if err = ctx.UsePrivateKey(privKey); err != nil {
+		return nil, err
+	}
+	// the clients use self-signed certificates
+	ctx.SetVerify(openssl.VerifyFailIfNoPeerCert|openssl.VerifyPeer, func(ok bool, store *openssl.CertificateStoreCtx) bool {
+		return true
+	})
+	if err = ctx.SetEllipticCurve(openssl.Secp256k1); err != nil {
+		return nil, err
+	}
+	if err = ctx.SetSupportedEllipticCurves([]openssl.EllipticCurve{openssl.Secp256k1}); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// argBytes returns the i-th argument of a decoded request
+func argBytes(args []interface{}, i int) []byte {
+	if i >= len(args) {
+		return nil
+	}
+	b, _ := args[i].([]byte)
+	return b
+}
+
+// argUint returns the i-th argument of a decoded request as number
+func argUint(args []interface{}, i int) uint64 {
+	return util.DecodeBytesToUint(argBytes(args, i))
+}
@@ -0,0 +1,249 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package relaytest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/edge"
+	"github.com/diodechain/diode_client/rpc"
+	"github.com/diodechain/diode_client/rpc/relaytest"
+	"github.com/diodechain/diode_client/util"
+)
+
+func testConfig(t *testing.T, relay *relaytest.Relay) *config.Config {
+	dir, err := ioutil.TempDir("", "diode_relaytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		DBPath:           filepath.Join(dir, "private.db"),
+		RetryTimes:       3,
+		EdgeE2ETimeout:   6 * time.Second,
+		RemoteRPCTimeout: 5 * time.Second,
+		RetryWait:        time.Second,
+		RemoteRPCAddrs:   config.StringValues{relay.Addr()},
+		RelayPoolSize:    1,
+		FleetAddr:        config.DefaultFleetAddr,
+		LogMode:          config.LogToConsole,
+	}
+	l, _ := config.NewLogger(cfg)
+	cfg.Logger = &l
+	config.AppConfig = cfg
+
+	clidb, err := db.OpenFile(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = clidb
+	t.Cleanup(func() {
+		clidb.Close()
+		os.RemoveAll(dir)
+	})
+	cfg.ClientAddr = util.PubkeyToAddress(rpc.LoadClientPubKey())
+	rpc.SetLastValid(relay.Chain.Checkpoint())
+	return cfg
+}
+
+// deviceConfig returns the config of another device that connects to the
+// relay, the device has its own identity and so its own key and state in the
+// database of the test
+func deviceConfig(t *testing.T, relay *relaytest.Relay, identity string) *config.Config {
+	cfg := *config.AppConfig
+	cfg.Identity = identity
+	appConfig := config.AppConfig
+	config.AppConfig = &cfg
+	defer func() { config.AppConfig = appConfig }()
+	cfg.ClientAddr = util.PubkeyToAddress(rpc.LoadClientPubKey())
+	rpc.SetLastValid(relay.Chain.Checkpoint())
+	return &cfg
+}
+
+// TestTunnel connects a publishing and a binding device to the relay
+func TestTunnel(t *testing.T) {
+	relay, err := relaytest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	cfg := testConfig(t, relay)
+
+	publisher := rpc.NewClientManager(cfg)
+	publisher.GetPool().SetPublishedPorts(map[int]*config.Port{
+		80: {
+			SrcHost:  "127.0.0.1",
+			Src:      relaytest.Echo(t),
+			To:       80,
+			Mode:     config.PublicPublishedMode,
+			Protocol: config.AnyProtocol,
+		},
+	})
+	publisher.Start()
+	defer publisher.Stop()
+	if client := publisher.GetNearestClient(); client == nil {
+		t.Fatalf("publisher didn't connect")
+	}
+
+	binderCfg := deviceConfig(t, relay, "binder")
+	if binderCfg.ClientAddr == cfg.ClientAddr {
+		t.Fatalf("expected the binder to have its own key")
+	}
+	binder := rpc.NewClientManager(binderCfg)
+	binder.Start()
+	defer binder.Stop()
+	if client := binder.GetNearestClient(); client == nil {
+		t.Fatalf("binder didn't connect")
+	}
+	socksServer, err := rpc.NewSocksServer(rpc.Config{}, binder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socksServer.Close()
+	localPort := relaytest.FreePort(t)
+	socksServer.SetBinds([]config.Bind{{
+		To:        cfg.ClientAddr.HexString(),
+		ToPort:    80,
+		LocalPort: localPort,
+		Protocol:  config.TCPProtocol,
+	}})
+
+	for _, device := range []util.Address{cfg.ClientAddr, binderCfg.ClientAddr} {
+		if relay.Ticket(device) == nil {
+			t.Fatalf("expected the relay to have a ticket of %s", device.HexString())
+		}
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(localPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, data := range []string{"hello", "through the relay"} {
+		relaytest.ExpectEcho(t, conn, data)
+	}
+	if relay.OpenPorts() != 1 {
+		t.Fatalf("expected one open port but got %d", relay.OpenPorts())
+	}
+
+	conn.Close()
+	relaytest.WaitFor(t, "the port to be closed", func() bool {
+		return relay.OpenPorts() == 0
+	})
+}
+
+// TestHello checks that the batch capability is only used with relays that
+// negotiated it and that legacy relays still validate
+func TestHello(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		relay, err := relaytest.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		relay.Legacy = legacy
+		cfg := testConfig(t, relay)
+
+		cm := rpc.NewClientManager(cfg)
+		cm.Start()
+		client := cm.GetNearestClient()
+		if client == nil {
+			t.Fatalf("client didn't connect to the relay (legacy: %v)", legacy)
+		}
+		if client.Supports(edge.CapabilityBatch) == legacy {
+			t.Fatalf("batch should only be negotiated with new relays (legacy: %v)", legacy)
+		}
+		peak := relay.Chain.Peak()
+		headers, err := client.GetBlockHeadersUnsafe(context.Background(), peak-10, peak)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, bh := range headers {
+			if expected, _ := relay.Chain.Header(peak - 10 + uint64(i)); bh.Hash() != expected.Hash() {
+				t.Fatalf("wrong header for block %d (legacy: %v)", bh.Number(), legacy)
+			}
+		}
+		cm.Stop()
+		relay.Close()
+	}
+}
+
+// TestSilentRelay checks that calls to a relay that stops answering time out
+// without a deadline of the caller and trip the circuit breaker
+func TestSilentRelay(t *testing.T) {
+	relay, err := relaytest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	cfg := testConfig(t, relay)
+	cfg.RemoteRPCTimeout = 200 * time.Millisecond
+
+	cm := rpc.NewClientManager(cfg)
+	cm.Start()
+	defer cm.Stop()
+	client := cm.GetNearestClient()
+	if client == nil {
+		t.Fatalf("client didn't connect to the relay")
+	}
+	relay.Silence(true)
+	for i := 0; i < 5; i++ {
+		_, err = client.GetBlockPeak(context.Background())
+		if _, ok := err.(rpc.TimeoutError); !ok {
+			t.Fatalf("expected a timeout but got %v", err)
+		}
+	}
+	relaytest.WaitFor(t, "the relay to be quarantined", func() bool {
+		scores := cm.RelayScores()
+		return len(scores) > 0 && scores[0].QuarantinedUntil.After(time.Now())
+	})
+}
+
+// TestBlockAnnounce checks that the client validates the blocks that the
+// relay announces without waiting for the next poll
+func TestBlockAnnounce(t *testing.T) {
+	relay, err := relaytest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	cfg := testConfig(t, relay)
+
+	cm := rpc.NewClientManager(cfg)
+	cm.Start()
+	defer cm.Stop()
+	client := cm.GetNearestClient()
+	if client == nil {
+		t.Fatalf("client didn't connect to the relay")
+	}
+	if !client.Supports(edge.CapabilityBlocks) {
+		t.Fatalf("blocks should be negotiated with the relay")
+	}
+	headers := make(chan blockquick.BlockHeader, 100)
+	client.SubscribeBlocks(headers)
+	defer client.UnsubscribeBlocks(headers)
+
+	peak := relay.Chain.Peak()
+	if err = relay.Mine(10); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case bh := <-headers:
+			if bh.Number() > peak {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no header after block %d was announced", peak)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/diodechain/diode_client/config"
@@ -30,8 +31,9 @@ func NewResolver(socksCfg Config, clientManager *ClientManager) (resolver *Resol
 	return
 }
 
-// ResolveDevice
-func (resolver *Resolver) ResolveDevice(deviceName string) (ret []*edge.DeviceTicket, err error) {
+// ResolveDevice returns the tickets of the devices of deviceName, the lookup
+// stops when ctx is done
+func (resolver *Resolver) ResolveDevice(ctx context.Context, deviceName string) (ret []*edge.DeviceTicket, err error) {
 	// Resolving BNS if needed
 	var deviceIDs []Address
 	client := resolver.clientManager.GetNearestClient()
@@ -39,7 +41,7 @@ func (resolver *Resolver) ResolveDevice(deviceName string) (ret []*edge.DeviceTi
 		return nil, HttpError{404, err}
 	}
 	if !util.IsHex([]byte(deviceName)) {
-		deviceIDs, err = client.GetCacheOrResolveBNS(deviceName)
+		deviceIDs, err = client.GetCacheOrResolveBNS(ctx, deviceName)
 		if err != nil {
 			return
 		}
@@ -55,18 +57,7 @@ func (resolver *Resolver) ResolveDevice(deviceName string) (ret []*edge.DeviceTi
 
 	deviceIDs = util.Filter(deviceIDs, func(addr Address) bool {
 		// Checking blocklist and allowlist
-		if len(resolver.Config.Blocklists) > 0 {
-			if resolver.Config.Blocklists[addr] {
-				return false
-			}
-		} else {
-			if len(resolver.Config.Allowlists) > 0 {
-				if !resolver.Config.Allowlists[addr] {
-					return false
-				}
-			}
-		}
-		return true
+		return resolver.Config.AccessLists.Check(addr) == nil
 	})
 
 	if len(deviceIDs) == 0 {
@@ -84,15 +75,18 @@ func (resolver *Resolver) ResolveDevice(deviceName string) (ret []*edge.DeviceTi
 			continue
 		}
 
-		device, err := client.GetObject(deviceID)
+		device, err := client.GetObject(ctx, deviceID)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
 			continue
 		}
 		if !client.isRecentTicket(device) {
 			continue
 		}
 
-		if device.BlockHash, err = client.ResolveBlockHash(device.BlockNumber); err != nil {
+		if device.BlockHash, err = client.ResolveBlockHash(ctx, device.BlockNumber); err != nil {
 			client.Log().Error("failed to resolve() %v", err)
 			continue
 		}
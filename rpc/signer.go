@@ -0,0 +1,134 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/pem"
+	"fmt"
+	"sync"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/crypto/secp256k1"
+	"github.com/diodechain/diode_client/util"
+)
+
+var (
+	// clientSigner replaces the keys of the identities in the database
+	clientSigner   crypto.Signer
+	clientSignerMx sync.Mutex
+	errNoTLSKey    = fmt.Errorf("signer does not provide the key for the tls connection")
+	errTLSKey      = fmt.Errorf("tls key does not match the signer")
+)
+
+// tlsKeySigner is implemented by signers that provide the private key for the
+// TLS connections, relays identify devices by the key of the TLS certificate
+// and the openssl handshake is signed in process
+type tlsKeySigner interface {
+	TLSPrivateKeyPEM() ([]byte, error)
+}
+
+// SetSigner replaces the signer of the client identity, by default and after
+// SetSigner(nil) the private key is read from the database
+func SetSigner(signer crypto.Signer) {
+	clientSignerMx.Lock()
+	defer clientSignerMx.Unlock()
+	clientSigner = signer
+}
+
+// ClientSigner returns the signer of the client identity
+func ClientSigner() crypto.Signer {
+	return signerOf(config.AppConfig)
+}
+
+// signerOf returns the signer of the identity of the config
+func signerOf(cfg *config.Config) crypto.Signer {
+	clientSignerMx.Lock()
+	defer clientSignerMx.Unlock()
+	if clientSigner != nil {
+		return clientSigner
+	}
+	return dbSigner{identity: configIdentity(cfg), key: privateKey}
+}
+
+// TimeSigner returns the signer of the served consensus time, it has a key of
+// its own so that requests can't get signatures of the identity key
+func TimeSigner() crypto.Signer {
+	return dbSigner{identity: activeIdentity(), key: timeKey}
+}
+
+// signDigest signs the digest and returns the address of the signer
+func signDigest(signer crypto.Signer, digest []byte) (addr util.Address, sig []byte, err error) {
+	sig, err = signer.Sign(digest)
+	if err != nil {
+		return
+	}
+	addr, err = recoverSigner(digest, sig)
+	return
+}
+
+// recoverSigner returns the address that signed the digest
+func recoverSigner(digest []byte, sig []byte) (addr util.Address, err error) {
+	pubkey, err := secp256k1.RecoverPubkey(digest, sig)
+	if err != nil {
+		return
+	}
+	return util.PubkeyToAddress(pubkey), nil
+}
+
+// tlsPrivateKeyPEM returns the private key for the certificate of the TLS connections
+func tlsPrivateKeyPEM(signer crypto.Signer) ([]byte, error) {
+	tlsSigner, ok := signer.(tlsKeySigner)
+	if !ok {
+		return nil, errNoTLSKey
+	}
+	privPEM, err := tlsSigner.TLSPrivateKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := signer.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	tlsPubKey, err := pubKeyFromPEM(privPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pubKey, tlsPubKey) {
+		return nil, errTLSKey
+	}
+	return privPEM, nil
+}
+
+// dbSigner signs with a key of the identity in the database
+type dbSigner struct {
+	identity string
+	key      string
+}
+
+func (s dbSigner) PublicKey() ([]byte, error) {
+	return pubKeyFromPEM(ensureKeyPEM(s.identity, s.key))
+}
+
+func (s dbSigner) Sign(hash []byte) ([]byte, error) {
+	privKey, err := privKeyFromPEM(ensureKeyPEM(s.identity, s.key))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewKeySigner(privKey).Sign(hash)
+}
+
+func (s dbSigner) TLSPrivateKeyPEM() ([]byte, error) {
+	return ensureKeyPEM(s.identity, s.key), nil
+}
+
+func privKeyFromPEM(kd []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(kd)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem private key format")
+	}
+	return crypto.DerToECDSA(block.Bytes)
+}
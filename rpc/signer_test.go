@@ -0,0 +1,63 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/crypto"
+)
+
+// keySigner is an in process signer that also provides the tls key
+type keySigner struct {
+	*crypto.KeySigner
+	tlsKey []byte
+}
+
+func (s keySigner) TLSPrivateKeyPEM() ([]byte, error) {
+	return s.tlsKey, nil
+}
+
+func keyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := crypto.ECDSAToDer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestSignerTLS(t *testing.T) {
+	defer SetSigner(nil)
+	key, err := crypto.HexToECDSA("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+
+	SetSigner(keySigner{crypto.NewKeySigner(key), keyPEM(t, key)})
+	ctx, err := doInitSSLCtx(cfg)
+	if err != nil || ctx == nil {
+		t.Fatalf("tls setup with the signer failed: %v", err)
+	}
+
+	// a signer that doesn't provide the key can't be used for the tls handshake
+	SetSigner(crypto.NewKeySigner(key))
+	if _, err = doInitSSLCtx(cfg); err != errNoTLSKey {
+		t.Fatalf("expected errNoTLSKey but got %v", err)
+	}
+
+	// the tls key has to be the key of the signer
+	other, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigner(keySigner{crypto.NewKeySigner(key), keyPEM(t, other)})
+	if _, err = doInitSSLCtx(cfg); err != errTLSKey {
+		t.Fatalf("expected errTLSKey but got %v", err)
+	}
+}
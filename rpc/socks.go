@@ -5,6 +5,7 @@
 package rpc
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -46,6 +47,11 @@ const (
 	socksRepRefused            = 0x05
 	socksRepTTLExpired         = 0x06
 	stackBufferSize            = 2048
+	// failoverTimeout is how long ports try to reopen through another relay
+	failoverTimeout = 30 * time.Second
+	// connectTimeout is how long resolving a device and opening its port may
+	// take
+	connectTimeout = 30 * time.Second
 )
 
 // Config is Socks Server configuration
@@ -55,8 +61,7 @@ type Config struct {
 	Fallback        string
 	EnableProxy     bool
 	FleetAddr       Address
-	Blocklists      map[Address]bool
-	Allowlists      map[Address]bool
+	AccessLists     *config.AccessLists
 }
 
 // Bind keeps track if existing binds
@@ -78,6 +83,9 @@ type Server struct {
 	closeCh       chan struct{}
 	binds         []Bind
 	cd            sync.Once
+	// ctx is cancelled when the server is closed
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type DeviceError struct {
@@ -284,7 +292,17 @@ func isDiodeHost(host string) bool {
 	return len(subdomainPort) == 4
 }
 
-func (socksServer *Server) doConnectDevice(deviceName string, port int, protocol int, mode string, retry int) (*ConnectedPort, error) {
+func (socksServer *Server) doConnectDevice(ctx context.Context, deviceName string, port int, protocol int, mode string, retry int) (*ConnectedPort, error) {
+	deviceID, client, portOpen, err := socksServer.openDevicePort(ctx, deviceName, port, protocol, mode, nil, retry)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnectedPort(portOpen.Ref, deviceID, client, port), nil
+}
+
+// openDevicePort opens the port through one of the relays of the device, the
+// failed relay is skipped
+func (socksServer *Server) openDevicePort(ctx context.Context, deviceName string, port int, protocol int, mode string, failed *Client, retry int) (Address, *Client, *edge.PortOpen, error) {
 	// Define portname
 	var portName string
 	if protocol == config.UDPProtocol {
@@ -297,12 +315,12 @@ func (socksServer *Server) doConnectDevice(deviceName string, port int, protocol
 
 	// This is double checked in some cases, but it does not hurt since
 	// ResolveDevice internally caches
-	devices, err := socksServer.resolver.ResolveDevice(deviceName)
+	devices, err := socksServer.resolver.ResolveDevice(ctx, deviceName)
 	if err != nil {
 		// Errors are fatal such as 'deviceName' is not an address
 		// or 'deviceName' is not on the allow list. In latter case caching
 		// might delay the time until an allowance reckognized
-		return nil, err
+		return Address{}, nil, nil, err
 	}
 
 	if len(devices) == 0 {
@@ -318,48 +336,107 @@ func (socksServer *Server) doConnectDevice(deviceName string, port int, protocol
 			continue
 		}
 
-		for _, serverID := range device.GetServerIDs() {
+		for _, serverID := range socksServer.preferConnected(device.GetServerIDs()) {
+			if failed != nil && serverID == failed.serverID {
+				continue
+			}
 			var client *Client
-			client, err = socksServer.GetServer(serverID)
+			client, err = socksServer.GetServer(ctx, serverID)
 			if err != nil {
 				socksServer.logger.Error("GetServer() failed: %v", err)
 				continue
 			}
 
 			var portOpen *edge.PortOpen
-			portOpen, err = client.PortOpen(deviceID, portName, mode)
+			portOpen, err = client.PortOpen(ctx, deviceID, portName, mode)
 			if err != nil {
+				if ctx.Err() != nil {
+					return Address{}, nil, nil, err
+				}
 				continue
 			}
 			if portOpen != nil && portOpen.Err != nil {
 				continue
 			}
 			portOpen.PortNumber = port
-			return NewConnectedPort(portOpen.Ref, deviceID, client, port), nil
+			return deviceID, client, portOpen, nil
 		}
 		// If connecting to this device has failed clear the cached
 		// device ticket before trying again
 		socksServer.datapool.SetCacheDevice(deviceID, nil)
 	}
 
-	if retry > 0 {
-		return socksServer.doConnectDevice(deviceName, port, protocol, mode, retry-1)
+	if retry > 0 && ctx.Err() == nil {
+		return socksServer.openDevicePort(ctx, deviceName, port, protocol, mode, failed, retry-1)
 	}
 
 	msg := fmt.Sprintf("doConnectDevice() for '%v' failed: %v", deviceName, err)
 	socksServer.logger.Error(msg)
 	if _, ok := err.(RPCError); ok {
-		return nil, HttpError{404, DeviceError{err}}
+		return Address{}, nil, nil, HttpError{404, DeviceError{err}}
 	}
-	return nil, HttpError{500, fmt.Errorf(msg)}
+	return Address{}, nil, nil, HttpError{500, fmt.Errorf(msg)}
 }
 
-func (socksServer *Server) connectDeviceAndLoop(deviceName string, port int, protocol int, mode string, fn func(*ConnectedPort) (net.Conn, error)) error {
+// preferConnected moves the relays that are connected to the front, dialing a
+// relay that just went away would delay new sessions
+func (socksServer *Server) preferConnected(serverIDs []Address) []Address {
+	connected := make([]Address, 0, len(serverIDs))
+	var other []Address
+	for _, serverID := range serverIDs {
+		if socksServer.clientManager.GetClient(serverID) != nil {
+			connected = append(connected, serverID)
+		} else {
+			other = append(other, serverID)
+		}
+	}
+	return append(connected, other...)
+}
+
+// reopenPort opens the port again after the failed relay went away, the
+// device may need a moment to reconnect when it used the same relay
+func (socksServer *Server) reopenPort(deviceName string, port int, protocol int, mode string, failed *Client) (*Client, string, error) {
+	backoff := newFailoverBackoff()
+	ctx, cancel := context.WithTimeout(socksServer.ctx, failoverTimeout)
+	defer cancel()
+	for {
+		_, client, portOpen, err := socksServer.openDevicePort(ctx, deviceName, port, protocol, mode, failed, 0)
+		if err == nil {
+			return client, portOpen.Ref, nil
+		}
+		if socksServer.Closed() || ctx.Err() != nil {
+			return nil, "", err
+		}
+		select {
+		case <-time.After(backoff.Duration()):
+		case <-ctx.Done():
+			return nil, "", err
+		}
+	}
+}
+
+// newFailoverBackoff returns the backoff between the attempts to reach the
+// device through another relay
+func newFailoverBackoff() *Backoff {
+	return &Backoff{
+		Min:    500 * time.Millisecond,
+		Max:    5 * time.Second,
+		Factor: 2,
+		Jitter: true,
+	}
+}
+
+// connectDeviceAndLoop opens the port of the device with ctx and copies data
+// until the connection is closed, opening the port times out after
+// connectTimeout
+func (socksServer *Server) connectDeviceAndLoop(ctx context.Context, deviceName string, port int, protocol int, mode string, fn func(*ConnectedPort) (net.Conn, error)) error {
 	if protocol == config.TLSProtocol && strings.Contains(mode, "s") {
 		protocol = config.TCPProtocol
 	}
 
-	connPort, err := socksServer.doConnectDevice(deviceName, port, protocol, mode, 1)
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	connPort, err := socksServer.doConnectDevice(ctx, deviceName, port, protocol, mode, 1)
+	cancel()
 	defer connPort.Shutdown()
 	if err != nil {
 		return err
@@ -372,6 +449,13 @@ func (socksServer *Server) connectDeviceAndLoop(deviceName string, port int, pro
 	}
 
 	connPort.Conn = conn
+	if protocol == config.UDPProtocol {
+		// datagrams can continue through another relay, streams can't be
+		// resumed and are closed with their relay
+		connPort.reopen = func(failed *Client) (*Client, string, error) {
+			return socksServer.reopenPort(deviceName, port, protocol, mode, failed)
+		}
+	}
 
 	if protocol == config.TLSProtocol {
 		err := connPort.UpgradeTLSClient()
@@ -446,7 +530,7 @@ func (socksServer *Server) pipeFallback(conn net.Conn, ver int, host string) {
 	tunnel.Copy()
 }
 
-func (socksServer *Server) pipeSocksThenClose(conn net.Conn, ver int, devices []*edge.DeviceTicket, port int, mode string) {
+func (socksServer *Server) pipeSocksThenClose(ctx context.Context, conn net.Conn, ver int, devices []*edge.DeviceTicket, port int, mode string) {
 	defer func() {
 		if err := recover(); err != nil {
 			buf := make([]byte, stackBufferSize)
@@ -460,7 +544,7 @@ func (socksServer *Server) pipeSocksThenClose(conn net.Conn, ver int, devices []
 
 	for _, device := range devices {
 		deviceID = device.GetDeviceID()
-		err = socksServer.connectDeviceAndLoop(deviceID, port, config.TLSProtocol, mode, func(connPort *ConnectedPort) (net.Conn, error) {
+		err = socksServer.connectDeviceAndLoop(ctx, deviceID, port, config.TLSProtocol, mode, func(connPort *ConnectedPort) (net.Conn, error) {
 			writeSocksReturn(conn, ver, connPort.ClientLocalAddr(), port)
 			return conn, nil
 		})
@@ -551,6 +635,8 @@ func parseHost(host string) (isWS bool, mode string, deviceID string, port int,
 
 func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 	defer conn.Close()
+	ctx, cancel := context.WithCancel(socksServer.ctx)
+	defer cancel()
 
 	ver, host, err := handshake(conn)
 	if err != nil {
@@ -582,7 +668,7 @@ func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 		socksServer.logger.Error("Failed to parse host %v", err)
 		return
 	}
-	devices, httpErr := socksServer.resolver.ResolveDevice(deviceID)
+	devices, httpErr := socksServer.resolver.ResolveDevice(ctx, deviceID)
 	if len(devices) == 0 {
 		if httpErr == nil {
 			socksServer.logger.Error("Failed to ResolveDevice - Device offline")
@@ -593,7 +679,7 @@ func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 		return
 	}
 	if !isWS {
-		socksServer.pipeSocksThenClose(conn, ver, devices, port, mode)
+		socksServer.pipeSocksThenClose(ctx, conn, ver, devices, port, mode)
 	} else {
 		socksServer.logger.Error("Couldn't forward socks connection")
 		writeSocksError(conn, ver, socksRepNotAllowed)
@@ -744,7 +830,7 @@ func (socksServer *Server) forwardUDP(addr net.Addr, deviceName string, port int
 		return
 	}
 
-	err = socksServer.connectDeviceAndLoop(deviceName, port, config.UDPProtocol, mode, func(connPort2 *ConnectedPort) (net.Conn, error) {
+	err = socksServer.connectDeviceAndLoop(socksServer.ctx, deviceName, port, config.UDPProtocol, mode, func(connPort2 *ConnectedPort) (net.Conn, error) {
 		err := connPort2.SendRemote(data)
 		if err != nil {
 			socksServer.logger.Error("forwardUDP error: PortSend(): %v", err)
@@ -872,8 +958,12 @@ func (socksServer *Server) startBind(bind *Bind) error {
 	return nil
 }
 
+// handleBind tunnels a connection of the bind to the device, the connection
+// is closed with its tunnel so that the application reconnects when the
+// relay went away, new connections of the bind use another relay
 func (socksServer *Server) handleBind(conn net.Conn, bind config.Bind) {
-	err := socksServer.connectDeviceAndLoop(bind.To, bind.ToPort, bind.Protocol, "rw", func(*ConnectedPort) (net.Conn, error) {
+	defer conn.Close()
+	err := socksServer.connectDeviceAndLoop(socksServer.ctx, bind.To, bind.ToPort, bind.Protocol, "rw", func(*ConnectedPort) (net.Conn, error) {
 		return conn, nil
 	})
 
@@ -892,6 +982,7 @@ func NewSocksServer(socksCfg Config, clientManager *ClientManager) (*Server, err
 		closeCh:       make(chan struct{}),
 		binds:         make([]Bind, 0),
 	}
+	socksServer.ctx, socksServer.cancel = context.WithCancel(context.Background())
 	if err := socksServer.SetConfig(socksCfg); err != nil {
 		return nil, err
 	}
@@ -909,8 +1000,8 @@ func (socksServer *Server) SetConfig(config Config) error {
 }
 
 // GetServer gets or creates a new SSL connection to the given server
-func (socksServer *Server) GetServer(nodeID Address) (client *Client, err error) {
-	return socksServer.clientManager.GetClientorConnect(nodeID)
+func (socksServer *Server) GetServer(ctx context.Context, nodeID Address) (client *Client, err error) {
+	return socksServer.clientManager.GetClientorConnect(ctx, nodeID)
 }
 
 // Closed returns whether socks server had closed
@@ -922,6 +1013,7 @@ func (socksServer *Server) Closed() bool {
 func (socksServer *Server) Close() {
 	socksServer.cd.Do(func() {
 		close(socksServer.closeCh)
+		socksServer.cancel()
 		if socksServer.listener != nil {
 			socksServer.listener.Close()
 			socksServer.listener = nil
@@ -4,7 +4,6 @@
 package rpc
 
 import (
-	"crypto/ecdsa"
 	"encoding/binary"
 	"encoding/pem"
 	"fmt"
@@ -30,7 +29,6 @@ type SSL struct {
 	totalConnections uint64
 	totalBytes       uint64
 	counter          uint64
-	clientPrivKey    *ecdsa.PrivateKey
 	rm               sync.RWMutex
 	cd               sync.Once
 	closeCh          chan struct{}
@@ -163,39 +161,31 @@ func getConnectionPubkey(conn *openssl.Conn) ([]byte, error) {
 	return crypto.DerToPublicKey(derPubKey)
 }
 
-// GetClientPrivateKey returns client private key
-// It can fetch from openssl, the way is like GetServerPublicKey doing:
-// 1. get key from openssl
-// 2. change format from openssl key to DER
-// 3. change format from DER to ecdsa
-// Maybe compare both
-func (s *SSL) GetClientPrivateKey() (*ecdsa.PrivateKey, error) {
-	if s.clientPrivKey != nil {
-		return s.clientPrivKey, nil
-	}
-	kd := EnsurePrivatePEM()
-	block, _ := pem.Decode(kd)
-	if block == nil {
-		return nil, fmt.Errorf("invalid pem private key format")
-	}
-	clientPrivKey, err := crypto.DerToECDSA(block.Bytes)
+// LoadClientPubKey loads the public key of the active identity from the signer
+func LoadClientPubKey() []byte {
+	clientPubKey, err := ClientSigner().PublicKey()
+	if err != nil {
+		return []byte{}
+	}
+	return clientPubKey
+}
+
+// LoadIdentityPubKey loads the public key of the given identity, other than
+// LoadClientPubKey it never creates a new key
+func LoadIdentityPubKey(identity string) ([]byte, error) {
+	kd, err := db.DB.GetSecret(db.IdentityKey(identity, "private"))
 	if err != nil {
 		return nil, err
 	}
-	s.clientPrivKey = clientPrivKey
-	return clientPrivKey, nil
+	return pubKeyFromPEM(kd)
 }
 
-// LoadClientPubKey loads the clients public key from the database
-func LoadClientPubKey() []byte {
-	kd := EnsurePrivatePEM()
-	block, _ := pem.Decode(kd)
-	privKey, err := crypto.DerToECDSA(block.Bytes)
+func pubKeyFromPEM(kd []byte) ([]byte, error) {
+	privKey, err := privKeyFromPEM(kd)
 	if err != nil {
-		return []byte{}
+		return nil, err
 	}
-	clientPubKey := crypto.MarshalPubkey(&privKey.PublicKey)
-	return clientPubKey
+	return crypto.MarshalPubkey(&privKey.PublicKey), nil
 }
 
 func (s *SSL) setTotalBytes(n uint64) {
@@ -276,9 +266,37 @@ func (s *SSL) write(buf []byte) (n int, err error) {
 	return
 }
 
+// generatedKeys are the identities whose key was generated by this process
+var generatedKeys sync.Map
+
+// IsGeneratedKey returns true when the key of the active identity was
+// generated by this process, so it wasn't used yet and can be replaced
+func IsGeneratedKey() bool {
+	_, ok := generatedKeys.Load(activeIdentity())
+	return ok
+}
+
+// EnsurePrivatePEM returns the private key of the active identity, the key is
+// generated when the identity has none yet
 func EnsurePrivatePEM() []byte {
-	key, _ := db.DB.Get("private")
+	return ensurePrivatePEM(activeIdentity())
+}
+
+func ensurePrivatePEM(identity string) []byte {
+	return ensureKeyPEM(identity, privateKey)
+}
+
+// ensureKeyPEM returns the key of the identity that is stored under name, the
+// key is generated when the identity has none yet
+func ensureKeyPEM(identity string, name string) []byte {
+	key, err := db.DB.GetSecret(db.IdentityKey(identity, name))
+	if err != nil && err != db.ErrKeyNotFound {
+		// Never generate a new identity when the existing one can't be read
+		config.AppConfig.Logger.Error("Failed to load ec key: %v", err)
+		os.Exit(129)
+	}
 	if key == nil {
+		config.AppConfig.Logger.Info("No %s key found, generating a new key for identity %s", name, identity)
 		privKey, err := openssl.GenerateECKey(openssl.Secp256k1)
 		if err != nil {
 			config.AppConfig.Logger.Error("Failed to generate ec key: %v", err)
@@ -289,13 +307,20 @@ func EnsurePrivatePEM() []byte {
 			config.AppConfig.Logger.Error("Failed to marshal ec key: %v", err)
 			os.Exit(129)
 		}
-		err = db.DB.Put("private", bytes)
+		err = db.DB.PutSecret(db.IdentityKey(identity, name), bytes)
 		if err != nil {
 			config.AppConfig.Logger.Error("Failed to save ec key to file: %v", err)
 			os.Exit(129)
 		}
+		if name == privateKey {
+			generatedKeys.Store(identity, true)
+		}
 		return bytes
 	}
+	if block, _ := pem.Decode(key); block == nil {
+		config.AppConfig.Logger.Error("Failed to load ec key: invalid pem private key format, run 'diode config -verify'")
+		os.Exit(129)
+	}
 	return key
 }
 
@@ -326,7 +351,10 @@ func doInitSSLCtx(config *config.Config) (*openssl.Ctx, error) {
 		Organization: "Private",
 		CommonName:   name,
 	}
-	privPEM := EnsurePrivatePEM()
+	privPEM, err := tlsPrivateKeyPEM(signerOf(config))
+	if err != nil {
+		return nil, err
+	}
 	key, err := openssl.LoadPrivateKeyFromPEM(privPEM)
 	if err != nil {
 		return nil, err
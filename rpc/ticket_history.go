@@ -0,0 +1,122 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/edge"
+	"github.com/diodechain/diode_client/util"
+)
+
+const (
+	// each ticket is stored in its own tickets/<seq> key, ticketSeqKey is
+	// the sequence number of the next ticket
+	ticketHistoryKey = "tickets"
+	ticketSeqKey     = "ticketseq"
+	// only the ticketHistorySize latest tickets are kept
+	ticketHistorySize = 1000
+)
+
+var ticketHistoryMx sync.Mutex
+
+// TicketRecord is a ticket that the client signed, it's kept to reconcile
+// the billing of the relays
+type TicketRecord struct {
+	Time             time.Time `json:"time"`
+	Reason           string    `json:"reason"`
+	Relay            string    `json:"relay"`
+	ServerID         string    `json:"server_id"`
+	BlockNumber      uint64    `json:"block_number"`
+	FleetAddr        string    `json:"fleet"`
+	TotalConnections uint64    `json:"total_connections"`
+	TotalBytes       uint64    `json:"total_bytes"`
+	LocalAddr        string    `json:"local_addr,omitempty"`
+	DeviceSig        string    `json:"device_sig"`
+}
+
+// TicketHistory returns the tickets that the active identity signed, the
+// oldest first
+func TicketHistory() (records []TicketRecord, err error) {
+	ticketHistoryMx.Lock()
+	defer ticketHistoryMx.Unlock()
+	identity := activeIdentity()
+	next := loadTicketSeq(identity)
+	seq := uint64(0)
+	if next > ticketHistorySize {
+		seq = next - ticketHistorySize
+	}
+	for ; seq < next; seq++ {
+		data, err := db.DB.Get(ticketKey(identity, seq))
+		if err != nil {
+			// the record was not written
+			continue
+		}
+		var record TicketRecord
+		if err = json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+func ticketKey(identity string, seq uint64) string {
+	return db.IdentityKey(identity, fmt.Sprintf("%s/%d", ticketHistoryKey, seq))
+}
+
+// IsTicketKey returns true for the database keys of the ticket history
+func IsTicketKey(key string) bool {
+	return strings.HasPrefix(key, ticketHistoryKey+"/") || strings.Contains(key, "/"+ticketHistoryKey+"/")
+}
+
+func loadTicketSeq(identity string) uint64 {
+	data, err := db.DB.Get(db.IdentityKey(identity, ticketSeqKey))
+	if err != nil {
+		// nothing was signed yet
+		return 0
+	}
+	return util.DecodeBytesToUint(data)
+}
+
+// recordTicket adds a ticket that the identity signed to its history and
+// removes the oldest ticket when the history is full
+func recordTicket(identity string, relay string, reason string, ticket *edge.DeviceTicket) error {
+	if db.DB == nil {
+		return nil
+	}
+	ticketHistoryMx.Lock()
+	defer ticketHistoryMx.Unlock()
+	data, err := json.Marshal(TicketRecord{
+		Time:             time.Now(),
+		Reason:           reason,
+		Relay:            relay,
+		ServerID:         ticket.ServerID.HexString(),
+		BlockNumber:      ticket.BlockNumber,
+		FleetAddr:        ticket.FleetAddr.HexString(),
+		TotalConnections: ticket.TotalConnections,
+		TotalBytes:       ticket.TotalBytes,
+		LocalAddr:        util.EncodeToString(ticket.LocalAddr),
+		DeviceSig:        util.EncodeToString(ticket.DeviceSig),
+	})
+	if err != nil {
+		return err
+	}
+	seq := loadTicketSeq(identity)
+	if err = db.DB.Put(ticketKey(identity, seq), data); err != nil {
+		return err
+	}
+	if err = db.DB.Put(db.IdentityKey(identity, ticketSeqKey), util.DecodeUintToBytes(seq+1)); err != nil {
+		return err
+	}
+	if seq >= ticketHistorySize {
+		return db.DB.Del(ticketKey(identity, seq-ticketHistorySize))
+	}
+	return nil
+}
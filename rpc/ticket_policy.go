@@ -0,0 +1,107 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+)
+
+// Ticket signing modes
+const (
+	// EagerTicketMode signs a new ticket as soon as a threshold of the
+	// policy is reached and when the primary relay changes
+	EagerTicketMode = "eager"
+	// LazyTicketMode signs a new ticket only when the unsent usage reaches
+	// the cap or the last ticket got too old
+	LazyTicketMode = "lazy"
+)
+
+// Reasons to sign a ticket, they are kept in the ticket history
+const (
+	ticketReasonConnect     = "connect"
+	ticketReasonPrimary     = "primary"
+	ticketReasonBytes       = "bytes"
+	ticketReasonConnections = "connections"
+	ticketReasonInterval    = "interval"
+	ticketReasonCap         = "cap"
+	ticketReasonStale       = "stale"
+	ticketReasonResubmit    = "resubmit"
+)
+
+// ValidTicketMode returns an error when name is not a ticket signing mode
+func ValidTicketMode(name string) error {
+	switch name {
+	case EagerTicketMode, LazyTicketMode:
+		return nil
+	}
+	return fmt.Errorf("unknown ticket mode %s, expected %s or %s", name, EagerTicketMode, LazyTicketMode)
+}
+
+// TicketPolicy decides when the client signs a new ticket for the usage of
+// a relay, a zero threshold is disabled
+type TicketPolicy struct {
+	// Bytes is the traffic after which a new ticket is signed
+	Bytes uint64
+	// Connections is the number of connections after which a new ticket is
+	// signed
+	Connections uint64
+	// Interval is the time after which the unsent usage is signed
+	Interval time.Duration
+	// Lazy ignores the thresholds above and signs only at MaxUnsent
+	Lazy bool
+	// MaxUnsent is the traffic that is never exceeded without a new ticket
+	MaxUnsent uint64
+}
+
+// ticketUsage is the usage of a relay since the last ticket
+type ticketUsage struct {
+	bytes       uint64
+	connections uint64
+	elapsed     time.Duration
+}
+
+// NewTicketPolicy returns the ticket policy of the config
+func NewTicketPolicy(cfg *config.Config) *TicketPolicy {
+	p := &TicketPolicy{
+		Bytes:       uint64(cfg.TicketBytes),
+		Connections: uint64(cfg.TicketConnections),
+		Interval:    cfg.TicketInterval,
+		Lazy:        cfg.TicketMode == LazyTicketMode,
+		MaxUnsent:   uint64(cfg.TicketMaxUnsent),
+	}
+	if cfg.TicketBytes <= 0 {
+		p.Bytes = ticketBound
+	}
+	return p
+}
+
+// due returns the reason to sign a new ticket for the usage, or an empty
+// string when no ticket is needed yet
+func (p *TicketPolicy) due(usage ticketUsage) string {
+	if p.MaxUnsent > 0 && usage.bytes >= p.MaxUnsent {
+		return ticketReasonCap
+	}
+	if p.Lazy {
+		return ""
+	}
+	if p.Bytes > 0 && usage.bytes >= p.Bytes {
+		return ticketReasonBytes
+	}
+	if p.Connections > 0 && usage.connections >= p.Connections {
+		return ticketReasonConnections
+	}
+	if p.Interval > 0 && usage.elapsed >= p.Interval && (usage.bytes > 0 || usage.connections > 0) {
+		return ticketReasonInterval
+	}
+	return ""
+}
+
+// signOnPrimaryChange returns true when a new ticket is signed for the new
+// primary and secondary relay
+func (p *TicketPolicy) signOnPrimaryChange() bool {
+	return !p.Lazy
+}
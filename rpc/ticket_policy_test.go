@@ -0,0 +1,117 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_client/config"
+	"github.com/diodechain/diode_client/db"
+	"github.com/diodechain/diode_client/edge"
+)
+
+func TestTicketPolicy(t *testing.T) {
+	cfg := &config.Config{
+		TicketConnections: 10,
+		TicketInterval:    time.Minute,
+		TicketMaxUnsent:   4 * ticketBound,
+	}
+	eager := NewTicketPolicy(cfg)
+	tests := []struct {
+		usage  ticketUsage
+		reason string
+	}{
+		{ticketUsage{bytes: 1024, connections: 1, elapsed: time.Second}, ""},
+		{ticketUsage{bytes: ticketBound}, ticketReasonBytes},
+		{ticketUsage{connections: 10}, ticketReasonConnections},
+		{ticketUsage{bytes: 1, elapsed: time.Minute}, ticketReasonInterval},
+		// nothing to sign
+		{ticketUsage{elapsed: time.Hour}, ""},
+		{ticketUsage{bytes: 4 * ticketBound}, ticketReasonCap},
+	}
+	for _, test := range tests {
+		if reason := eager.due(test.usage); reason != test.reason {
+			t.Fatalf("expected %+v to be due for %q but got %q", test.usage, test.reason, reason)
+		}
+	}
+	if !eager.signOnPrimaryChange() {
+		t.Fatalf("expected the eager policy to sign when the primary relay changes")
+	}
+
+	cfg.TicketMode = LazyTicketMode
+	lazy := NewTicketPolicy(cfg)
+	if reason := lazy.due(ticketUsage{bytes: 2 * ticketBound, connections: 100, elapsed: time.Hour}); reason != "" {
+		t.Fatalf("expected the lazy policy to wait for the cap but got %q", reason)
+	}
+	if reason := lazy.due(ticketUsage{bytes: 4 * ticketBound}); reason != ticketReasonCap {
+		t.Fatalf("expected the lazy policy to sign at the cap but got %q", reason)
+	}
+	if lazy.signOnPrimaryChange() {
+		t.Fatalf("expected the lazy policy not to sign when the primary relay changes")
+	}
+}
+
+// withTestDB gives the test an empty database and a default config, both
+// are closed and restored when the test ends
+func withTestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diode_rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldConfig := db.DB, config.AppConfig
+	t.Cleanup(func() {
+		db.DB.Close()
+		db.DB, config.AppConfig = oldDB, oldConfig
+		os.RemoveAll(dir)
+	})
+	config.AppConfig = &config.Config{}
+	db.DB, err = db.OpenFile(filepath.Join(dir, "private.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTicketHistory(t *testing.T) {
+	withTestDB(t)
+
+	if records, err := TicketHistory(); err != nil || len(records) != 0 {
+		t.Fatalf("expected an empty history but got %v %v", records, err)
+	}
+	for i := 0; i < ticketHistorySize+1; i++ {
+		ticket := &edge.DeviceTicket{
+			BlockNumber: uint64(i),
+			TotalBytes:  uint64(i * 1024),
+			DeviceSig:   []byte{1, 2, 3},
+		}
+		if err := recordTicket(activeIdentity(), "relay:41046", ticketReasonBytes, ticket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := TicketHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != ticketHistorySize || records[0].BlockNumber != 1 {
+		t.Fatalf("expected the %d latest tickets but got %d from block %d", ticketHistorySize, len(records), records[0].BlockNumber)
+	}
+	last := records[len(records)-1]
+	if last.Relay != "relay:41046" || last.Reason != ticketReasonBytes || last.TotalBytes != ticketHistorySize*1024 || last.DeviceSig != "0x010203" {
+		t.Fatalf("ticket wasn't recorded: %+v", last)
+	}
+	// each ticket is kept in its own key and the oldest one was removed
+	keys := 0
+	for _, key := range db.DB.List() {
+		if strings.Contains(key, ticketHistoryKey+"/") {
+			keys++
+		}
+	}
+	if keys != ticketHistorySize {
+		t.Fatalf("expected %d ticket keys but got %d", ticketHistorySize, keys)
+	}
+}
@@ -5,11 +5,13 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/config"
 	"github.com/diodechain/diode_client/crypto"
 	"github.com/diodechain/diode_client/db"
 	"github.com/diodechain/diode_client/edge"
@@ -22,6 +24,10 @@ const (
 	rpcCallRetryTimes = 2
 	lvbnKey           = "lvbn3"
 	lvbhKey           = "lvbh3"
+	// privateKey is the key of the identity, timeKey signs the served
+	// consensus time
+	privateKey = "private"
+	timeKey    = "time_private"
 )
 
 var (
@@ -35,6 +41,7 @@ type Call struct {
 	method   string
 	state    Signal
 	response chan interface{}
+	done     chan struct{}
 	data     *bytes.Buffer
 	Parse    func(buffer []byte) (interface{}, error)
 	cd       sync.Once
@@ -48,17 +55,19 @@ func (c *Call) enqueueResponse(msg interface{}) error {
 	select {
 	case c.response <- msg:
 		return nil
+	case <-c.done:
+		return fmt.Errorf("call was cancelled")
 	case <-timer.C:
 		return fmt.Errorf("send response to channel timeout")
 	}
 }
 
-// Clean the call
+// Clean the call, no response is sent after
 func (c *Call) Clean(state Signal) {
 	c.cd.Do(func() {
 		c.state = state
-		if c.response != nil {
-			close(c.response)
+		if c.done != nil {
+			close(c.done)
 		}
 	})
 }
@@ -75,15 +84,29 @@ func (e TimeoutError) Error() string {
 	return fmt.Sprintf("remote timeout: %s", e.Timeout)
 }
 
+// Unwrap returns context.DeadlineExceeded, so TimeoutError matches it with
+// errors.Is
+func (e TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
 // CancelledError is struct for cancelled error
 type CancelledError struct {
 	Host string
+	// Err is the error of the context that cancelled the call, it's nil when
+	// the client was closed
+	Err error
 }
 
 func (e CancelledError) Error() string {
 	return "rpc call has been cancelled"
 }
 
+// Unwrap returns the error of the context that cancelled the call
+func (e CancelledError) Unwrap() error {
+	return e.Err
+}
+
 // RPCError is struct for rpc error
 type RPCError struct {
 	Err edge.Error
@@ -100,32 +123,78 @@ func WindowSize() int {
 
 // LastValid returns the last valid block number and block header
 func (client *Client) LastValid() (uint64, crypto.Sha3) {
-	var bq *blockquick.Window
-	client.callTimeout(func() { bq = client.bq })
+	bq := client.validWindow()
 	if bq == nil {
-		return restoreLastValid()
+		return restoreLastValid(client.identity())
 	}
 	return bq.Last()
 
 }
 
-func restoreLastValid() (uint64, crypto.Sha3) {
-	lvbn, err := db.DB.Get(lvbnKey)
+// validWindow returns the blockquick window of the client, it's nil until
+// the network was validated
+func (client *Client) validWindow() (bq *blockquick.Window) {
+	client.callTimeout(func() { bq = client.bq })
+	return
+}
+
+// activeIdentity returns the name of the identity selected with -identity
+func activeIdentity() string {
+	return configIdentity(config.AppConfig)
+}
+
+// configIdentity returns the name of the identity of the config
+func configIdentity(cfg *config.Config) string {
+	if len(cfg.Identity) == 0 {
+		return db.DefaultIdentity
+	}
+	return cfg.Identity
+}
+
+// identity returns the name of the identity that the client connects with,
+// the key and the state of the client are stored under this identity
+func (client *Client) identity() string {
+	return configIdentity(client.config)
+}
+
+// identityKey returns the database key of the active identity
+func identityKey(key string) string {
+	return db.IdentityKey(activeIdentity(), key)
+}
+
+func restoreLastValid(identity string) (uint64, crypto.Sha3) {
+	lvbn, err := db.DB.Get(db.IdentityKey(identity, lvbnKey))
 	var lvbh []byte
 	if err == nil {
 		lvbnNum := util.DecodeBytesToUint(lvbn)
-		lvbh, err = db.DB.Get(lvbhKey)
+		lvbh, err = db.DB.Get(db.IdentityKey(identity, lvbhKey))
 		if err == nil {
 			var hash [32]byte
 			copy(hash[:], lvbh)
 			return lvbnNum, hash
 		}
 	}
+	if lvbn, lvbh, ok := pinnedCheckpoint(identity); ok {
+		return lvbn, lvbh
+	}
 	return 500, [32]byte{0, 0, 91, 137, 111, 20, 109, 80, 251, 76, 143, 80, 134, 152, 142, 201, 98, 250, 205, 7, 108, 135, 20, 235, 135, 65, 44, 186, 4, 161, 71, 238}
 }
 
 func (client *Client) storeLastValid() {
 	lvbn, lvbh := client.LastValid()
-	db.DB.Put(lvbnKey, util.DecodeUintToBytes(lvbn))
-	db.DB.Put(lvbhKey, lvbh[:])
+	setLastValid(client.identity(), lvbn, lvbh)
+	if bq := client.validWindow(); bq != nil {
+		storeWindowCache(client.identity(), bq)
+	}
+}
+
+// SetLastValid sets the trusted block that the blockquick validation of the
+// active identity starts from
+func SetLastValid(lvbn uint64, lvbh crypto.Sha3) {
+	setLastValid(activeIdentity(), lvbn, lvbh)
+}
+
+func setLastValid(identity string, lvbn uint64, lvbh crypto.Sha3) {
+	db.DB.Put(db.IdentityKey(identity, lvbnKey), util.DecodeUintToBytes(lvbn))
+	db.DB.Put(db.IdentityKey(identity, lvbhKey), lvbh[:])
 }
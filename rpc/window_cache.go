@@ -0,0 +1,128 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/db"
+)
+
+const windowCacheKey = "window"
+
+var (
+	windowCacheMx sync.Mutex
+	// windowCacheLast is the number of the latest cached header of each
+	// identity, so that the window is only written again after it moved by
+	// half of its size
+	windowCacheLast = map[string]uint64{}
+)
+
+// loadWindowCache returns the cached headers that end at lvbn with the hash
+// lvbh, the headers are linked by their parent hashes, so they are as
+// trusted as lvbh. Headers before lvbn are returned when lvbn is not cached
+// yet, they are checked against lvbh once the missing headers are fetched.
+func loadWindowCache(identity string, lvbn uint64, lvbh crypto.Sha3) []blockquick.BlockHeader {
+	data, err := db.DB.Get(db.IdentityKey(identity, windowCacheKey))
+	if err != nil {
+		return nil
+	}
+	var bhs []blockquick.BlockHeader
+	if err = json.Unmarshal(data, &bhs); err != nil || len(bhs) == 0 {
+		return nil
+	}
+	for i := 1; i < len(bhs); i++ {
+		if bhs[i].Number() != bhs[i-1].Number()+1 || bhs[i].Parent() != bhs[i-1].Hash() {
+			return nil
+		}
+	}
+	for i, bh := range bhs {
+		if bh.Number() == lvbn {
+			if bh.Hash() != lvbh {
+				// the cache is from another chain than the trusted block
+				return nil
+			}
+			return bhs[:i+1]
+		}
+	}
+	if bhs[len(bhs)-1].Number() > lvbn {
+		return nil
+	}
+	return bhs
+}
+
+// storeWindowCache writes the headers of the window when the latest header
+// moved by at least half a window since the last write
+func storeWindowCache(identity string, win *blockquick.Window) {
+	bhs := win.Headers()
+	if len(bhs) == 0 {
+		return
+	}
+	windowCacheMx.Lock()
+	defer windowCacheMx.Unlock()
+	if bhs[len(bhs)-1].Number() < windowCacheLast[identity]+windowSize/2 {
+		return
+	}
+	writeWindowCache(identity, bhs)
+}
+
+// storeWindowHeaders replaces the cached window with the headers
+func storeWindowHeaders(bhs []blockquick.BlockHeader) error {
+	windowCacheMx.Lock()
+	defer windowCacheMx.Unlock()
+	return writeWindowCache(activeIdentity(), bhs)
+}
+
+func writeWindowCache(identity string, bhs []blockquick.BlockHeader) error {
+	data, err := json.Marshal(bhs)
+	if err != nil {
+		return err
+	}
+	if err = db.DB.Put(db.IdentityKey(identity, windowCacheKey), data); err != nil {
+		return err
+	}
+	windowCacheLast[identity] = bhs[len(bhs)-1].Number()
+	return nil
+}
+
+// windowHeaders returns the windowSize headers that end at lvbn, only the
+// headers that are not cached are fetched from the relay
+func (client *Client) windowHeaders(ctx context.Context, lvbn uint64, lvbh crypto.Sha3) ([]blockquick.BlockHeader, error) {
+	blockNumMin := lvbn - windowSize + 1
+	headers := make([]blockquick.BlockHeader, 0, windowSize)
+	for _, bh := range loadWindowCache(client.identity(), lvbn, lvbh) {
+		if bh.Number() >= blockNumMin {
+			headers = append(headers, bh)
+		}
+	}
+	next := blockNumMin
+	if len(headers) > 0 {
+		next = headers[len(headers)-1].Number() + 1
+		if headers[0].Number() != blockNumMin {
+			// the cache is too old to be extended
+			headers = headers[:0]
+			next = blockNumMin
+		}
+	}
+	if next > lvbn {
+		return headers, nil
+	}
+	client.Log().Debug("Fetching blocks %v-%v, %d blocks were cached", next, lvbn, len(headers))
+	fetched, err := client.GetBlockHeadersUnsafe(ctx, next, lvbn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch blocks %v-%v error: %v", next, lvbn, err)
+	}
+	if len(headers) > 0 && len(fetched) > 0 && fetched[0].Parent() != headers[len(headers)-1].Hash() {
+		// the cache doesn't belong to the chain of lvbn, e.g. after the
+		// trusted block was replaced, so the whole window is fetched
+		client.Log().Debug("Cached blocks don't match block %v, fetching all blocks", next)
+		return client.GetBlockHeadersUnsafe(ctx, blockNumMin, lvbn)
+	}
+	return append(headers, fetched...), nil
+}
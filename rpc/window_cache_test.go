@@ -0,0 +1,86 @@
+// Diode Network Client
+// Copyright 2021 Diode
+// Licensed under the Diode License, Version 1.1
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"testing"
+
+	"github.com/diodechain/diode_client/blockquick"
+	"github.com/diodechain/diode_client/crypto"
+	"github.com/diodechain/diode_client/util"
+	bert "github.com/diodechain/gobert"
+)
+
+// testHeaders returns n linked block headers from block number 1
+func testHeaders(t *testing.T, n int) []blockquick.BlockHeader {
+	return mineTestHeaders(t, testMiner(t), util.EmptyBytes(32), 1, n)
+}
+
+func testMiner(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// mineTestHeaders returns n linked block headers of the miner key, the first
+// one has the number from and the parent prev
+func mineTestHeaders(t *testing.T, key *ecdsa.PrivateKey, prev []byte, from uint64, n int) []blockquick.BlockHeader {
+	signer := crypto.NewKeySigner(key)
+	pubkey := crypto.MarshalPubkey(&key.PublicKey)
+	bhs := make([]blockquick.BlockHeader, 0, n)
+	for i := from; i < from+uint64(n); i++ {
+		txHash, stateHash := util.EmptyBytes(32), util.EmptyBytes(32)
+		enc, err := bert.Encode([6]bert.Term{prev, stateHash, txHash, i, i, uint64(0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := signer.Sign(crypto.Sha256(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bh, err := blockquick.NewHeader(txHash, stateHash, prev, sig, pubkey, i, i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := bh.Hash()
+		prev = hash[:]
+		bhs = append(bhs, bh)
+	}
+	return bhs
+}
+
+func TestWindowCache(t *testing.T) {
+	withTestDB(t)
+	windowCacheLast = map[string]uint64{}
+
+	bhs := testHeaders(t, windowSize)
+	win, err := blockquick.New(bhs, windowSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeWindowCache(activeIdentity(), win)
+
+	last := bhs[windowSize-1]
+	if cached := loadWindowCache(activeIdentity(), last.Number(), last.Hash()); len(cached) != windowSize || cached[windowSize-1].Hash() != last.Hash() {
+		t.Fatalf("expected the whole window to be cached but got %d headers", len(cached))
+	}
+	if cached := loadWindowCache(activeIdentity(), last.Number(), crypto.Sha3{1}); cached != nil {
+		t.Fatalf("expected the cache of another chain to be dropped")
+	}
+	middle := bhs[49]
+	if cached := loadWindowCache(activeIdentity(), middle.Number(), middle.Hash()); len(cached) != 50 {
+		t.Fatalf("expected the headers up to the trusted block but got %d", len(cached))
+	}
+	// the headers after the cache are fetched and checked against lvbh
+	if cached := loadWindowCache(activeIdentity(), last.Number()+10, crypto.Sha3{1}); len(cached) != windowSize {
+		t.Fatalf("expected the cache to be extended but got %d headers", len(cached))
+	}
+	if cached := loadWindowCache(activeIdentity(), 0, crypto.Sha3{}); cached != nil {
+		t.Fatalf("expected no headers before the first block but got %d", len(cached))
+	}
+}